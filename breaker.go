@@ -0,0 +1,244 @@
+package ormx
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cloudfly/flagx"
+	"github.com/go-sql-driver/mysql"
+)
+
+var (
+	limitMaster      = flagx.NewInt("database.limit.concurrency.master", 0, "the maximum number of concurrent queries on the master of each data source, the others wait in queue; 0 means unlimited")
+	limitSlave       = flagx.NewInt("database.limit.concurrency.slave", 0, "the maximum number of concurrent queries on the slaves of each data source, the others wait in queue; 0 means unlimited")
+	limitQueue       = flagx.NewDuration("database.limit.queue.timeout", "1s", "the maximum time a query waits in queue before failing with ErrConcurrencyLimit")
+	breakerErrorRate = flagx.NewFloat("database.breaker.errorrate", 0, "the ratio of failed queries in the window opening the circuit breaker of the master or slaves of a data source; 0 disables it")
+	breakerLatency   = flagx.NewDuration("database.breaker.latency", "0", "the average latency of queries in the window opening the circuit breaker; 0 disables it")
+	breakerWindow    = flagx.NewDuration("database.breaker.window", "10s", "the window the circuit breaker counts the queries in")
+	breakerRequests  = flagx.NewInt("database.breaker.requests", 20, "the minimum number of queries in the window before the circuit breaker opens")
+	breakerCooldown  = flagx.NewDuration("database.breaker.cooldown", "5s", "the time the circuit breaker stays open before letting a probe query through")
+
+	gates sync.Map // gateKey => *gate
+)
+
+// Limits is the admission control of the master or slaves of a data source
+type Limits struct {
+	// MaxConcurrent is the maximum number of concurrent queries, 0 means unlimited.
+	// The queries exceeding it wait in queue for QueueTimeout, then fail with ErrConcurrencyLimit.
+	MaxConcurrent int
+	QueueTimeout  time.Duration
+	// The circuit breaker opens if the ratio of failures reaches ErrorRate, or the average latency reaches Latency,
+	// when there are MinRequests queries at least in the Window. The queries fail fast with ErrCircuitOpen while it's open,
+	// a probe query is let through after Cooldown, and the circuit closes if the probe succeeds.
+	ErrorRate   float64
+	Latency     time.Duration
+	Window      time.Duration
+	MinRequests int
+	Cooldown    time.Duration
+}
+
+// defaultLimits return the limits configured by flags
+func defaultLimits(master bool) Limits {
+	l := Limits{
+		MaxConcurrent: *limitSlave,
+		QueueTimeout:  time.Duration(limitQueue.Msecs) * time.Millisecond,
+		ErrorRate:     *breakerErrorRate,
+		Latency:       time.Duration(breakerLatency.Msecs) * time.Millisecond,
+		Window:        time.Duration(breakerWindow.Msecs) * time.Millisecond,
+		MinRequests:   *breakerRequests,
+		Cooldown:      time.Duration(breakerCooldown.Msecs) * time.Millisecond,
+	}
+	if master {
+		l.MaxConcurrent = *limitMaster
+	}
+	return l
+}
+
+// SetLimits set the admission control of the master or slaves of the named data source, the name of default data source is empty.
+// It overrides the flags and resets the state of circuit breaker.
+func SetLimits(source string, master bool, limits Limits) {
+	gates.Store(gateKey{source: source, master: master}, newGate(source, master, limits))
+}
+
+type gateKey struct {
+	source string
+	master bool
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// gate limits the concurrent queries and breaks the circuit of the master or slaves of a data source
+type gate struct {
+	source string
+	master bool
+	limits Limits
+	// sem holds a token for each running query, nil if unlimited
+	sem chan struct{}
+
+	lock     sync.Mutex
+	state    circuitState
+	openedAt time.Time
+	probing  bool
+	// the queries counted in the window started at windowStart
+	windowStart time.Time
+	total       int
+	failures    int
+	latency     time.Duration
+}
+
+func newGate(source string, master bool, limits Limits) *gate {
+	g := &gate{source: source, master: master, limits: limits, windowStart: time.Now()}
+	if limits.MaxConcurrent > 0 {
+		g.sem = make(chan struct{}, limits.MaxConcurrent)
+	}
+	return g
+}
+
+// gateFor return the gate of the master or slaves of the data source, it's created by flags at first use
+func gateFor(source string, master bool) *gate {
+	key := gateKey{source: source, master: master}
+	if g, ok := gates.Load(key); ok {
+		return g.(*gate)
+	}
+	g, _ := gates.LoadOrStore(key, newGate(source, master, defaultLimits(master)))
+	return g.(*gate)
+}
+
+// acquire admit the query, probe is true if the query is the probe while half open.
+// ErrCircuitOpen is returned if the circuit is open, and ErrConcurrencyLimit is returned if the query waits in queue too long
+func (g *gate) acquire(ctx context.Context) (probe bool, err error) {
+	if probe, err = g.allow(); err != nil {
+		return false, err
+	}
+	if g.sem == nil {
+		return probe, nil
+	}
+	select {
+	case g.sem <- struct{}{}:
+		return probe, nil
+	default:
+	}
+	timer := time.NewTimer(g.limits.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case g.sem <- struct{}{}:
+		return probe, nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timer.C:
+		err = ErrConcurrencyLimit
+	}
+	if probe {
+		g.cancelProbe()
+	}
+	return false, err
+}
+
+// allow return ErrCircuitOpen if the circuit is open, or a probe is running while half open, probe is true if the query is let through as the probe
+func (g *gate) allow() (probe bool, err error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	switch g.state {
+	case circuitOpen:
+		if time.Since(g.openedAt) < g.limits.Cooldown {
+			return false, ErrCircuitOpen
+		}
+		g.state, g.probing = circuitHalfOpen, true
+		return true, nil
+	case circuitHalfOpen:
+		if g.probing {
+			return false, ErrCircuitOpen
+		}
+		g.probing = true
+		return true, nil
+	}
+	return false, nil
+}
+
+// cancelProbe let another query probe if the probe is not admitted
+func (g *gate) cancelProbe() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.state == circuitHalfOpen {
+		g.probing = false
+	}
+}
+
+// release finish the query admitted by acquire with its duration and error, probe is returned by acquire.
+// Only the probe closes or opens the circuit while half open, the queries admitted before the circuit opened are ignored.
+func (g *gate) release(duration time.Duration, err error, probe bool) {
+	if g.sem != nil {
+		<-g.sem
+	}
+	failed := isBreakerFailure(err)
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	switch g.state {
+	case circuitHalfOpen:
+		if !probe {
+			return
+		}
+		if failed || g.limits.Latency > 0 && duration >= g.limits.Latency {
+			g.open()
+		} else {
+			g.state, g.probing = circuitClosed, false
+			g.resetWindow()
+			log.Info().Str("source", g.source).Bool("master", g.master).Msg("Circuit breaker closed")
+		}
+		return
+	case circuitOpen:
+		return
+	}
+
+	if g.limits.ErrorRate <= 0 && g.limits.Latency <= 0 {
+		return
+	}
+	if time.Since(g.windowStart) >= g.limits.Window {
+		g.resetWindow()
+	}
+	g.total++
+	g.latency += duration
+	if failed {
+		g.failures++
+	}
+	if g.total < g.limits.MinRequests {
+		return
+	}
+	if g.limits.ErrorRate > 0 && float64(g.failures)/float64(g.total) >= g.limits.ErrorRate ||
+		g.limits.Latency > 0 && g.latency/time.Duration(g.total) >= g.limits.Latency {
+		g.open()
+	}
+}
+
+// open the circuit, the lock should be held
+func (g *gate) open() {
+	g.state, g.openedAt, g.probing = circuitOpen, time.Now(), false
+	log.Warn().Str("source", g.source).Bool("master", g.master).Int("total", g.total).Int("failures", g.failures).Msg("Circuit breaker opened")
+	g.resetWindow()
+}
+
+func (g *gate) resetWindow() {
+	g.windowStart, g.total, g.failures, g.latency = time.Now(), 0, 0, 0
+}
+
+// isBreakerFailure return true if the error indicates the database is unhealthy, such as broken connection, read-only master and timeout.
+// Not found, canceled by caller, and the other errors responded by MySQL server such as duplicate key are not failures.
+func isBreakerFailure(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	switch kind := errorKind(err); {
+	case kind == ErrConnection, kind == ErrReadOnly:
+		return true
+	case err == nil, kind != nil, IsNotFound(err), errors.Is(err, context.Canceled), errors.As(err, &mysqlErr):
+		return false
+	}
+	return true
+}
@@ -0,0 +1,133 @@
+package ormx
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cloudfly/ormx/test"
+	"github.com/go-sql-driver/mysql"
+)
+
+// useLimits set the limits of the default data source, they are removed after the test
+func useLimits(t *testing.T, master bool, limits Limits) *gate {
+	SetLimits("", master, limits)
+	t.Cleanup(func() { gates.Delete(gateKey{master: master}) })
+	return gateFor("", master)
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	useSQLite(t)
+	var (
+		ctx = context.Background()
+		g   = useLimits(t, false, Limits{MaxConcurrent: 1, QueueTimeout: 20 * time.Millisecond})
+		n   int
+	)
+	_, err := g.acquire(ctx)
+	test.NoError(t, err)
+	test.Equal(t, true, errors.Is(Get(ctx, &n, "SELECT COUNT(*) FROM test"), ErrConcurrencyLimit))
+	// the master has its own budget
+	test.NoError(t, Get(FromMaster(ctx), &n, "SELECT COUNT(*) FROM test"))
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	test.Equal(t, true, errors.Is(Get(cancelled, &n, "SELECT COUNT(*) FROM test"), context.Canceled))
+
+	g.release(0, nil, false)
+	test.NoError(t, Get(ctx, &n, "SELECT COUNT(*) FROM test"))
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	useSQLite(t)
+	var (
+		ctx    = context.Background()
+		events = recordEvents(t)
+		n      int
+	)
+	useLimits(t, true, Limits{ErrorRate: 0.5, Window: time.Minute, MinRequests: 2, Cooldown: 50 * time.Millisecond})
+
+	for i := 0; i < 2; i++ {
+		_, err := Exec(ctx, "INSERT INTO missing (id) VALUES (1)")
+		test.Equal(t, true, err != nil)
+	}
+	_, err := Exec(ctx, insertTestRow)
+	test.Equal(t, true, errors.Is(err, ErrCircuitOpen))
+	test.Equal(t, "circuit_open", (*events)[len(*events)-1].ErrClass)
+	// the slaves are not affected
+	test.NoError(t, Get(ctx, &n, "SELECT COUNT(*) FROM test"))
+
+	// the probe closes the circuit after cooldown
+	time.Sleep(60 * time.Millisecond)
+	_, err = Exec(ctx, insertTestRow)
+	test.NoError(t, err)
+	_, err = Exec(ctx, insertTestRow)
+	test.NoError(t, err)
+}
+
+func TestCircuitBreakerLatency(t *testing.T) {
+	var (
+		ctx = context.Background()
+		g   = newGate("", true, Limits{Latency: 10 * time.Millisecond, Window: time.Minute, MinRequests: 2, Cooldown: time.Minute})
+	)
+	for _, d := range []time.Duration{time.Millisecond, 30 * time.Millisecond} {
+		probe, err := g.acquire(ctx)
+		test.NoError(t, err)
+		test.Equal(t, false, probe)
+		g.release(d, nil, probe)
+	}
+	_, err := g.acquire(ctx)
+	test.Equal(t, true, errors.Is(err, ErrCircuitOpen))
+
+	// the slow probe opens the circuit again
+	g.openedAt = time.Now().Add(-time.Hour)
+	probe, err := g.acquire(ctx)
+	test.NoError(t, err)
+	test.Equal(t, true, probe)
+	_, err = g.acquire(ctx)
+	test.Equal(t, true, errors.Is(err, ErrCircuitOpen))
+	g.release(20*time.Millisecond, nil, probe)
+	test.Equal(t, circuitOpen, g.state)
+}
+
+func TestCircuitBreakerProbe(t *testing.T) {
+	var (
+		ctx = context.Background()
+		g   = newGate("", true, Limits{ErrorRate: 0.5, Window: time.Minute, MinRequests: 2, Cooldown: time.Minute})
+	)
+	// the slow query is admitted before the circuit opened
+	slow, err := g.acquire(ctx)
+	test.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		probe, err := g.acquire(ctx)
+		test.NoError(t, err)
+		g.release(0, ErrConnection, probe)
+	}
+	test.Equal(t, circuitOpen, g.state)
+
+	g.openedAt = time.Now().Add(-time.Hour)
+	probe, err := g.acquire(ctx)
+	test.NoError(t, err)
+	test.Equal(t, true, probe)
+	// the query released while half open doesn't close the circuit
+	g.release(0, nil, slow)
+	test.Equal(t, circuitHalfOpen, g.state)
+	_, err = g.acquire(ctx)
+	test.Equal(t, true, errors.Is(err, ErrCircuitOpen))
+
+	g.release(0, nil, probe)
+	test.Equal(t, circuitClosed, g.state)
+}
+
+func TestIsBreakerFailure(t *testing.T) {
+	test.Equal(t, false, isBreakerFailure(nil))
+	test.Equal(t, false, isBreakerFailure(sql.ErrNoRows))
+	test.Equal(t, false, isBreakerFailure(context.Canceled))
+	test.Equal(t, false, isBreakerFailure(&mysql.MySQLError{Number: 1062}))
+	test.Equal(t, false, isBreakerFailure(&DBError{Kind: ErrDeadlock, Err: &mysql.MySQLError{Number: 1213}}))
+	test.Equal(t, true, isBreakerFailure(&mysql.MySQLError{Number: 1290, Message: "The MySQL server is running with the --super-read-only option so it cannot execute this statement"}))
+	test.Equal(t, true, isBreakerFailure(&mysql.MySQLError{Number: 1040}))
+	test.Equal(t, true, isBreakerFailure(context.DeadlineExceeded))
+	test.Equal(t, true, isBreakerFailure(errors.New("bad connection")))
+}
@@ -96,12 +96,12 @@ type ignoreNamespaceCtxKey struct{}
 // WhereFromStruct generate where exprs from data(type of struct), the returned value can be used by builder.Where method
 func WhereFromStruct(c *sb.Cond, data any, dst []string) []string {
 	if data == nil {
-		return []string{}
+		return dst
 	}
 	v := dereferencedValue(reflect.ValueOf(data))
 	t := dereferencedType(reflect.TypeOf(data))
 	if !v.IsValid() || v.IsZero() {
-		return []string{}
+		return dst
 	}
 	for i := 0; i < v.NumField(); i++ {
 		field := v.Field(i)
@@ -122,7 +122,7 @@ func WhereFromStruct(c *sb.Cond, data any, dst []string) []string {
 // WhereFromStruct generate where exprs from []KV, the returned value can be used by builder.Where method
 func WhereFromKVs(c *sb.Cond, filter KVs, dst []string) []string {
 	if filter == nil {
-		return []string{}
+		return dst
 	}
 	for _, kv := range filter {
 		colName := kv.Key
@@ -163,6 +163,15 @@ func WhereFrom(c *sb.Cond, filter any, dst []string) []string {
 	return dst
 }
 
+// isWhereOp return true if op generates a where condition by appendWhereExpr, the unknown ops are ignored by it
+func isWhereOp(op string) bool {
+	switch op {
+	case "", "e", "ne", "gt", "gte", "lt", "lte", "in", "notin", "like", "notlike":
+		return true
+	}
+	return false
+}
+
 func appendWhereExpr(c *sb.Cond, dst []string, column string, value any, op string) []string {
 	switch op {
 	case "":
@@ -200,7 +209,7 @@ func appendWhereExpr(c *sb.Cond, dst []string, column string, value any, op stri
 }
 
 // TableName auto recoganize the table name from data, it will auto prepend the tableNamePrefix which can be set by SetTableNamePrefix to the result.
-//   - having Table() method, it will call d.Table() to get the table name
+//   - having Table() method, it will call d.Table() to get the table name, so do the pointer and slice of the type having it, such as []T and []*T
 //   - type of struct, it will use the struct name, and snake case it
 //   - type of string, return the name.
 //   - type of other, return fmt.Sprintf("%s", d)
@@ -226,6 +235,10 @@ S:
 	case reflect.String:
 		return d.(string)
 	case reflect.Struct:
+		if t, ok := reflect.New(vt).Interface().(interface{ Table() string }); ok {
+			// the Table() method defined on the element type of pointer or slice
+			return t.Table()
+		}
 		structName := vt.Name()
 		name = sb.SnakeCaseMapper(structName)
 	case reflect.Slice:
@@ -0,0 +1,27 @@
+package ormx
+
+import (
+	"testing"
+
+	"github.com/cloudfly/ormx/test"
+)
+
+type pointerTableRow struct {
+	ID int64 `db:"id"`
+}
+
+func (*pointerTableRow) Table() string { return "pointer_rows" }
+
+func TestTableName(t *testing.T) {
+	test.Equal(t, "test", TableName(TestRow{}))
+	test.Equal(t, "test", TableName(&TestRow{}))
+	// the Table() method on the element type of slice and pointer
+	test.Equal(t, "test", TableName([]TestRow{}))
+	test.Equal(t, "test", TableName(&[]*TestRow{}))
+	test.Equal(t, "pointer_rows", TableName(pointerTableRow{}))
+	test.Equal(t, "pointer_rows", TableName([]pointerTableRow{}))
+
+	test.Equal(t, "test_row_patch", TableName(TestRowPatch{}))
+	test.Equal(t, "test_row_patch", TableName([]*TestRowPatch{}))
+	test.Equal(t, "users", TableName("users"))
+}
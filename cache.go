@@ -0,0 +1,218 @@
+package ormx
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/cloudfly/flagx"
+	"github.com/cloudfly/ormx/cache"
+)
+
+var (
+	cacheTTL         = flagx.NewDuration("database.cache.ttl", "10s", "the ttl of rows cached by GetByID, 0 disables the cache")
+	cacheTables      = flagx.NewArrayString("database.cache.tables", "the tables whose rows are cached by GetByID, all tables are cached if empty")
+	cacheNegativeTTL = flagx.NewDuration("database.cache.negative.ttl", "0", "the ttl of not found rows cached by GetByID, 0 disables the negative cache. The rows inserted by raw Exec are not invalidated, they may be not found until it expires")
+	cacheStale       = flagx.NewDuration("database.cache.stale", "0", "the duration GetByID can serve the expired row while refreshing it in background, 0 disables it")
+	cacheJitter      = flagx.NewFloat("database.cache.jitter", 0.1, "the ratio the cache ttl is randomly shortened by, so that rows cached together don't expire together")
+
+	tableCacheTTLLock sync.RWMutex
+	tableCacheTTL     = map[string]time.Duration{}
+)
+
+// SetCacheTTL set the ttl of rows cached by GetByID for the table, it overrides the flags. A non-positive ttl disables the cache of the table.
+func SetCacheTTL(table string, ttl time.Duration) {
+	tableCacheTTLLock.Lock()
+	defer tableCacheTTLLock.Unlock()
+	tableCacheTTL[table] = ttl
+}
+
+// cacheTTLOf return the ttl for caching rows of table, 0 means the table should not be cached
+func cacheTTLOf(table string) time.Duration {
+	tableCacheTTLLock.RLock()
+	ttl, ok := tableCacheTTL[table]
+	tableCacheTTLLock.RUnlock()
+	if ok {
+		return max(ttl, 0)
+	}
+	if len(*cacheTables) > 0 && !slices.Contains(*cacheTables, table) {
+		return 0
+	}
+	return time.Duration(cacheTTL.Msecs) * time.Millisecond
+}
+
+func rowCacheOptions(ttl time.Duration) cache.Options {
+	return cache.Options{
+		TTL:         ttl,
+		NegativeTTL: time.Duration(cacheNegativeTTL.Msecs) * time.Millisecond,
+		Stale:       time.Duration(cacheStale.Msecs) * time.Millisecond,
+		Jitter:      *cacheJitter,
+	}
+}
+
+// rowCacheKey return the cache key of the row, it contains the versions of the table and the row, so that invalidateRows
+// invalidates all the variants of the row by bumping the versions
+func rowCacheKey(ctx context.Context, table string, id any, dst any) []any {
+	typ := ""
+	if dst != nil {
+		typ = dereferencedElemType(reflect.TypeOf(dst)).String()
+	}
+	source := sourceName(ctx, []string{table})
+	version := cache.Version(table, source) + "." + cache.Version(table, source, id)
+	return []any{table, source, id, version, namespaceValueForInject(ctx), typ}
+}
+
+// invalidateRows invalidate the cached rows of table by ids, all the rows of table are invalidated if no id given.
+// The slice ids like []int64{1, 2} are flattened. The cached query results of the table are always invalidated,
+// they are cached by the physical tables if table is sharded, so the results of all the shards are invalidated.
+// The keys are not scanned, the versions in them are bumped instead, and the items cached with the previous versions expire by ttl.
+//
+// The removal is deferred until commit if ctx is in the transaction created by RunTxContext.
+// The transaction not created by RunTxContext is unknown to ormx, so the removal happens before it's committed,
+// and a concurrent read may cache the old row again until the ttl expires.
+func invalidateRows(ctx context.Context, table string, ids ...any) {
+	var (
+		flattened []any
+		source    = sourceName(ctx, []string{table})
+		queries   = queryTables(table)
+		sources   = make([]string, len(queries))
+	)
+	for _, id := range ids {
+		if items, ok := filterIDs(id); ok {
+			flattened = append(flattened, items...)
+		}
+	}
+	for i, t := range queries {
+		sources[i] = sourceName(ctx, []string{t})
+	}
+	AfterCommit(ctx, func() {
+		for i, t := range queries {
+			cache.Bump(t, sources[i], queryCacheSegment)
+		}
+		if len(ids) == 0 {
+			cache.Bump(table, source)
+			return
+		}
+		for _, id := range flattened {
+			cache.Bump(table, source, id)
+		}
+	})
+}
+
+// queryTables return the tables the query results of table are cached by, they are all the physical tables if table is sharded
+func queryTables(table string) []string {
+	s := shardingOf(table)
+	if s == nil {
+		return []string{table}
+	}
+	tables := make([]string, s.Strategy.Shards())
+	for i := range tables {
+		tables[i] = s.table(table, i)
+	}
+	return tables
+}
+
+// filterIDs return the primary ids in filter, false if the filter is not made of ids
+func filterIDs(filter any) ([]any, bool) {
+	if filter == nil {
+		return nil, false
+	}
+	if _, ok := filter.(KVs); ok {
+		return nil, false
+	}
+	switch dereferencedType(reflect.TypeOf(filter)).Kind() {
+	case reflect.Struct, reflect.Map:
+		return nil, false
+	case reflect.Slice:
+		return Any2Slice(dereferencedValue(reflect.ValueOf(filter)).Interface()), true
+	}
+	return []any{filter}, true
+}
+
+// primaryKeyValues return the non-zero primary key values of the data, the data should be struct
+func primaryKeyValues(data ...any) []any {
+	var ids []any
+	for _, item := range data {
+		v := dereferencedValue(reflect.ValueOf(item))
+		if !v.IsValid() || v.Kind() != reflect.Struct {
+			continue
+		}
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if name, _ := colNameFromTag(t.Field(i)); name != *primaryKey {
+				continue
+			}
+			if field := dereferencedValue(v.Field(i)); field.IsValid() && !field.IsZero() {
+				ids = append(ids, field.Interface())
+			}
+			break
+		}
+	}
+	return ids
+}
+
+// queryCacheSegment is the third key segment of the cached query results, following the table and data source name,
+// the version of the query results follows it
+const queryCacheSegment = "ormx:query"
+
+type queryCacheCtxKey struct{}
+
+// WithCache enable caching the results of SelectWhere, Count and Exist for ttl when called by this context.
+//
+// The results are cached by the final sql, args and namespace, and they are invalidated by the writes on the same table.
+// The writes in transaction invalidate the cache after commit only if the transaction is created by RunTxContext.
+// The cache is skipped when ctx is created by FromMaster.
+func WithCache(ctx context.Context, ttl time.Duration) context.Context {
+	return context.WithValue(ctx, queryCacheCtxKey{}, ttl)
+}
+
+func queryCacheTTL(ctx context.Context) time.Duration {
+	ttl, _ := ctx.Value(queryCacheCtxKey{}).(time.Duration)
+	return ttl
+}
+
+// queryCacheKey return the cache key of query, it contains the version of the query results of the table bumped by invalidateRows
+func queryCacheKey(ctx context.Context, table, statement string, args []any) []any {
+	h := sha256.New()
+	h.Write([]byte(statement))
+	for _, arg := range args {
+		fmt.Fprintf(h, "\x00%T:%v", arg, arg)
+	}
+	fmt.Fprintf(h, "\x00%s", namespaceValueForInject(ctx))
+	source := sourceName(ctx, []string{table})
+	return []any{table, source, queryCacheSegment, cache.Version(table, source, queryCacheSegment), hex.EncodeToString(h.Sum(nil)[:16])}
+}
+
+// cachedQuery fill dst by query, the result is cached if ctx is created by WithCache
+func cachedQuery(ctx context.Context, table string, dst any, statement string, args []any, query func() error) error {
+	ttl := queryCacheTTL(ctx)
+	if ttl <= 0 || isFromMaster(ctx) {
+		return query()
+	}
+	var (
+		content []byte
+		queried bool
+		start   = time.Now()
+	)
+	hit, err := cache.TryHit(&content, func() error {
+		if err := query(); err != nil {
+			return err
+		}
+		queried = true
+		var err error
+		content, err = json.Marshal(dst)
+		return err
+	}, ttl, queryCacheKey(ctx, table, statement, args)...)
+	if err != nil || queried {
+		return err
+	}
+	err = json.Unmarshal(content, dst)
+	observe(ctx, QueryEvent{Operation: OpSelect, Tables: []string{table}, SQL: statement, Rows: resultRows(dst), Duration: time.Since(start), Err: err, CacheHit: hit, SharedLoad: !hit})
+	return err
+}
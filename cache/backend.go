@@ -0,0 +1,27 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Backend is the storage of cache, the values are stored as bytes
+type Backend interface {
+	// Get return the value of key, false if the key not found or expired
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set the value of key, which will expire after ttl
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete the key
+	Delete(ctx context.Context, key string) error
+	// DeleteByPrefix delete all the keys start with prefix
+	DeleteByPrefix(ctx context.Context, prefix string) error
+}
+
+// Broadcaster deliver messages to all the subscribers, it's used to broadcast invalidations between replicas
+type Broadcaster interface {
+	// Publish msg to all the subscribers
+	Publish(ctx context.Context, msg string) error
+	// Subscribe calls handle for each message in background, until stop called or ctx done.
+	// It returns after the subscription is ready.
+	Subscribe(ctx context.Context, handle func(msg string)) (stop func(), err error)
+}
@@ -1,79 +1,113 @@
 package cache
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"strings"
 	"sync"
 	"time"
 
-	lru "github.com/hashicorp/golang-lru"
+	"golang.org/x/sync/singleflight"
 )
 
 var (
 	lock sync.RWMutex
-	// Assuming each item is 128 bytes, we allocate 25% of our available memory to the cache.
-	defaultSize = GetMemoryLimit() / 4 / 128
-	cache       *lru.TwoQueueCache
-	finalizers  []Finalizer
+	// We allocate 25% of our available memory to the cache.
+	defaultMaxBytes = GetMemoryLimit() / 4
+	local           *Local
+	backend         Backend
+	group           singleflight.Group
 )
 
+// Finalizer is called with the key and value of the items removed from the local cache.
+//
+// Deprecated: use the onEvict of NewLocal to get the typed key and the eviction reason.
 type Finalizer func(any, any)
 
-type Value struct {
-	Data   interface{}
-	Expire time.Time
-}
-
-// Init the cache size
+// Init the local cache, it's used as backend unless another one is set by SetBackend.
+// The local cache created by the previous Init is closed, and its items are no longer counted in Stats.
 func Init(fs ...Finalizer) (err error) {
-	size := defaultSize
+	size := defaultMaxBytes
 	if size == 0 {
 		size = 1024 * 1024 * 64 // 64M
 	}
-	cache, err = lru.New2Q(int(size))
-	if err != nil {
-		return err
+	onEvict := make([]func(string, any, EvictReason), 0, len(fs))
+	for _, f := range fs {
+		onEvict = append(onEvict, func(key string, value any, _ EvictReason) { f(key, value) })
+	}
+	l := NewLocal(size, onEvict...)
+
+	lock.Lock()
+	prev := local
+	if backend == nil || backend == Backend(prev) {
+		backend = l
+	}
+	local = l
+	lock.Unlock()
+
+	if prev != nil {
+		prev.Close()
+		recordLocalDrop(prev)
 	}
-	finalizers = fs
-	go tick()
-	return
+	return nil
 }
 
-func Contains(key interface{}) bool {
-	return cache.Contains(key)
+// Close stop the background cleanup of the local cache created by Init
+func Close() error {
+	lock.RLock()
+	defer lock.RUnlock()
+	if local == nil {
+		return nil
+	}
+	return local.Close()
 }
 
-func Expire() {
+// SetBackend replace the backend used by the package level functions, such as Redis or Tiered.
+//
+// The values stored in a backend other than the local cache are bytes, []byte is stored as it is and others are JSON encoded,
+// so Get always returns []byte on them.
+func SetBackend(b Backend) {
 	lock.Lock()
 	defer lock.Unlock()
-	now := time.Now()
-	for _, key := range cache.Keys() {
-		if v, ok := cache.Get(key); ok && v.(Value).Expire.Before(now) {
-			cache.Remove(key)
-			for _, f := range finalizers {
-				f(key, v.(Value).Data)
-			}
-		}
+	backend = b
+}
+
+// remote return the backend if it's not the local cache, otherwise nil
+func remote() Backend {
+	lock.RLock()
+	defer lock.RUnlock()
+	if backend == Backend(local) {
+		return nil
 	}
+	return backend
 }
 
-func getByKey(key interface{}) (interface{}, bool) {
-	lock.Lock()
-	defer lock.Unlock()
-	value, ok := cache.Get(key)
-	if !ok {
-		return nil, false
-	}
-	ins := value.(Value)
-	if ins.Expire.Before(time.Now()) {
-		cache.Remove(key)
-		for _, f := range finalizers {
-			f(key, ins.Data)
+func Contains(key interface{}) bool {
+	if r := remote(); r != nil {
+		_, ok, err := r.Get(context.Background(), fmt.Sprintf("%v", key))
+		return ok && err == nil
+	}
+	_, ok := local.cache.Peek(fmt.Sprintf("%v", key))
+	return ok
+}
+
+// Expire remove the expired items from the local cache
+func Expire() {
+	local.expire()
+}
+
+func getByKey(key string) (value interface{}, ok bool) {
+	defer func() { recordGet(key, ok) }()
+	if r := remote(); r != nil {
+		data, ok, err := r.Get(context.Background(), key)
+		if err != nil || !ok {
+			return nil, false
 		}
-		return nil, false
+		return data, true
 	}
-	return ins.Data, true
+	return local.get(key)
 }
 
 func Get(keys ...interface{}) (interface{}, bool) {
@@ -88,58 +122,107 @@ func Set(ttl time.Duration, keyAndValue ...any) {
 
 	keys := keyAndValue[:len(keyAndValue)-1]
 	value := keyAndValue[len(keyAndValue)-1]
-	key := joinSlice(keys, "/")
-	lock.Lock()
-	defer lock.Unlock()
-	cache.Add(key, Value{
-		Data:   value,
-		Expire: time.Now().Add(ttl),
-	})
+	setByKey(ttl, joinSlice(keys, "/"), value)
+}
+
+func setByKey(ttl time.Duration, key string, value any) {
+	recordSet(key)
+	if r := remote(); r != nil {
+		content, err := encode(value)
+		if err != nil {
+			return
+		}
+		_ = r.Set(context.Background(), key, content, ttl)
+		return
+	}
+	local.set(key, value, ttl)
 }
 
 func Remove(keys ...any) {
 	key := joinSlice(keys, "/")
-	lock.Lock()
-	defer lock.RUnlock()
-	value, ok := cache.Get(key)
-	if !ok {
+	generationOf(key).Add(1)
+	if r := remote(); r != nil {
+		_ = r.Delete(context.Background(), key)
 		return
 	}
-	cache.Remove(key)
-	for _, f := range finalizers {
-		f(key, value.(Value).Data)
+	local.remove(key)
+}
+
+// RemovePrefix remove all the items whose key starts with the keys, the keys are joined as a whole segment,
+// so RemovePrefix("user", 1) removes "user/1" and "user/1/..." but not "user/10".
+// The values being loaded by Load or Try under the same first segment are not stored, since they may be read before the removal.
+func RemovePrefix(keys ...any) {
+	prefix := joinSlice(keys, "/")
+	generationOf(prefix).Add(1)
+	if r := remote(); r != nil {
+		_ = r.Delete(context.Background(), prefix)
+		_ = r.DeleteByPrefix(context.Background(), prefix+"/")
+		return
 	}
+	local.remove(prefix)
+	local.removePrefix(prefix + "/")
 }
 
+// Try get the value of keys into dest, the fallback is called to fill dest on cache miss, and then the dest value is cached for ttl.
+//
+// The concurrent misses of the same key share one fallback call, the callers which not running the fallback receive the value filled by it.
+// Use Load for negative caching, stale-while-revalidate and ttl jitter.
 func Try(dest any, fallback func() error, ttl time.Duration, keys ...any) error {
-	key := joinSlice(keys, "/")
-	value, ok := getByKey(key)
-	if !ok {
+	_, err := TryHit(dest, fallback, ttl, keys...)
+	return err
+}
+
+// TryHit is same as Try, and it reports whether dest is filled from cache.
+// The hit is false if dest is filled by the fallback of this call or a concurrent one of the same key.
+func TryHit(dest any, fallback func() error, ttl time.Duration, keys ...any) (bool, error) {
+	if dest == nil {
+		return false, fmt.Errorf("dest is nil")
+	}
+	var (
+		elem   = reflect.ValueOf(dest).Elem()
+		filled = false
+	)
+	value, hit, err := fetch(joinSlice(keys, "/"), elem.Type(), Options{TTL: ttl}, func() (any, error) {
 		if err := fallback(); err != nil {
-			return err
+			return nil, err
 		}
-		cache.Add(key, Value{
-			Data:   reflect.ValueOf(dest).Elem().Interface(),
-			Expire: time.Now().Add(ttl),
-		})
-	} else if dest == nil {
-		return fmt.Errorf("dest is nil")
-	} else {
-		reflect.ValueOf(dest).Elem().Set(reflect.ValueOf(value))
+		filled = true
+		return elem.Interface(), nil
+	})
+	if err != nil || filled {
+		return hit, err
 	}
-	return nil
+	if value != nil {
+		elem.Set(reflect.ValueOf(value))
+	}
+	return hit, nil
 }
 
+// Len return the items count in local cache
 func Len() int {
-	return cache.Len()
+	return local.cache.Len()
+}
+
+// Bytes return the total size of values in local cache
+func Bytes() int64 {
+	return local.cache.Bytes()
+}
+
+// encode the value into bytes for storing in backend, []byte is kept as it is
+func encode(value any) ([]byte, error) {
+	if content, ok := value.([]byte); ok {
+		return content, nil
+	}
+	return json.Marshal(value)
 }
 
-func tick() {
-	ticker := time.NewTicker(time.Minute)
-	defer ticker.Stop()
-	for range ticker.C {
-		Expire()
+// decode the content stored by encode into dest
+func decode(content []byte, dest any) error {
+	if b, ok := dest.(*[]byte); ok {
+		*b = content
+		return nil
 	}
+	return json.Unmarshal(content, dest)
 }
 
 func joinSlice[T int | string | int64 | int32 | int16 | int8 | uint32 | uint64 | uint16 | uint8 | float64 | float32 | any](data []T, split string) string {
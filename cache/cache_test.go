@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudfly/ormx/test"
+)
+
+func TestRemovePrefix(t *testing.T) {
+	test.NoError(t, Init())
+	Set(time.Minute, "user", 1, "a", 1)
+	Set(time.Minute, "user", 1, "b", 2)
+	Set(time.Minute, "user", 10, 3)
+
+	RemovePrefix("user", 1)
+	_, ok := Get("user", 1, "a")
+	test.Equal(t, false, ok)
+	_, ok = Get("user", 1, "b")
+	test.Equal(t, false, ok)
+	v, ok := Get("user", 10)
+	test.Equal(t, true, ok)
+	test.Equal(t, 3, v)
+
+	Remove("user", 10)
+	_, ok = Get("user", 10)
+	test.Equal(t, false, ok)
+}
+
+func TestLocalDeleteByPrefix(t *testing.T) {
+	var (
+		ctx = context.Background()
+		l   = NewLocal(1024 * 1024)
+	)
+	defer l.Close()
+	for _, key := range []string{"orders/1", "orders/2", "users/1"} {
+		test.NoError(t, l.Set(ctx, key, []byte("a"), time.Minute))
+	}
+	test.NoError(t, l.DeleteByPrefix(ctx, "orders/1"))
+	_, ok, _ := l.Get(ctx, "orders/1")
+	test.Equal(t, false, ok)
+	_, ok, _ = l.Get(ctx, "orders/2")
+	test.Equal(t, true, ok)
+
+	// the prefix not ending on a segment boundary
+	test.NoError(t, l.DeleteByPrefix(ctx, "ord"))
+	_, ok, _ = l.Get(ctx, "orders/2")
+	test.Equal(t, false, ok)
+	_, ok, _ = l.Get(ctx, "users/1")
+	test.Equal(t, true, ok)
+}
+
+func TestTrySingleflight(t *testing.T) {
+	test.NoError(t, Init())
+
+	var (
+		calls   atomic.Int32
+		release = make(chan struct{})
+		wg      sync.WaitGroup
+		results = make([]int, 8)
+		hits    atomic.Int32
+	)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			hit, err := TryHit(&results[i], func() error {
+				calls.Add(1)
+				<-release
+				results[i] = 42
+				return nil
+			}, time.Minute, "try", "singleflight")
+			test.NoError(t, err)
+			if hit {
+				hits.Add(1)
+			}
+		}(i)
+	}
+	time.Sleep(time.Millisecond * 50)
+	close(release)
+	wg.Wait()
+
+	test.Equal(t, int32(1), calls.Load())
+	// the callers sharing the fallback are not hits
+	test.Equal(t, int32(0), hits.Load())
+	for _, v := range results {
+		test.Equal(t, 42, v)
+	}
+
+	var v int
+	hit, err := TryHit(&v, func() error { return nil }, time.Minute, "try", "singleflight")
+	test.NoError(t, err)
+	test.Equal(t, true, hit)
+	test.Equal(t, 42, v)
+}
+
+func TestVersion(t *testing.T) {
+	test.NoError(t, Init())
+	v1 := Version("user", 1)
+	test.Equal(t, v1, Version("user", 1))
+	test.Equal(t, true, Version("user", 2) != v1)
+
+	v2 := Bump("user", 1)
+	test.Equal(t, true, v2 != v1)
+	test.Equal(t, v2, Version("user", 1))
+
+	// the version removed is replaced by a new one
+	RemovePrefix("user", 1)
+	test.Equal(t, true, Version("user", 1) != v2)
+}
@@ -0,0 +1,225 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var generations sync.Map // first key segment => *atomic.Int64
+
+// generationOf return the generation of the key's first segment, it's bumped by Remove and RemovePrefix,
+// so that the value loaded before the removal is not stored after it.
+func generationOf(key string) *atomic.Int64 {
+	segment := firstSegment(key)
+	if g, ok := generations.Load(segment); ok {
+		return g.(*atomic.Int64)
+	}
+	g, _ := generations.LoadOrStore(segment, &atomic.Int64{})
+	return g.(*atomic.Int64)
+}
+
+// Options controls how Load caches the loaded values
+type Options struct {
+	// TTL is the duration the loaded value keeps fresh
+	TTL time.Duration
+	// NegativeTTL is the duration to cache the sql.ErrNoRows returned by load, the cached not found is returned as sql.ErrNoRows.
+	// 0 disables the negative caching.
+	NegativeTTL time.Duration
+	// Stale is the duration an expired value can still be served after TTL, while it's refreshed in background.
+	// 0 disables the stale-while-revalidate.
+	Stale time.Duration
+	// Jitter shortens the TTL and NegativeTTL randomly by at most Jitter * ttl, so that the entries loaded together don't expire together.
+	// It should be in [0, 1).
+	Jitter float64
+}
+
+// Size implements Sizer
+func (e entry) Size() int64 {
+	return estimateSize(e.Value) + 32
+}
+
+func (o Options) jitter(ttl time.Duration) time.Duration {
+	if o.Jitter <= 0 || ttl <= 0 {
+		return ttl
+	}
+	return ttl - time.Duration(rand.Float64()*min(o.Jitter, 1)*float64(ttl))
+}
+
+// entry is the value stored by Load, Fresh is the time before which the value needn't refresh
+type entry struct {
+	Value    any
+	NotFound bool
+	Fresh    time.Time
+}
+
+// Load get the value of keys from cache, the load is called to get the value on miss, and the value is cached according to opts.
+//
+// The concurrent misses of the same key share one load call. When opts.Stale is set, the load may be called in background after the
+// value expired, so it should not depend on the caller's state, such as a cancelable context.
+func Load[T any](opts Options, load func() (T, error), keys ...any) (T, error) {
+	value, _, err := LoadHit(opts, load, keys...)
+	return value, err
+}
+
+// LoadHit is same as Load, and it reports whether the value or the not found is served from cache.
+// The hit is false if the value is loaded by this call or a concurrent one of the same key.
+func LoadHit[T any](opts Options, load func() (T, error), keys ...any) (T, bool, error) {
+	var zero T
+	v, hit, err := fetch(joinSlice(keys, "/"), reflect.TypeFor[T](), opts, func() (any, error) {
+		return load()
+	})
+	if err != nil {
+		return zero, hit, err
+	}
+	value, ok := v.(T)
+	if !ok {
+		return zero, hit, nil
+	}
+	return value, hit, nil
+}
+
+// LoadInto is same as LoadHit for the type known at runtime, dest is the pointer to the type of the value returned by load,
+// and the value is stored into it. The value kept by the local cache is shared by the calls, so its slices and maps should not be modified.
+func LoadInto(dest any, opts Options, load func() (any, error), keys ...any) (bool, error) {
+	if dest == nil {
+		return false, errors.New("dest is nil")
+	}
+	elem := reflect.ValueOf(dest).Elem()
+	v, hit, err := fetch(joinSlice(keys, "/"), elem.Type(), opts, load)
+	if err != nil {
+		return hit, err
+	}
+	if v != nil {
+		elem.Set(reflect.ValueOf(v))
+	}
+	return hit, nil
+}
+
+// fetch get the value of key from cache or by load, hit is true if it's served from cache
+func fetch(key string, typ reflect.Type, opts Options, load func() (any, error)) (value any, hit bool, err error) {
+	e, ok := getEntry(key, typ)
+	recordGet(key, ok)
+	if ok {
+		if e.Fresh.Before(time.Now()) {
+			// serving the stale value, refresh it in background
+			group.DoChan(key, func() (any, error) {
+				return loadEntry(key, opts, load)
+			})
+		}
+		if e.NotFound {
+			return nil, true, sql.ErrNoRows
+		}
+		return e.Value, true, nil
+	}
+	v, err, _ := group.Do(key, func() (any, error) {
+		return loadEntry(key, opts, load)
+	})
+	return v, false, err
+}
+
+// loadEntry call load and store its result, the result is not stored if the keys of the same first segment are removed during the load,
+// since it may be read before the write causing the removal.
+func loadEntry(key string, opts Options, load func() (any, error)) (any, error) {
+	var (
+		generation = generationOf(key)
+		before     = generation.Load()
+	)
+	v, err := load()
+	if generation.Load() != before {
+		return v, err
+	}
+	if err != nil {
+		if opts.NegativeTTL > 0 && errors.Is(err, sql.ErrNoRows) {
+			ttl := opts.jitter(opts.NegativeTTL)
+			setEntry(key, entry{NotFound: true, Fresh: time.Now().Add(ttl)}, ttl)
+		}
+		return nil, err
+	}
+	ttl := opts.jitter(opts.TTL)
+	setEntry(key, entry{Value: v, Fresh: time.Now().Add(ttl)}, ttl+opts.Stale)
+	return v, nil
+}
+
+func getEntry(key string, typ reflect.Type) (entry, bool) {
+	if r := remote(); r != nil {
+		content, ok, err := r.Get(context.Background(), key)
+		if err != nil || !ok {
+			return entry{}, false
+		}
+		e, err := decodeEntry(content, typ)
+		return e, err == nil
+	}
+	v, ok := local.get(key)
+	if !ok {
+		return entry{}, false
+	}
+	e, ok := v.(entry)
+	if !ok && reflect.TypeOf(v) == typ {
+		// the value stored by Set has no stale phase, it keeps fresh until the local cache expires it
+		return entry{Value: v, Fresh: time.Now().Add(time.Hour)}, true
+	}
+	if ok && !e.NotFound && reflect.TypeOf(e.Value) != typ && typ.Kind() != reflect.Interface {
+		// the entry is stored by another type, treat it as missing
+		return entry{}, false
+	}
+	return e, ok
+}
+
+func setEntry(key string, e entry, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	recordSet(key)
+	if r := remote(); r != nil {
+		content, err := encodeEntry(e)
+		if err != nil {
+			return
+		}
+		_ = r.Set(context.Background(), key, content, ttl)
+		return
+	}
+	local.set(key, e, ttl)
+}
+
+// encodeEntry encode the entry as: 1 byte not found flag, 8 bytes fresh unix nano, and the value encoded by encode
+func encodeEntry(e entry) ([]byte, error) {
+	header := make([]byte, 9)
+	if e.NotFound {
+		header[0] = 1
+	}
+	binary.BigEndian.PutUint64(header[1:], uint64(e.Fresh.UnixNano()))
+	if e.NotFound {
+		return header, nil
+	}
+	content, err := encode(e.Value)
+	if err != nil {
+		return nil, err
+	}
+	return append(header, content...), nil
+}
+
+func decodeEntry(content []byte, typ reflect.Type) (entry, error) {
+	if len(content) < 9 {
+		return entry{}, errors.New("invalid cache entry")
+	}
+	e := entry{
+		NotFound: content[0] == 1,
+		Fresh:    time.Unix(0, int64(binary.BigEndian.Uint64(content[1:9]))),
+	}
+	if e.NotFound {
+		return e, nil
+	}
+	dest := reflect.New(typ)
+	if err := decode(content[9:], dest.Interface()); err != nil {
+		return entry{}, err
+	}
+	e.Value = dest.Elem().Interface()
+	return e, nil
+}
@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"database/sql"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudfly/ormx/test"
+)
+
+func TestLoadNegative(t *testing.T) {
+	test.NoError(t, Init())
+
+	var (
+		calls atomic.Int32
+		opts  = Options{TTL: time.Minute, NegativeTTL: time.Minute}
+		load  = func() ([]byte, error) {
+			calls.Add(1)
+			return nil, sql.ErrNoRows
+		}
+	)
+	for i := 0; i < 3; i++ {
+		_, err := Load(opts, load, "load", "negative")
+		test.Equal(t, true, errors.Is(err, sql.ErrNoRows))
+	}
+	test.Equal(t, int32(1), calls.Load())
+
+	// the other errors are not cached
+	for i := 0; i < 3; i++ {
+		_, err := Load(opts, func() ([]byte, error) {
+			calls.Add(1)
+			return nil, errors.New("boom")
+		}, "load", "error")
+		test.Equal(t, "boom", err.Error())
+	}
+	test.Equal(t, int32(4), calls.Load())
+}
+
+func TestLoadRemovedDuringLoad(t *testing.T) {
+	test.NoError(t, Init())
+
+	// the value loaded before the removal is returned but not cached
+	v, err := Load(Options{TTL: time.Minute}, func() (string, error) {
+		RemovePrefix("load", "racing")
+		return "old", nil
+	}, "load", "racing")
+	test.NoError(t, err)
+	test.Equal(t, "old", v)
+	_, ok := Get("load", "racing")
+	test.Equal(t, false, ok)
+
+	v, err = Load(Options{TTL: time.Minute}, func() (string, error) { return "new", nil }, "load", "racing")
+	test.NoError(t, err)
+	test.Equal(t, "new", v)
+	v, err = Load(Options{TTL: time.Minute}, func() (string, error) { return "", errors.New("not called") }, "load", "racing")
+	test.NoError(t, err)
+	test.Equal(t, "new", v)
+}
+
+func TestLoadStale(t *testing.T) {
+	test.NoError(t, Init())
+
+	var (
+		version atomic.Int32
+		opts    = Options{TTL: time.Millisecond * 50, Stale: time.Minute}
+		load    = func() (int32, error) { return version.Add(1), nil }
+		v, err  = Load(opts, load, "load", "stale")
+	)
+	test.NoError(t, err)
+	test.Equal(t, int32(1), v)
+
+	time.Sleep(time.Millisecond * 100)
+	// the stale value is served, and refreshed in background
+	v, err = Load(opts, load, "load", "stale")
+	test.NoError(t, err)
+	test.Equal(t, int32(1), v)
+
+	deadline := time.Now().Add(time.Second * 3)
+	for v != 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+		v, err = Load(opts, load, "load", "stale")
+		test.NoError(t, err)
+	}
+	test.Equal(t, int32(2), v)
+}
+
+func TestOptionsJitter(t *testing.T) {
+	opts := Options{Jitter: 0.2}
+	for i := 0; i < 100; i++ {
+		ttl := opts.jitter(time.Minute)
+		test.Equal(t, true, ttl <= time.Minute && ttl >= time.Second*48)
+	}
+	test.Equal(t, time.Minute, Options{}.jitter(time.Minute))
+}
+
+func TestLoadInto(t *testing.T) {
+	test.NoError(t, Init())
+	type row struct {
+		ID     int
+		Secret string `json:"-"`
+	}
+	var (
+		calls atomic.Int32
+		load  = func() (any, error) {
+			calls.Add(1)
+			return row{ID: 1, Secret: "s"}, nil
+		}
+	)
+	for _, hit := range []bool{false, true} {
+		var dest row
+		got, err := LoadInto(&dest, Options{TTL: time.Minute}, load, "load", "into")
+		test.NoError(t, err)
+		test.Equal(t, hit, got)
+		test.Equal(t, row{ID: 1, Secret: "s"}, dest)
+		test.Equal(t, int32(1), calls.Load())
+	}
+}
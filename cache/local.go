@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Local is the process-local LRU cache limited by bytes, it's the default backend
+type Local struct {
+	cache   *Cache[string, any]
+	onEvict []func(key string, value any, reason EvictReason)
+}
+
+// NewLocal create a local cache holding at most maxBytes of values, the onEvict functions are called with the removed items.
+//
+// The expired items are removed every minute in background until Close called.
+func NewLocal(maxBytes int64, onEvict ...func(key string, value any, reason EvictReason)) *Local {
+	l := &Local{onEvict: onEvict}
+	l.cache = New(Config[string, any]{
+		MaxBytes:        maxBytes,
+		OnEvict:         l.evicted,
+		CleanupInterval: time.Minute,
+		Group:           firstSegment,
+	})
+	return l
+}
+
+// Get implements Backend
+func (l *Local) Get(_ context.Context, key string) ([]byte, bool, error) {
+	v, ok := l.get(key)
+	if !ok {
+		return nil, false, nil
+	}
+	content, ok := v.([]byte)
+	return content, ok, nil
+}
+
+// Set implements Backend
+func (l *Local) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	l.set(key, value, ttl)
+	return nil
+}
+
+// Delete implements Backend
+func (l *Local) Delete(_ context.Context, key string) error {
+	l.remove(key)
+	return nil
+}
+
+// DeleteByPrefix implements Backend
+func (l *Local) DeleteByPrefix(_ context.Context, prefix string) error {
+	l.removePrefix(prefix)
+	return nil
+}
+
+// Close stop the background cleanup
+func (l *Local) Close() error {
+	return l.cache.Close()
+}
+
+func (l *Local) get(key string) (any, bool) {
+	return l.cache.Get(key)
+}
+
+func (l *Local) set(key string, value any, ttl time.Duration) {
+	recordLocalSet(key, estimateSize(value))
+	l.cache.Set(key, value, ttl)
+}
+
+func (l *Local) remove(key string) {
+	l.cache.Remove(key)
+}
+
+// removePrefix remove the keys starting with prefix. Only the keys sharing the first segment with prefix are scanned
+// if the prefix contains the whole first segment, otherwise all the keys are scanned.
+func (l *Local) removePrefix(prefix string) {
+	match := func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	}
+	if !strings.Contains(prefix, "/") {
+		l.cache.RemoveFunc(match)
+		return
+	}
+	l.cache.RemoveGroup(firstSegment(prefix), match)
+}
+
+func (l *Local) expire() {
+	l.cache.Expire()
+}
+
+func (l *Local) evicted(key string, value any, reason EvictReason) {
+	recordLocalEvict(key, estimateSize(value), reason)
+	if e, ok := value.(entry); ok {
+		// the entries stored by Load are internal
+		value = e.Value
+	}
+	for _, f := range l.onEvict {
+		f(key, value, reason)
+	}
+}
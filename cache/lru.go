@@ -0,0 +1,336 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// EvictReason is the reason why an item is removed from Cache
+type EvictReason int
+
+const (
+	// EvictExpired means the item removed for its ttl passed
+	EvictExpired EvictReason = iota
+	// EvictCapacity means the item removed for the cache is over its byte budget
+	EvictCapacity
+	// EvictRemoved means the item removed explicitly
+	EvictRemoved
+	// EvictReplaced means the item replaced by a new value of the same key
+	EvictReplaced
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictExpired:
+		return "expired"
+	case EvictCapacity:
+		return "capacity"
+	case EvictRemoved:
+		return "removed"
+	case EvictReplaced:
+		return "replaced"
+	}
+	return "unknown"
+}
+
+// Sizer is implemented by the values which know their size in bytes
+type Sizer interface {
+	Size() int64
+}
+
+// Config of Cache
+type Config[K comparable, V any] struct {
+	// MaxBytes is the budget of the total size of the values, the least recently used items are evicted when it's exceeded.
+	// 0 means unlimited.
+	MaxBytes int64
+	// Sizer return the size of value in bytes, it's used for the values not implementing Sizer.
+	// The []byte and string are measured by length, and the others are assumed to be 128 bytes if it's nil.
+	Sizer func(key K, value V) int64
+	// OnEvict is called after the item removed from cache, it's called without holding the cache lock.
+	OnEvict func(key K, value V, reason EvictReason)
+	// CleanupInterval is the interval of removing expired items in background, 0 disables the background cleanup,
+	// the expired items are still removed on reading.
+	CleanupInterval time.Duration
+	// Group return the group of key, the keys are indexed by group so that RemoveGroup removes them without scanning the whole cache.
+	// nil disables the index.
+	Group func(key K) string
+}
+
+// Cache is a typed LRU cache with ttl for each item, the size of it is limited by the total bytes of values
+type Cache[K comparable, V any] struct {
+	cfg   Config[K, V]
+	lock  sync.Mutex
+	items map[K]*list.Element
+	// groups index the elements by Config.Group
+	groups map[string]map[K]*list.Element
+	order  *list.List // the front is the most recently used
+	bytes  int64
+	stop   chan struct{}
+	once   sync.Once
+}
+
+type item[K comparable, V any] struct {
+	key    K
+	value  V
+	size   int64
+	expire time.Time
+}
+
+type evicted[K comparable, V any] struct {
+	item   *item[K, V]
+	reason EvictReason
+}
+
+// New create a cache by cfg, Close should be called to stop the background cleanup if CleanupInterval is set
+func New[K comparable, V any](cfg Config[K, V]) *Cache[K, V] {
+	c := &Cache[K, V]{
+		cfg:    cfg,
+		items:  make(map[K]*list.Element),
+		groups: make(map[string]map[K]*list.Element),
+		order:  list.New(),
+		stop:   make(chan struct{}),
+	}
+	if cfg.CleanupInterval > 0 {
+		go c.cleanup(cfg.CleanupInterval)
+	}
+	return c
+}
+
+// Get the value of key, false if not found or expired
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	var (
+		zero    V
+		removed []evicted[K, V]
+	)
+	defer func() { c.notify(removed) }()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return zero, false
+	}
+	it := elem.Value.(*item[K, V])
+	if it.expire.Before(time.Now()) {
+		removed = append(removed, c.removeElement(elem, EvictExpired))
+		return zero, false
+	}
+	c.order.MoveToFront(elem)
+	return it.value, true
+}
+
+// Peek get the value of key without updating the recentness, false if not found or expired
+func (c *Cache[K, V]) Peek(key K) (V, bool) {
+	var zero V
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return zero, false
+	}
+	it := elem.Value.(*item[K, V])
+	if it.expire.Before(time.Now()) {
+		return zero, false
+	}
+	return it.value, true
+}
+
+// Set the value of key which expires after ttl, the least recently used items are evicted if the budget exceeded.
+func (c *Cache[K, V]) Set(key K, value V, ttl time.Duration) {
+	var removed []evicted[K, V]
+	defer func() { c.notify(removed) }()
+
+	it := &item[K, V]{
+		key:    key,
+		value:  value,
+		size:   c.sizeOf(key, value),
+		expire: time.Now().Add(ttl),
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if elem, ok := c.items[key]; ok {
+		removed = append(removed, c.removeElement(elem, EvictReplaced))
+	}
+	elem := c.order.PushFront(it)
+	c.items[key] = elem
+	if c.cfg.Group != nil {
+		group := c.cfg.Group(key)
+		if c.groups[group] == nil {
+			c.groups[group] = make(map[K]*list.Element)
+		}
+		c.groups[group][key] = elem
+	}
+	c.bytes += it.size
+	for c.cfg.MaxBytes > 0 && c.bytes > c.cfg.MaxBytes && c.order.Len() > 0 {
+		removed = append(removed, c.removeElement(c.order.Back(), EvictCapacity))
+	}
+}
+
+// Remove the key, return false if the key not found
+func (c *Cache[K, V]) Remove(key K) bool {
+	var removed []evicted[K, V]
+	defer func() { c.notify(removed) }()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return false
+	}
+	removed = append(removed, c.removeElement(elem, EvictRemoved))
+	return true
+}
+
+// RemoveFunc remove all the keys which match returns true, return the count of removed items
+func (c *Cache[K, V]) RemoveFunc(match func(key K) bool) int {
+	var removed []evicted[K, V]
+	defer func() { c.notify(removed) }()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for key, elem := range c.items {
+		if match(key) {
+			removed = append(removed, c.removeElement(elem, EvictRemoved))
+		}
+	}
+	return len(removed)
+}
+
+// RemoveGroup remove the keys of group which match returns true, return the count of removed items.
+// Only the keys of group are scanned, it's same as RemoveFunc if Config.Group is nil.
+func (c *Cache[K, V]) RemoveGroup(group string, match func(key K) bool) int {
+	if c.cfg.Group == nil {
+		return c.RemoveFunc(match)
+	}
+	var removed []evicted[K, V]
+	defer func() { c.notify(removed) }()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for key, elem := range c.groups[group] {
+		if match(key) {
+			removed = append(removed, c.removeElement(elem, EvictRemoved))
+		}
+	}
+	return len(removed)
+}
+
+// Expire remove all the expired items
+func (c *Cache[K, V]) Expire() {
+	var removed []evicted[K, V]
+	defer func() { c.notify(removed) }()
+
+	now := time.Now()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, elem := range c.items {
+		if elem.Value.(*item[K, V]).expire.Before(now) {
+			removed = append(removed, c.removeElement(elem, EvictExpired))
+		}
+	}
+}
+
+// Keys return all the keys in cache, from the most recently used to the least
+func (c *Cache[K, V]) Keys() []K {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	keys := make([]K, 0, c.order.Len())
+	for elem := c.order.Front(); elem != nil; elem = elem.Next() {
+		keys = append(keys, elem.Value.(*item[K, V]).key)
+	}
+	return keys
+}
+
+// Range calls f for each item in cache from the most recently used to the least, until f returns false.
+// f is called with the lock held, so it must not call the methods of the cache.
+func (c *Cache[K, V]) Range(f func(key K, value V, size int64, expire time.Time) bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for elem := c.order.Front(); elem != nil; elem = elem.Next() {
+		it := elem.Value.(*item[K, V])
+		if !f(it.key, it.value, it.size, it.expire) {
+			return
+		}
+	}
+}
+
+// Len return the count of items in cache, including the expired ones not removed yet
+func (c *Cache[K, V]) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.order.Len()
+}
+
+// Bytes return the total size of values in cache
+func (c *Cache[K, V]) Bytes() int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.bytes
+}
+
+// Close stop the background cleanup, the cache is still usable after closed
+func (c *Cache[K, V]) Close() error {
+	c.once.Do(func() { close(c.stop) })
+	return nil
+}
+
+func (c *Cache[K, V]) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.Expire()
+		}
+	}
+}
+
+// removeElement remove elem from cache, the lock must be held
+func (c *Cache[K, V]) removeElement(elem *list.Element, reason EvictReason) evicted[K, V] {
+	it := elem.Value.(*item[K, V])
+	c.order.Remove(elem)
+	delete(c.items, it.key)
+	if c.cfg.Group != nil {
+		group := c.cfg.Group(it.key)
+		if delete(c.groups[group], it.key); len(c.groups[group]) == 0 {
+			delete(c.groups, group)
+		}
+	}
+	c.bytes -= it.size
+	return evicted[K, V]{item: it, reason: reason}
+}
+
+func (c *Cache[K, V]) notify(removed []evicted[K, V]) {
+	if c.cfg.OnEvict == nil {
+		return
+	}
+	for _, e := range removed {
+		c.cfg.OnEvict(e.item.key, e.item.value, e.reason)
+	}
+}
+
+func (c *Cache[K, V]) sizeOf(key K, value V) int64 {
+	if s, ok := any(value).(Sizer); ok {
+		return s.Size()
+	}
+	if c.cfg.Sizer != nil {
+		return c.cfg.Sizer(key, value)
+	}
+	return estimateSize(value)
+}
+
+// estimateSize measure []byte and string by length, and assumes the others are 128 bytes
+func estimateSize(value any) int64 {
+	switch v := value.(type) {
+	case []byte:
+		return int64(len(v))
+	case string:
+		return int64(len(v))
+	case Sizer:
+		return v.Size()
+	}
+	return 128
+}
@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloudfly/ormx/test"
+)
+
+type sized int64
+
+func (s sized) Size() int64 { return int64(s) }
+
+func TestCacheBytes(t *testing.T) {
+	evicted := map[string]EvictReason{}
+	c := New(Config[string, sized]{
+		MaxBytes: 100,
+		OnEvict: func(key string, _ sized, reason EvictReason) {
+			evicted[key] = reason
+		},
+	})
+	defer c.Close()
+
+	c.Set("a", 40, time.Minute)
+	c.Set("b", 40, time.Minute)
+	test.Equal(t, int64(80), c.Bytes())
+
+	// a is recently used, so b is evicted
+	_, ok := c.Get("a")
+	test.Equal(t, true, ok)
+	c.Set("c", 40, time.Minute)
+	test.Equal(t, int64(80), c.Bytes())
+	test.Equal(t, []string{"c", "a"}, c.Keys())
+	test.Equal(t, map[string]EvictReason{"b": EvictCapacity}, evicted)
+
+	// replacing the value updates the bytes
+	c.Set("a", 10, time.Minute)
+	test.Equal(t, int64(50), c.Bytes())
+	test.Equal(t, EvictReplaced, evicted["a"])
+
+	c.Set("d", 10, -time.Second)
+	_, ok = c.Get("d")
+	test.Equal(t, false, ok)
+	test.Equal(t, EvictExpired, evicted["d"])
+
+	test.Equal(t, 1, c.RemoveFunc(func(key string) bool { return key == "c" }))
+	test.Equal(t, int64(10), c.Bytes())
+	test.Equal(t, 1, c.Len())
+}
+
+func TestCacheGroup(t *testing.T) {
+	c := New(Config[string, string]{
+		Group: func(key string) string { return key[:1] },
+	})
+	for _, key := range []string{"a1", "a2", "b1"} {
+		c.Set(key, key, time.Minute)
+	}
+	// replacing the value keeps the key in its group
+	c.Set("a1", "a1", time.Minute)
+
+	scanned := 0
+	test.Equal(t, 2, c.RemoveGroup("a", func(string) bool { scanned++; return true }))
+	test.Equal(t, 2, scanned)
+	test.Equal(t, []string{"b1"}, c.Keys())
+	test.Equal(t, 0, c.RemoveGroup("a", func(string) bool { return true }))
+	test.Equal(t, 1, len(c.groups))
+}
+
+func TestCacheSizer(t *testing.T) {
+	c := New(Config[int, []int]{
+		Sizer: func(_ int, v []int) int64 { return int64(len(v) * 8) },
+	})
+	c.Set(1, []int{1, 2, 3}, time.Minute)
+	test.Equal(t, int64(24), c.Bytes())
+
+	s := New(Config[int, string]{})
+	s.Set(1, "hello", time.Minute)
+	test.Equal(t, int64(5), s.Bytes())
+}
+
+func TestCacheCleanup(t *testing.T) {
+	c := New(Config[int, string]{CleanupInterval: time.Millisecond * 10})
+	c.Set(1, "a", time.Millisecond)
+	time.Sleep(time.Millisecond * 100)
+	test.Equal(t, 0, c.Len())
+	test.NoError(t, c.Close())
+	test.NoError(t, c.Close())
+}
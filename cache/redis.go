@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis is the backend stores items in redis(or any server speaks redis protocol), it's also a Broadcaster by using redis pub/sub
+type Redis struct {
+	client  redis.UniversalClient
+	prefix  string
+	channel string
+}
+
+// NewRedis create a redis backend, all the keys are prefixed by prefix in redis, so that multiple applications can share one redis
+func NewRedis(client redis.UniversalClient, prefix string) *Redis {
+	return &Redis{
+		client:  client,
+		prefix:  prefix,
+		channel: prefix + "ormx:cache:invalidate",
+	}
+}
+
+// Get implements Backend
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	content, err := r.client.Get(ctx, r.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return content, true, nil
+}
+
+// Set implements Backend
+func (r *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return r.client.Set(ctx, r.prefix+key, value, ttl).Err()
+}
+
+// Delete implements Backend
+func (r *Redis) Delete(ctx context.Context, key string) error {
+	return r.client.Del(ctx, r.prefix+key).Err()
+}
+
+// DeleteByPrefix implements Backend, it scans the matched keys and deletes them in batch
+func (r *Redis) DeleteByPrefix(ctx context.Context, prefix string) error {
+	var (
+		iter = r.client.Scan(ctx, 0, escapeGlob(r.prefix+prefix)+"*", 512).Iterator()
+		keys = make([]string, 0, 512)
+	)
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) >= 512 {
+			if err := r.client.Del(ctx, keys...).Err(); err != nil {
+				return err
+			}
+			keys = keys[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		return r.client.Del(ctx, keys...).Err()
+	}
+	return nil
+}
+
+// Publish implements Broadcaster
+func (r *Redis) Publish(ctx context.Context, msg string) error {
+	return r.client.Publish(ctx, r.channel, msg).Err()
+}
+
+// Subscribe implements Broadcaster
+func (r *Redis) Subscribe(ctx context.Context, handle func(msg string)) (func(), error) {
+	ps := r.client.Subscribe(ctx, r.channel)
+	// wait for the subscription confirmed
+	if _, err := ps.Receive(ctx); err != nil {
+		ps.Close()
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		defer ps.Close()
+		ch := ps.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				handle(msg.Payload)
+			}
+		}
+	}()
+	return cancel, nil
+}
+
+// escapeGlob escape the special characters of redis glob pattern
+func escapeGlob(s string) string {
+	if !strings.ContainsAny(s, `*?[]\`) {
+		return s
+	}
+	b := &strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/cloudfly/ormx/test"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedis(t *testing.T, prefix string) *Redis {
+	s := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewRedis(client, prefix)
+}
+
+func TestRedis(t *testing.T) {
+	var (
+		ctx = context.Background()
+		r   = newTestRedis(t, "app:")
+	)
+
+	_, ok, err := r.Get(ctx, "user/1")
+	test.NoError(t, err)
+	test.Equal(t, false, ok)
+
+	test.NoError(t, r.Set(ctx, "user/1", []byte("a"), time.Minute))
+	test.NoError(t, r.Set(ctx, "user/1/x", []byte("b"), time.Minute))
+	test.NoError(t, r.Set(ctx, "user/10", []byte("c"), time.Minute))
+	test.NoError(t, r.Set(ctx, "user*/1", []byte("d"), time.Minute))
+
+	content, ok, err := r.Get(ctx, "user/1")
+	test.NoError(t, err)
+	test.Equal(t, true, ok)
+	test.Equal(t, []byte("a"), content)
+
+	test.NoError(t, r.DeleteByPrefix(ctx, "user/1/"))
+	_, ok, _ = r.Get(ctx, "user/1/x")
+	test.Equal(t, false, ok)
+	_, ok, _ = r.Get(ctx, "user/10")
+	test.Equal(t, true, ok)
+
+	// glob characters in prefix are matched literally
+	test.NoError(t, r.DeleteByPrefix(ctx, "user*"))
+	_, ok, _ = r.Get(ctx, "user*/1")
+	test.Equal(t, false, ok)
+	_, ok, _ = r.Get(ctx, "user/10")
+	test.Equal(t, true, ok)
+
+	test.NoError(t, r.Delete(ctx, "user/10"))
+	_, ok, _ = r.Get(ctx, "user/10")
+	test.Equal(t, false, ok)
+}
+
+func TestRedisVersion(t *testing.T) {
+	test.NoError(t, Init())
+	SetBackend(newTestRedis(t, "app:"))
+	defer SetBackend(local)
+
+	v := Version("user", 1)
+	test.Equal(t, v, Version("user", 1))
+	content, ok, err := remote().Get(context.Background(), "user/1/ormx:version")
+	test.NoError(t, err)
+	test.Equal(t, true, ok)
+	test.Equal(t, v, string(content))
+	test.Equal(t, true, Bump("user", 1) != v)
+}
+
+func TestTiered(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		remote = newTestRedis(t, "")
+	)
+	newNode := func() *Tiered {
+		node, err := NewTiered(ctx, NewLocal(1<<20), remote, remote, time.Minute)
+		test.NoError(t, err)
+		t.Cleanup(func() { node.Close() })
+		return node
+	}
+	a, b := newNode(), newNode()
+
+	test.NoError(t, a.Set(ctx, "user/1", []byte("v1"), time.Minute))
+	content, ok, err := b.Get(ctx, "user/1")
+	test.NoError(t, err)
+	test.Equal(t, true, ok)
+	test.Equal(t, []byte("v1"), content)
+
+	// b keeps a local copy, it should be dropped by the invalidation from a
+	test.NoError(t, a.Set(ctx, "user/1", []byte("v2"), time.Minute))
+	eventually(t, func() bool {
+		content, _, _ := b.Get(ctx, "user/1")
+		return string(content) == "v2"
+	})
+
+	test.NoError(t, a.DeleteByPrefix(ctx, "user/"))
+	eventually(t, func() bool {
+		_, ok, _ := b.Get(ctx, "user/1")
+		return !ok
+	})
+}
+
+func TestSetBackend(t *testing.T) {
+	test.NoError(t, Init())
+	SetBackend(newTestRedis(t, ""))
+	defer SetBackend(local)
+
+	Set(time.Minute, "user", 1, map[string]int{"id": 1})
+	v, ok := Get("user", 1)
+	test.Equal(t, true, ok)
+	test.Equal(t, []byte(`{"id":1}`), v)
+
+	var dest map[string]int
+	test.NoError(t, Try(&dest, func() error { dest = map[string]int{"id": 2}; return nil }, time.Minute, "user", 2))
+	dest = nil
+	test.NoError(t, Try(&dest, func() error { panic("should hit cache") }, time.Minute, "user", 2))
+	test.Equal(t, map[string]int{"id": 2}, dest)
+
+	RemovePrefix("user")
+	_, ok = Get("user", 1)
+	test.Equal(t, false, ok)
+	_, ok = Get("user", 2)
+	test.Equal(t, false, ok)
+}
+
+func eventually(t *testing.T, f func() bool) {
+	deadline := time.Now().Add(time.Second * 3)
+	for !f() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not satisfied in time")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stat is the statistics of the cache items sharing the same first key segment, which is the table name for ormx.
+//
+// Entries and Bytes are counted on the local cache only.
+type Stat struct {
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+	Sets    int64 `json:"sets"`
+	Removes int64 `json:"removes"`
+	// Expired is the count of items evicted for ttl passed
+	Expired int64 `json:"expired"`
+	// Evicted is the count of items evicted for the capacity exceeded
+	Evicted int64 `json:"evicted"`
+	Entries int64 `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+}
+
+type counters struct {
+	hits, misses, sets, removes, expired, evicted, entries, bytes atomic.Int64
+}
+
+var stats sync.Map // first key segment => *counters
+
+// firstSegment return the first segment of key, which is the table name for ormx
+func firstSegment(key string) string {
+	segment, _, _ := strings.Cut(key, "/")
+	return segment
+}
+
+func statOf(key string) *counters {
+	segment := firstSegment(key)
+	if c, ok := stats.Load(segment); ok {
+		return c.(*counters)
+	}
+	c, _ := stats.LoadOrStore(segment, &counters{})
+	return c.(*counters)
+}
+
+func recordGet(key string, hit bool) {
+	if hit {
+		statOf(key).hits.Add(1)
+	} else {
+		statOf(key).misses.Add(1)
+	}
+}
+
+func recordSet(key string) {
+	statOf(key).sets.Add(1)
+}
+
+// recordLocalSet account the item stored into local cache
+func recordLocalSet(key string, size int64) {
+	c := statOf(key)
+	c.entries.Add(1)
+	c.bytes.Add(size)
+}
+
+// recordLocalEvict account the item removed from local cache
+func recordLocalEvict(key string, size int64, reason EvictReason) {
+	c := statOf(key)
+	c.entries.Add(-1)
+	c.bytes.Add(-size)
+	switch reason {
+	case EvictExpired:
+		c.expired.Add(1)
+	case EvictCapacity:
+		c.evicted.Add(1)
+	case EvictRemoved:
+		c.removes.Add(1)
+	}
+}
+
+// recordLocalDrop account the items of the local cache dropped as a whole, such as the one replaced by Init
+func recordLocalDrop(l *Local) {
+	l.cache.Range(func(key string, value any, _ int64, _ time.Time) bool {
+		c := statOf(key)
+		c.entries.Add(-1)
+		c.bytes.Add(-estimateSize(value))
+		return true
+	})
+}
+
+// Stats return the statistics of cache grouped by the first key segment
+func Stats() map[string]Stat {
+	result := map[string]Stat{}
+	stats.Range(func(k, v any) bool {
+		c := v.(*counters)
+		result[k.(string)] = Stat{
+			Hits:    c.hits.Load(),
+			Misses:  c.misses.Load(),
+			Sets:    c.sets.Load(),
+			Removes: c.removes.Load(),
+			Expired: c.expired.Load(),
+			Evicted: c.evicted.Load(),
+			Entries: c.entries.Load(),
+			Bytes:   c.bytes.Load(),
+		}
+		return true
+	})
+	return result
+}
+
+// KeyInfo is the item info returned by Dump
+type KeyInfo struct {
+	Key    string    `json:"key"`
+	Size   int64     `json:"size"`
+	Expire time.Time `json:"expire"`
+}
+
+// Dump return the items whose key starts with prefix in local cache, sorted by key. It's for debugging.
+func Dump(prefix string) []KeyInfo {
+	var items []KeyInfo
+	local.cache.Range(func(key string, _ any, size int64, expire time.Time) bool {
+		if strings.HasPrefix(key, prefix) {
+			items = append(items, KeyInfo{Key: key, Size: size, Expire: expire})
+		}
+		return true
+	})
+	sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+	return items
+}
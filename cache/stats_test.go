@@ -0,0 +1,40 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloudfly/ormx/test"
+)
+
+func TestStats(t *testing.T) {
+	test.NoError(t, Init())
+
+	Set(time.Minute, "stats", 1, []byte("abc"))
+	Set(time.Minute, "stats", 2, []byte("de"))
+	Set(-time.Second, "stats", 3, []byte("f"))
+	Get("stats", 1)
+	Get("stats", 3)
+	Get("stats", 4)
+	Remove("stats", 2)
+
+	stat := Stats()["stats"]
+	test.Equal(t, int64(3), stat.Sets)
+	test.Equal(t, int64(1), stat.Hits)
+	test.Equal(t, int64(2), stat.Misses)
+	test.Equal(t, int64(1), stat.Expired)
+	test.Equal(t, int64(1), stat.Removes)
+	test.Equal(t, int64(1), stat.Entries)
+	test.Equal(t, int64(3), stat.Bytes)
+
+	items := Dump("stats/")
+	test.Equal(t, 1, len(items))
+	test.Equal(t, "stats/1", items[0].Key)
+	test.Equal(t, int64(3), items[0].Size)
+
+	// the items of the replaced local cache are not counted
+	test.NoError(t, Init())
+	stat = Stats()["stats"]
+	test.Equal(t, int64(0), stat.Entries)
+	test.Equal(t, int64(0), stat.Bytes)
+}
@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"strings"
+	"time"
+)
+
+// Tiered is a two-tier backend, it reads from the local tier first, and falls back to the remote tier which shared by all replicas.
+//
+// The writes are applied on both tiers, and broadcasted to the other replicas, so that they drop their local copies.
+type Tiered struct {
+	local    Backend
+	remote   Backend
+	bus      Broadcaster
+	localTTL time.Duration
+	id       string
+	stop     func()
+}
+
+// NewTiered create a two-tier backend, the items read from remote are kept in local for at most localTTL.
+//
+// The invalidations are broadcasted through bus, it can be nil if there is only one replica.
+func NewTiered(ctx context.Context, local, remote Backend, bus Broadcaster, localTTL time.Duration) (*Tiered, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	t := &Tiered{
+		local:    local,
+		remote:   remote,
+		bus:      bus,
+		localTTL: localTTL,
+		id:       hex.EncodeToString(id),
+	}
+	if bus != nil {
+		stop, err := bus.Subscribe(ctx, t.handle)
+		if err != nil {
+			return nil, err
+		}
+		t.stop = stop
+	}
+	return t, nil
+}
+
+// Get implements Backend
+func (t *Tiered) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	if content, ok, err := t.local.Get(ctx, key); err == nil && ok {
+		return content, true, nil
+	}
+	content, ok, err := t.remote.Get(ctx, key)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	_ = t.local.Set(ctx, key, content, t.localTTL)
+	return content, true, nil
+}
+
+// Set implements Backend
+func (t *Tiered) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := t.remote.Set(ctx, key, value, ttl); err != nil {
+		return err
+	}
+	if err := t.local.Set(ctx, key, value, min(ttl, t.localTTL)); err != nil {
+		return err
+	}
+	return t.publish(ctx, 'd', key)
+}
+
+// Delete implements Backend
+func (t *Tiered) Delete(ctx context.Context, key string) error {
+	if err := t.remote.Delete(ctx, key); err != nil {
+		return err
+	}
+	if err := t.local.Delete(ctx, key); err != nil {
+		return err
+	}
+	return t.publish(ctx, 'd', key)
+}
+
+// DeleteByPrefix implements Backend
+func (t *Tiered) DeleteByPrefix(ctx context.Context, prefix string) error {
+	if err := t.remote.DeleteByPrefix(ctx, prefix); err != nil {
+		return err
+	}
+	if err := t.local.DeleteByPrefix(ctx, prefix); err != nil {
+		return err
+	}
+	return t.publish(ctx, 'p', prefix)
+}
+
+// Close stop receiving the invalidations from other replicas, and close the local tier if it's closable
+func (t *Tiered) Close() error {
+	if t.stop != nil {
+		t.stop()
+	}
+	if c, ok := t.local.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// publish the invalidation message, the format is "<sender id> <op> <key>", op is 'd' for key and 'p' for prefix
+func (t *Tiered) publish(ctx context.Context, op byte, key string) error {
+	if t.bus == nil {
+		return nil
+	}
+	return t.bus.Publish(ctx, t.id+" "+string(op)+" "+key)
+}
+
+func (t *Tiered) handle(msg string) {
+	sender, msg, ok := strings.Cut(msg, " ")
+	if !ok || sender == t.id {
+		return
+	}
+	op, key, ok := strings.Cut(msg, " ")
+	if !ok {
+		return
+	}
+	ctx := context.Background()
+	switch op {
+	case "d":
+		_ = t.local.Delete(ctx, key)
+	case "p":
+		_ = t.local.DeleteByPrefix(ctx, key)
+	}
+}
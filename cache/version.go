@@ -0,0 +1,50 @@
+package cache
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// versionSegment is the last key segment of the versions stored in cache
+const versionSegment = "ormx:version"
+
+// versionTTL is the ttl of the versions, the items cached longer than it are missed after their version expired
+const versionTTL = time.Hour
+
+// Version return the version of keys, put it into the keys of the items so that Bump invalidates them together without scanning the keys.
+// The version is stored in the backend, so it's shared by the replicas using the same remote backend.
+// A new version is created if it's missing, such as evicted, so the items cached with the lost version are never read again.
+func Version(keys ...any) string {
+	key := versionKey(keys)
+	if r := remote(); r != nil {
+		if content, ok, err := r.Get(context.Background(), key); err == nil && ok {
+			return string(content)
+		}
+	} else if v, ok := local.get(key); ok {
+		if version, ok := v.(string); ok {
+			return version
+		}
+	}
+	return Bump(keys...)
+}
+
+// Bump replace the version of keys by a new one and return it, the items cached with the previous version are no longer read,
+// they are removed after their ttl expired.
+func Bump(keys ...any) string {
+	var (
+		key     = versionKey(keys)
+		version = strconv.FormatUint(rand.Uint64(), 36)
+	)
+	if r := remote(); r != nil {
+		_ = r.Set(context.Background(), key, []byte(version), versionTTL)
+	} else {
+		local.set(key, version, versionTTL)
+	}
+	return version
+}
+
+func versionKey(keys []any) string {
+	return joinSlice(append(keys[:len(keys):len(keys)], versionSegment), "/")
+}
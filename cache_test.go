@@ -0,0 +1,184 @@
+package ormx
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cloudfly/ormx/cache"
+	"github.com/cloudfly/ormx/test"
+)
+
+func TestRowCacheKey(t *testing.T) {
+	ctx := context.Background()
+	key := rowCacheKey(ctx, "test", int64(1), &TestRow{})
+	test.Equal(t, []any{"test", "", int64(1), "", "ormx.TestRow"}, append(key[:3:3], key[4:]...))
+	// the versions are kept until invalidated
+	test.Equal(t, key, rowCacheKey(ctx, "test", int64(1), &TestRow{}))
+	key = rowCacheKey(WithNamespace(ctx, "default"), "test", int64(1), &TestRow{})
+	test.Equal(t, []any{"test", "", int64(1), "default", "ormx.TestRow"}, append(key[:3:3], key[4:]...))
+	key = rowCacheKey(WithDataSource(ctx, "archive"), "test", int64(1), &TestRow{})
+	test.Equal(t, []any{"test", "archive", int64(1), "", "ormx.TestRow"}, append(key[:3:3], key[4:]...))
+}
+
+func TestInvalidateRows(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		archive = WithDataSource(ctx, "archive")
+		keys    = func() [][]any {
+			return [][]any{
+				rowCacheKey(ctx, "test", int64(1), &TestRow{}),
+				rowCacheKey(WithNamespace(ctx, "default"), "test", int64(1), &TestRow{}),
+				rowCacheKey(ctx, "test", int64(10), &TestRow{}),
+				rowCacheKey(archive, "test", int64(1), &TestRow{}),
+				queryCacheKey(ctx, "test", "SELECT * FROM test", nil),
+			}
+		}
+		cached = func() []bool {
+			var ok []bool
+			for _, key := range keys() {
+				_, found := cache.Get(key...)
+				ok = append(ok, found)
+			}
+			return ok
+		}
+	)
+	for _, key := range keys() {
+		cache.Set(time.Minute, append(key, []byte("{}"))...)
+	}
+	test.Equal(t, []bool{true, true, true, true, true}, cached())
+
+	// the rows of the other data source are kept
+	invalidateRows(ctx, "test", int64(1))
+	test.Equal(t, []bool{false, false, true, true, false}, cached())
+
+	// the ids passed as a slice are flattened
+	for _, key := range keys() {
+		cache.Set(time.Minute, append(key, []byte("{}"))...)
+	}
+	invalidateRows(ctx, "test", []int64{1, 2})
+	test.Equal(t, []bool{false, false, true, true, false}, cached())
+
+	invalidateRows(ctx, "test")
+	test.Equal(t, []bool{false, false, false, true, false}, cached())
+}
+
+func TestCacheTTLOf(t *testing.T) {
+	test.Equal(t, 10*time.Second, cacheTTLOf("test_cache_ttl"))
+	SetCacheTTL("test_cache_ttl", time.Minute)
+	test.Equal(t, time.Minute, cacheTTLOf("test_cache_ttl"))
+	SetCacheTTL("test_cache_ttl", 0)
+	test.Equal(t, time.Duration(0), cacheTTLOf("test_cache_ttl"))
+}
+
+func TestFilterIDs(t *testing.T) {
+	ids, ok := filterIDs([]int64{1, 2})
+	test.Equal(t, true, ok)
+	test.Equal(t, []any{int64(1), int64(2)}, ids)
+
+	ids, ok = filterIDs(int64(3))
+	test.Equal(t, true, ok)
+	test.Equal(t, []any{int64(3)}, ids)
+
+	_, ok = filterIDs(KVs{{Key: "id", Value: 1}})
+	test.Equal(t, false, ok)
+
+	test.Equal(t, []any{int64(5)}, primaryKeyValues(TestRow{ID: 5}, &TestRow{}))
+}
+
+func TestCachedQuery(t *testing.T) {
+	var (
+		ctx     = WithCache(context.Background(), time.Minute)
+		queries = 0
+		query   = func(dst *[]TestRow) func() error {
+			return func() error {
+				queries++
+				*dst = []TestRow{{ID: int64(queries)}}
+				return nil
+			}
+		}
+		statement = "SELECT id FROM test WHERE action = ?"
+	)
+
+	var rows []TestRow
+	test.NoError(t, cachedQuery(ctx, "test", &rows, statement, []any{"a"}, query(&rows)))
+	test.Equal(t, int64(1), rows[0].ID)
+
+	var cached []TestRow
+	test.NoError(t, cachedQuery(ctx, "test", &cached, statement, []any{"a"}, query(&cached)))
+	test.Equal(t, 1, queries)
+	test.Equal(t, int64(1), cached[0].ID)
+
+	// different args, namespace or master is not served from the cache
+	test.NoError(t, cachedQuery(ctx, "test", &rows, statement, []any{"b"}, query(&rows)))
+	test.NoError(t, cachedQuery(WithNamespace(ctx, "default"), "test", &rows, statement, []any{"a"}, query(&rows)))
+	test.NoError(t, cachedQuery(FromMaster(ctx), "test", &rows, statement, []any{"a"}, query(&rows)))
+	test.Equal(t, 4, queries)
+
+	invalidateRows(context.Background(), "test", int64(1))
+	test.NoError(t, cachedQuery(ctx, "test", &rows, statement, []any{"a"}, query(&rows)))
+	test.Equal(t, 5, queries)
+	test.Equal(t, int64(5), rows[0].ID)
+
+	// no cache without WithCache
+	test.NoError(t, cachedQuery(context.Background(), "test", &rows, statement, []any{"a"}, query(&rows)))
+	test.Equal(t, 6, queries)
+}
+
+// jsonlessRow is the row whose message is dropped by json, to tell whether GetByID filled it from the cached json
+type jsonlessRow struct {
+	ID      int64  `db:"id"`
+	Message string `db:"message" json:"-"`
+}
+
+func (jsonlessRow) Table() string { return "test" }
+
+func TestGetByIDCache(t *testing.T) {
+	useSQLite(t)
+	test.NoError(t, cache.Init())
+	var (
+		ctx    = context.Background()
+		events = recordEvents(t)
+	)
+	id, err := InsertOne(ctx, "", TestRow{Producer: "unittest", Resource: "cache", Action: "test", Message: "hello"})
+	test.NoError(t, err)
+
+	// the row loaded by the call is copied into dst
+	var row jsonlessRow
+	test.NoError(t, GetByID(ctx, &row, "", id))
+	test.Equal(t, jsonlessRow{ID: id, Message: "hello"}, row)
+	test.Equal(t, false, (*events)[len(*events)-1].CacheHit)
+
+	// the cached row is copied into dst the same as the loaded one
+	row = jsonlessRow{}
+	test.NoError(t, GetByID(ctx, &row, "", id))
+	test.Equal(t, jsonlessRow{ID: id, Message: "hello"}, row)
+	test.Equal(t, true, (*events)[len(*events)-1].CacheHit)
+}
+
+func TestGetByIDNegativeCache(t *testing.T) {
+	useSQLite(t)
+	test.NoError(t, cache.Init())
+	prev := cacheNegativeTTL.Msecs
+	cacheNegativeTTL.Msecs = time.Minute.Milliseconds()
+	t.Cleanup(func() { cacheNegativeTTL.Msecs = prev })
+	ctx := context.Background()
+
+	// the not found of the ids inserted with auto increment are invalidated
+	var row TestRow
+	for _, id := range []int64{1, 2, 3} {
+		test.Equal(t, true, IsNotFound(GetByID(ctx, &row, "", id)))
+	}
+	test.NoError(t, InsertMany(ctx, "", TestRow{Producer: "unittest", Message: "a"}, TestRow{Producer: "unittest", Message: "b"}))
+	test.NoError(t, GetByID(ctx, &row, "", 1))
+	test.Equal(t, "a", row.Message)
+	test.NoError(t, GetByID(ctx, &row, "", 2))
+	test.Equal(t, "b", row.Message)
+	test.Equal(t, true, IsNotFound(GetByID(ctx, &row, "", 3)))
+
+	id, err := InsertOne(ctx, "", TestRow{Producer: "unittest", Message: "c"})
+	test.NoError(t, err)
+	test.Equal(t, int64(3), id)
+	test.NoError(t, GetByID(ctx, &row, "", 3))
+	test.Equal(t, "c", row.Message)
+}
@@ -0,0 +1,160 @@
+package ormx
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/cloudfly/ormx/sqlparse"
+	sb "github.com/huandu/go-sqlbuilder"
+)
+
+var identPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+type rawExprCtxKey struct{}
+
+// AllowRaw mark the exprs as trusted sql expressions, the query helpers will put them into fields or sort as it is,
+// without validating and quoting. Only use it for expressions written in code, never for user input.
+func AllowRaw(ctx context.Context, exprs ...string) context.Context {
+	allowed := map[string]struct{}{}
+	if prev, ok := ctx.Value(rawExprCtxKey{}).(map[string]struct{}); ok {
+		for expr := range prev {
+			allowed[expr] = struct{}{}
+		}
+	}
+	for _, expr := range exprs {
+		allowed[expr] = struct{}{}
+	}
+	return context.WithValue(ctx, rawExprCtxKey{}, allowed)
+}
+
+// isRawAllowed return true if expr is allowed by AllowRaw in ctx
+func isRawAllowed(ctx context.Context, expr string) bool {
+	allowed, ok := ctx.Value(rawExprCtxKey{}).(map[string]struct{})
+	if !ok {
+		return false
+	}
+	_, ok = allowed[expr]
+	return ok
+}
+
+// Dialect return the sql flavor used to quote identifiers, it's decided by flag database.dialect,
+// and falls back to database.driver if the dialect is not specified.
+func Dialect() sb.Flavor {
+	name := *dialect
+	if name == "" {
+		name = *dbDriver
+	}
+	switch strings.ToLower(name) {
+	case "sqlite", "sqlite3":
+		return sb.SQLite
+	case "postgres", "postgresql", "pgx":
+		return sb.PostgreSQL
+	case "sqlserver", "mssql":
+		return sb.SQLServer
+	case "clickhouse":
+		return sb.ClickHouse
+	}
+	return sb.MySQL
+}
+
+// lexicalDialect return the dialect of sqlparse for Dialect
+func lexicalDialect() sqlparse.Dialect {
+	if Dialect() == sb.MySQL {
+		return sqlparse.MySQL
+	}
+	return sqlparse.Standard
+}
+
+// QuoteColumn quote the column name according to the Dialect, the qualified name like table.column is supported
+func QuoteColumn(name string) string {
+	flavor := Dialect()
+	if table, col, ok := strings.Cut(name, "."); ok {
+		return flavor.Quote(table) + "." + flavor.Quote(col)
+	}
+	return flavor.Quote(name)
+}
+
+// modelColumns return the column names defined in the struct tags of data, nil if data is not a struct or slice of struct
+func modelColumns(data any) map[string]struct{} {
+	if data == nil {
+		return nil
+	}
+	t := dereferencedElemType(reflect.TypeOf(data))
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	cols := make(map[string]struct{}, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if name, _ := colNameFromTag(t.Field(i)); name != "" {
+			cols[name] = struct{}{}
+		}
+	}
+	return cols
+}
+
+// checkColumn validate the column name and return it quoted.
+//
+// The name must be a plain identifier, optionally qualified by the table, and it must be one of the known columns if known is not nil.
+func checkColumn(table string, known map[string]struct{}, name string) (string, error) {
+	if !identPattern.MatchString(name) {
+		return "", fmt.Errorf("%w: %q", ErrUnknownColumn, name)
+	}
+	if known != nil {
+		col := name
+		if t, c, ok := strings.Cut(name, "."); ok {
+			if t != table {
+				return "", fmt.Errorf("%w: %q", ErrUnknownColumn, name)
+			}
+			col = c
+		}
+		if _, ok := known[col]; !ok {
+			return "", fmt.Errorf("%w: %q", ErrUnknownColumn, name)
+		}
+	}
+	return QuoteColumn(name), nil
+}
+
+// selectColumns validate and quote the fields for select, the exprs allowed by AllowRaw are kept as it is
+func selectColumns(ctx context.Context, table string, known map[string]struct{}, fields []string) ([]string, error) {
+	cols := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if isRawAllowed(ctx, field) {
+			cols = append(cols, field)
+			continue
+		}
+		col, err := checkColumn(table, known, field)
+		if err != nil {
+			return nil, err
+		}
+		cols = append(cols, col)
+	}
+	return cols, nil
+}
+
+// orderByColumns translate sort into order by exprs, the column having '-' prefix is sorted descending, otherwise ascending
+func orderByColumns(ctx context.Context, table string, known map[string]struct{}, sort []string) ([]string, error) {
+	cols := make([]string, 0, len(sort))
+	for _, item := range sort {
+		if item == "" {
+			continue
+		}
+		if isRawAllowed(ctx, item) {
+			cols = append(cols, item)
+			continue
+		}
+		order := " ASC"
+		if item[0] == '-' {
+			order = " DESC"
+			item = item[1:]
+		}
+		col, err := checkColumn(table, known, item)
+		if err != nil {
+			return nil, err
+		}
+		cols = append(cols, col+order)
+	}
+	return cols, nil
+}
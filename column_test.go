@@ -0,0 +1,61 @@
+package ormx
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cloudfly/ormx/test"
+)
+
+func TestOrderByColumns(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		known = modelColumns([]TestRow{})
+	)
+
+	cols, err := orderByColumns(ctx, "test", known, []string{"-created_time", "", "id", "test.action"})
+	test.NoError(t, err)
+	test.Equal(t, []string{"`created_time` DESC", "`id` ASC", "`test`.`action` ASC"}, cols)
+
+	for _, sort := range []string{"id; DROP TABLE test", "unknown", "other.id", "-", "id DESC", "(SELECT 1)"} {
+		_, err = orderByColumns(ctx, "test", known, []string{sort})
+		test.Equal(t, true, errors.Is(err, ErrUnknownColumn))
+	}
+
+	ctx = AllowRaw(ctx, "FIELD(action, 'a', 'b')")
+	cols, err = orderByColumns(ctx, "test", known, []string{"FIELD(action, 'a', 'b')", "-id"})
+	test.NoError(t, err)
+	test.Equal(t, []string{"FIELD(action, 'a', 'b')", "`id` DESC"}, cols)
+
+	// the expressions allowed are scoped to ctx
+	_, err = orderByColumns(context.Background(), "test", known, []string{"FIELD(action, 'a', 'b')"})
+	test.Equal(t, true, errors.Is(err, ErrUnknownColumn))
+}
+
+func TestSelectColumns(t *testing.T) {
+	ctx := context.Background()
+
+	cols, err := selectColumns(ctx, "test", modelColumns(&TestRow{}), []string{"id", "message"})
+	test.NoError(t, err)
+	test.Equal(t, []string{"`id`", "`message`"}, cols)
+
+	_, err = selectColumns(ctx, "test", modelColumns(&TestRow{}), []string{"id", "password"})
+	test.Equal(t, true, errors.Is(err, ErrUnknownColumn))
+
+	// without model, any plain identifier is accepted
+	cols, err = selectColumns(ctx, "test", modelColumns(&[]M{}), []string{"password"})
+	test.NoError(t, err)
+	test.Equal(t, []string{"`password`"}, cols)
+
+	_, err = selectColumns(ctx, "test", nil, []string{"COUNT(1)"})
+	test.Equal(t, true, errors.Is(err, ErrUnknownColumn))
+
+	cols, err = selectColumns(AllowRaw(ctx, "COUNT(1)"), "test", nil, []string{"COUNT(1)"})
+	test.NoError(t, err)
+	test.Equal(t, []string{"COUNT(1)"}, cols)
+
+	cols, err = selectColumns(AllowRaw(ctx, "MAX(id) AS max_id"), "test", nil, []string{"MAX(id) AS max_id", "id"})
+	test.NoError(t, err)
+	test.Equal(t, []string{"MAX(id) AS max_id", "`id`"}, cols)
+}
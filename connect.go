@@ -2,10 +2,15 @@ package ormx
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/cloudfly/flagx"
+	"github.com/cloudfly/ormx/cache"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 	"github.com/rs/zerolog"
@@ -13,66 +18,318 @@ import (
 
 var (
 	databaseDsn     = flagx.NewString("database.dsn", "", "the dsn address of the master database which ormx connect to write")
-	databaseDsnRead = flagx.NewString("database.dsn.read", "", "the dsn address of the slave database which ormx connect to read")
+	databaseDsnRead = flagx.NewArrayString("database.dsn.read", "the dsn addresses of the slave databases which ormx connect to read, the reads are balanced across them")
 	dbDriver        = flagx.NewString("database.driver", "mysql", "the sql driver for executing query")
 	lifetime        = flagx.NewDuration("database.conn.lifetime", "10m", "the maximum amount of seconds a connection may be reused")
 	idletime        = flagx.NewDuration("database.conn.idletime", "1m", "the maximum amount of seconds a connection may be idle")
 	maxOpen         = flagx.NewInt("database.conn.maxopen", 100, "the maximum number of connections to the database server")
 	maxIdle         = flagx.NewInt("database.conn.maxidle", 32, "the maximum number of connections in idle connection poll")
+	drainTimeout    = flagx.NewDuration("database.conn.drain.timeout", "30s", "the maximum time waiting for the connections in use before closing the pool replaced by Reconfigure or AddDataSource")
 )
 
 var (
-	db  *sqlx.DB
-	rdb *sqlx.DB
+	connLock sync.RWMutex // guards db, dbName and replicas
+	db       *sqlx.DB
+	dbName   string
+	replicas *replicaSet
+
+	drainLock sync.Mutex
+	draining  = map[*dataSource]struct{}{}
+	poolUsers = map[*sqlx.DB]int{} // master pool => the queries and transactions referencing the data source
+
+	inflightLock sync.Mutex
+	inflight     int
+	shuttingDown bool
+	inflightIdle chan struct{}
 )
 
-// Connect to the database server by using the addr and password specified in flags
+// Connect to the database server by using the addr and password specified in flags, the named data sources in flags are connected too.
+// The connections previously connected are drained and closed.
 func Connect(ctx context.Context) error {
-	if *databaseDsn == "" {
-		return nil
+	inflightLock.Lock()
+	shuttingDown = false
+	inflightLock.Unlock()
+
+	if *databaseDsn != "" {
+		if err := Reconfigure(ctx, *databaseDsn, *databaseDsnRead...); err != nil {
+			return err
+		}
 	}
 
-	var (
-		err error
-	)
+	return connectSources(ctx)
+}
 
-	zerolog.Ctx(ctx).Info().Str("dsn", *databaseDsn).Msg("Connecting to master database server")
-	db, err = sqlx.ConnectContext(ctx, *dbDriver, *databaseDsn)
+// Reconfigure connect to the new dsn and replicas, then swap them in as the default data source atomically, such as rotating the credentials.
+// The replicas are replaced by readDSNs, the master serves the reads if it's empty.
+// The previous connections are closed after the queries on them finished, or database.conn.drain.timeout passed.
+func Reconfigure(ctx context.Context, dsn string, readDSNs ...string) error {
+	zerolog.Ctx(ctx).Info().Str("dsn", dsnName(dsn)).Msg("Connecting to master database server")
+	master, err := connectDB(ctx, *dbDriver, dsn)
 	if err != nil {
-		return fmt.Errorf("connect error: %w", err)
+		return err
+	}
+	var rs *replicaSet
+	if len(readDSNs) > 0 {
+		if rs, err = connectReplicas(ctx, *dbDriver, master, readDSNs); err != nil {
+			master.Close()
+			return err
+		}
 	}
-	db.SetConnMaxLifetime(time.Duration(lifetime.Msecs) * time.Millisecond)
-	db.SetConnMaxIdleTime(time.Duration(idletime.Msecs) * time.Millisecond)
-	db.SetMaxIdleConns(*maxIdle)
-	db.SetMaxOpenConns(*maxOpen)
 
-	if *databaseDsnRead == "" {
-		zerolog.Ctx(ctx).Info().Str("dsn", *databaseDsnRead).Msg("Connecting to slave database server")
-		rdb, err = sqlx.ConnectContext(ctx, *dbDriver, *databaseDsnRead)
-		if err != nil {
-			return fmt.Errorf("connect error: %w", err)
+	connLock.Lock()
+	prev := &dataSource{master: db, replicas: replicas}
+	db, dbName, replicas = master, dsnName(dsn), rs
+	connLock.Unlock()
+	if prev.master != nil {
+		drainSource(prev)
+	}
+	return nil
+}
+
+// drainSource close the data source in background after the queries referencing it and the connections in use released,
+// or database.conn.drain.timeout passed
+func drainSource(s *dataSource) {
+	drainLock.Lock()
+	draining[s] = struct{}{}
+	drainLock.Unlock()
+	go func() {
+		var (
+			deadline = time.Now().Add(time.Duration(drainTimeout.Msecs) * time.Millisecond)
+			ticker   = time.NewTicker(10 * time.Millisecond)
+		)
+		defer ticker.Stop()
+		for (s.users() > 0 || s.inUse() > 0) && time.Now().Before(deadline) {
+			<-ticker.C
+		}
+		if err := s.close(); err != nil {
+			log.Warn().Err(err).Str("source", s.name).Msg("Failed to close the drained database connections")
 		}
-		rdb.SetConnMaxLifetime(time.Duration(lifetime.Msecs) * time.Millisecond)
-		rdb.SetConnMaxIdleTime(time.Duration(idletime.Msecs) * time.Millisecond)
-		rdb.SetMaxIdleConns(*maxIdle)
-		rdb.SetMaxOpenConns(*maxOpen)
+		drainLock.Lock()
+		delete(draining, s)
+		drainLock.Unlock()
+	}()
+}
+
+// acquire reference the data source connected by ormx, it's not closed by drainSource until release called
+func (s *dataSource) acquire() {
+	if s.master == nil {
+		return
 	}
+	drainLock.Lock()
+	poolUsers[s.master]++
+	drainLock.Unlock()
+}
 
+// release the reference of data source taken by acquire
+func (s *dataSource) release() {
+	if s.master == nil {
+		return
+	}
+	drainLock.Lock()
+	if poolUsers[s.master]--; poolUsers[s.master] <= 0 {
+		delete(poolUsers, s.master)
+	}
+	drainLock.Unlock()
+}
+
+// users return the number of queries and transactions referencing the data source
+func (s *dataSource) users() int {
+	if s.master == nil {
+		return 0
+	}
+	drainLock.Lock()
+	defer drainLock.Unlock()
+	return poolUsers[s.master]
+}
+
+// closeDraining close the data sources being drained immediately
+func closeDraining() error {
+	drainLock.Lock()
+	closing := draining
+	draining = map[*dataSource]struct{}{}
+	drainLock.Unlock()
+
+	var err error
+	for s := range closing {
+		if cerr := s.close(); cerr != nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// acquireQuery track the query or transaction in flight, ErrClosed is returned if Shutdown called
+func acquireQuery() error {
+	inflightLock.Lock()
+	defer inflightLock.Unlock()
+	if shuttingDown {
+		return ErrClosed
+	}
+	inflight++
 	return nil
 }
 
-// DefaultProvider return the sqlx.DB created by Connect()
-func DefaultProvider(isMaster bool) *sqlx.DB {
-	if !isMaster && rdb != nil {
-		return rdb
+// releaseQuery finish the query or transaction tracked by acquireQuery
+func releaseQuery() {
+	inflightLock.Lock()
+	defer inflightLock.Unlock()
+	inflight--
+	if inflight == 0 && inflightIdle != nil {
+		close(inflightIdle)
+		inflightIdle = nil
+	}
+}
+
+// Shutdown stop accepting new queries and transactions, wait for the ones in flight until ctx done, then Close.
+// The queries started after Shutdown return ErrClosed until Connect again, the error of ctx is returned if the wait is interrupted.
+func Shutdown(ctx context.Context) error {
+	inflightLock.Lock()
+	shuttingDown = true
+	idle := make(chan struct{})
+	if inflight == 0 {
+		close(idle)
+	} else {
+		inflightIdle = idle
+	}
+	inflightLock.Unlock()
+
+	var err error
+	select {
+	case <-idle:
+	case <-ctx.Done():
+		err = ctx.Err()
 	}
-	return db
+	if cerr := Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// connectDB connect to the dsn and configure the connection pool by flags
+func connectDB(ctx context.Context, driver, dsn string) (*sqlx.DB, error) {
+	conn, err := openDB(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.PingContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("connect error: %w", err)
+	}
+	return conn, nil
+}
+
+// openDB create the connection pool of dsn configured by flags without connecting to it
+func openDB(driver, dsn string) (*sqlx.DB, error) {
+	conn, err := sqlx.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("connect error: %w", err)
+	}
+	conn.SetConnMaxLifetime(time.Duration(lifetime.Msecs) * time.Millisecond)
+	conn.SetConnMaxIdleTime(time.Duration(idletime.Msecs) * time.Millisecond)
+	conn.SetMaxIdleConns(*maxIdle)
+	conn.SetMaxOpenConns(*maxOpen)
+	return conn, nil
 }
 
-// Close the connections to the database server in driver
+// connectReplicas open the replicas of master and start the health check.
+// The replicas are connected lazily, so the unreachable ones don't fail Connect, they serve no read until the health check succeeds.
+func connectReplicas(ctx context.Context, driver string, master *sqlx.DB, dsns []string) (*replicaSet, error) {
+	var (
+		names = make([]string, 0, len(dsns))
+		dbs   = make([]*sqlx.DB, 0, len(dsns))
+	)
+	for _, dsn := range dsns {
+		zerolog.Ctx(ctx).Info().Str("dsn", dsnName(dsn)).Msg("Connecting to slave database server")
+		rdb, err := openDB(driver, dsn)
+		if err != nil {
+			for _, rdb := range dbs {
+				rdb.Close()
+			}
+			return nil, err
+		}
+		names = append(names, dsnName(dsn))
+		dbs = append(dbs, rdb)
+	}
+	rs := newReplicaSet(*replicaBalance, names, dbs, *replicaWeights)
+	rs.master, rs.lagMethod = master, *replicaLagMethod
+	rs.startCheck(time.Duration(replicaCheckInterval.Msecs)*time.Millisecond, time.Duration(replicaCheckTimeout.Msecs)*time.Millisecond, *replicaCheckThreshold)
+	return rs, nil
+}
+
+// pickDB return the master, or the replica satisfying the default staleness; the master is returned if no replica available
+func pickDB(isMaster bool, master *sqlx.DB, rs *replicaSet) *sqlx.DB {
+	if !isMaster && rs != nil {
+		staleness, _ := maxStaleness(context.Background())
+		if rdb := rs.pick(staleness); rdb != nil {
+			return rdb
+		}
+	}
+	return master
+}
+
+// dsnName return the dsn without the user, password and parameters, it's safe to be logged
+func dsnName(dsn string) string {
+	if i := strings.LastIndex(dsn, "@"); i >= 0 {
+		dsn = dsn[i+1:]
+	}
+	name, _, _ := strings.Cut(dsn, "?")
+	return name
+}
+
+// DefaultProvider return the sqlx.DB created by Connect(), the slave is balanced across the healthy replicas and fallback to master if none is healthy
+func DefaultProvider(isMaster bool) *sqlx.DB {
+	connLock.RLock()
+	master, rs := db, replicas
+	connLock.RUnlock()
+	return pickDB(isMaster, master, rs)
+}
+
+// Close the connections to the database server in driver immediately, and stop the background reporters and cleanup of cache.
+// Use Shutdown to wait for the queries in flight.
 func Close() error {
-	if db != nil {
-		return db.Close()
+	stopReporters()
+	cache.Close()
+	err := closeSources()
+	if cerr := closeDraining(); cerr != nil {
+		err = cerr
 	}
-	return nil
+
+	connLock.Lock()
+	prev := &dataSource{master: db, replicas: replicas}
+	db, dbName, replicas = nil, "", nil
+	connLock.Unlock()
+	if cerr := prev.close(); cerr != nil {
+		err = cerr
+	}
+	return err
+}
+
+// PoolStat is the connection pool statistics of a database
+type PoolStat struct {
+	// Source is the name of data source, empty for the default one
+	Source string `json:"source"`
+	// Name is the dsn without credentials, it's empty for the data source registered by provider
+	Name   string      `json:"name"`
+	Master bool        `json:"master"`
+	Pool   sql.DBStats `json:"pool"`
+}
+
+// PoolStats return the connection pool statistics of the master and slaves of each data source, the default data source is the first
+func PoolStats() []PoolStat {
+	stats := defaultSource().poolStats()
+	sourceLock.RLock()
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := make([]*dataSource, 0, len(names))
+	for _, name := range names {
+		list = append(list, sources[name])
+	}
+	sourceLock.RUnlock()
+	for _, s := range list {
+		stats = append(stats, s.poolStats()...)
+	}
+	return stats
 }
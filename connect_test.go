@@ -0,0 +1,171 @@
+package ormx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudfly/ormx/test"
+	"github.com/jmoiron/sqlx"
+)
+
+// useConnect connect to a sqlite file as the default data source by flags, it's closed after the test
+func useConnect(t *testing.T) string {
+	var (
+		dir                           = t.TempDir()
+		dsn                           = fmt.Sprintf("file:%s", filepath.Join(dir, "master.db"))
+		prevDsn, prevRead, prevDriver = *databaseDsn, *databaseDsnRead, *dbDriver
+		prevProvider, prevDialect     = p, *dialect
+	)
+	*databaseDsn, *databaseDsnRead, *dbDriver, *dialect, p = dsn, nil, "sqlite3", "sqlite3", DefaultProvider
+	t.Cleanup(func() {
+		Close()
+		inflightLock.Lock()
+		shuttingDown = false
+		inflightLock.Unlock()
+		*databaseDsn, *databaseDsnRead, *dbDriver = prevDsn, prevRead, prevDriver
+		p, *dialect = prevProvider, prevDialect
+	})
+	test.NoError(t, Connect(context.Background()))
+	_, err := Master().Exec(test.SQLiteSchema)
+	test.NoError(t, err)
+	return dir
+}
+
+func TestReconfigure(t *testing.T) {
+	var (
+		dir = useConnect(t)
+		ctx = context.Background()
+		old = Master()
+	)
+	tx, err := old.Beginx()
+	test.NoError(t, err)
+
+	test.NoError(t, Reconfigure(ctx, "file:"+filepath.Join(dir, "rotated.db")))
+	test.Equal(t, true, Master() != old)
+	test.Equal(t, "file:"+filepath.Join(dir, "rotated.db"), PoolStats()[0].Name)
+	_, err = Master().Exec(test.SQLiteSchema)
+	test.NoError(t, err)
+
+	// the old pool is drained after the transaction finished
+	_, err = tx.Exec(insertTestRow)
+	test.NoError(t, err)
+	test.NoError(t, tx.Commit())
+	for i := 0; i < 100 && old.Ping() == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	test.Equal(t, true, old.Ping() != nil)
+	test.Equal(t, 0, countRows(t, ctx))
+}
+
+func TestReconfigureReferenced(t *testing.T) {
+	var (
+		dir = useConnect(t)
+		ctx = context.Background()
+		old = Master()
+	)
+	// the data source resolved by a query has no connection in use before the query executed
+	s, err := sourceFor(ctx, nil)
+	test.NoError(t, err)
+	test.NoError(t, Reconfigure(ctx, "file:"+filepath.Join(dir, "rotated.db")))
+	time.Sleep(50 * time.Millisecond)
+	test.NoError(t, old.Ping())
+
+	s.release()
+	for i := 0; i < 100 && old.Ping() == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	test.Equal(t, true, old.Ping() != nil)
+}
+
+func TestShutdown(t *testing.T) {
+	useConnect(t)
+	var (
+		ctx      = context.Background()
+		started  = make(chan struct{})
+		release  = make(chan struct{})
+		txErr    = make(chan error, 1)
+		shutdown = make(chan error, 1)
+	)
+	go func() {
+		txErr <- RunTxContext(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+			close(started)
+			<-release
+			_, err := ExecTx(ctx, tx, insertTestRow)
+			return err
+		})
+	}()
+	<-started
+	go func() { shutdown <- Shutdown(ctx) }()
+
+	// the new queries are rejected once shutting down
+	var err error
+	for i := 0; i < 100; i++ {
+		if _, err = Exec(ctx, insertTestRow); errors.Is(err, ErrClosed) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	test.Equal(t, true, errors.Is(err, ErrClosed))
+	select {
+	case <-shutdown:
+		t.Fatal("shutdown returned before the transaction finished")
+	default:
+	}
+
+	close(release)
+	test.NoError(t, <-txErr)
+	test.NoError(t, <-shutdown)
+	test.Equal(t, 0, len(PoolStats()))
+
+	// the interrupted shutdown still closes the connections
+	test.NoError(t, Connect(ctx))
+	test.NoError(t, acquireQuery())
+	defer releaseQuery()
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	test.Equal(t, true, errors.Is(Shutdown(timeout), context.DeadlineExceeded))
+	test.Equal(t, 0, len(PoolStats()))
+}
+
+type poolMetricHandler func(ctx context.Context, stat PoolStat)
+
+func (h poolMetricHandler) Emit(context.Context, string, bool) {}
+
+func (h poolMetricHandler) EmitPool(ctx context.Context, stat PoolStat) {
+	h(ctx, stat)
+}
+
+func TestPoolStats(t *testing.T) {
+	useConnect(t)
+	useDataSources(t)
+	var (
+		ctx   = context.Background()
+		stats []PoolStat
+	)
+	test.NoError(t, AddDataSource(ctx, DataSource{Name: "archive", DSN: "file:ormx_pool_master?mode=memory&cache=shared", ReadDSNs: []string{"file:ormx_pool_slave?mode=memory&cache=shared"}}))
+	slave := test.SQLite(t)
+	RegisterDataSource("logs", func(isMaster bool) *sqlx.DB {
+		if isMaster {
+			return sources["archive"].master
+		}
+		return slave
+	})
+
+	SetMetricHandler(poolMetricHandler(func(_ context.Context, stat PoolStat) {
+		stats = append(stats, stat)
+	}))
+	t.Cleanup(func() { SetMetricHandler(nil) })
+	emitPoolStats(ctx)
+
+	test.Equal(t, 5, len(stats))
+	test.Equal(t, "", stats[0].Source)
+	test.Equal(t, true, stats[0].Master)
+	test.Equal(t, PoolStat{Source: "archive", Name: "file:ormx_pool_master", Master: true}, PoolStat{Source: stats[1].Source, Name: stats[1].Name, Master: stats[1].Master})
+	test.Equal(t, PoolStat{Source: "archive", Name: "file:ormx_pool_slave"}, PoolStat{Source: stats[2].Source, Name: stats[2].Name, Master: stats[2].Master})
+	test.Equal(t, PoolStat{Source: "logs", Master: true}, PoolStat{Source: stats[3].Source, Name: stats[3].Name, Master: stats[3].Master})
+	test.Equal(t, PoolStat{Source: "logs"}, PoolStat{Source: stats[4].Source, Name: stats[4].Name, Master: stats[4].Master})
+}
@@ -0,0 +1,339 @@
+package ormx
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/cloudfly/flagx"
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog"
+)
+
+var (
+	sourceDsn     = flagx.NewArrayString("database.source.dsn", "the named data sources in format name=dsn, the queries are routed to them by database.source.route, the Database() method of model or WithDataSource")
+	sourceDsnRead = flagx.NewArrayString("database.source.dsn.read", "the replicas of the named data sources in format name=dsn, a data source can have multiple replicas")
+	sourceRoute   = flagx.NewArrayString("database.source.route", "the routing rules in format table_prefix=name, the table having the longest matched prefix is routed to the data source")
+
+	sourceLock   sync.RWMutex
+	sources      = map[string]*dataSource{}
+	tableRoutes  = map[string]string{} // table => data source, registered by models
+	prefixRoutes = map[string]string{} // table prefix => data source
+	modelTypes   sync.Map              // reflect.Type => struct{}, the models whose route registered
+	modelTables  sync.Map              // table => struct{}, the tables whose model registered
+)
+
+// DataSource is the config of a named data source
+type DataSource struct {
+	Name string
+	// Driver is the sql driver, use database.driver if empty
+	Driver   string
+	DSN      string
+	ReadDSNs []string
+}
+
+// dataSource is the master and slaves of a data source
+type dataSource struct {
+	name     string
+	provider DBProvider
+	// master and replicas are set if it's connected by ormx, masterName is the dsn of master without credentials
+	master     *sqlx.DB
+	masterName string
+	replicas   *replicaSet
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func (s *dataSource) db(isMaster bool) *sqlx.DB {
+	if s.provider == nil {
+		panic("db getter is nil, call ormx.Init to initilaze the DBGetter")
+	}
+	return s.provider(isMaster)
+}
+
+// close the connections of data source, it's safe to be called multiple times
+func (s *dataSource) close() error {
+	s.closeOnce.Do(func() {
+		if s.replicas != nil {
+			s.closeErr = s.replicas.close()
+		}
+		if s.master != nil {
+			if err := s.master.Close(); err != nil {
+				s.closeErr = err
+			}
+		}
+	})
+	return s.closeErr
+}
+
+// inUse return the number of connections in use of the master and replicas connected by ormx
+func (s *dataSource) inUse() int {
+	var n int
+	if s.master != nil {
+		n += s.master.Stats().InUse
+	}
+	if s.replicas != nil {
+		for _, r := range s.replicas.replicas {
+			n += r.db.Stats().InUse
+		}
+	}
+	return n
+}
+
+// poolStats return the pool statistics of master and replicas, they are got from provider if the data source is not connected by ormx
+func (s *dataSource) poolStats() []PoolStat {
+	if s.master == nil {
+		if s.provider == nil {
+			return nil
+		}
+		var stats []PoolStat
+		master := s.provider(true)
+		if master != nil {
+			stats = append(stats, PoolStat{Source: s.name, Master: true, Pool: master.Stats()})
+		}
+		if slave := s.provider(false); slave != nil && slave != master {
+			stats = append(stats, PoolStat{Source: s.name, Pool: slave.Stats()})
+		}
+		return stats
+	}
+	stats := []PoolStat{{Source: s.name, Name: s.masterName, Master: true, Pool: s.master.Stats()}}
+	if s.replicas != nil {
+		for _, r := range s.replicas.stats() {
+			stats = append(stats, PoolStat{Source: s.name, Name: r.Name, Pool: r.Pool})
+		}
+	}
+	return stats
+}
+
+// defaultSource return the data source initialized by Init and Connect
+func defaultSource() *dataSource {
+	connLock.RLock()
+	defer connLock.RUnlock()
+	return &dataSource{provider: p, master: db, masterName: dbName, replicas: replicas}
+}
+
+// RegisterDataSource register the named data source provided by provider, it replaces the data source having the same name
+func RegisterDataSource(name string, provider DBProvider) {
+	registerSource(&dataSource{name: name, provider: provider})
+}
+
+// AddDataSource connect to the named data source and register it, the connections are closed by Close.
+// It replaces the data source having the same name, such as rotating the credentials, the previous connections are drained like Reconfigure.
+func AddDataSource(ctx context.Context, ds DataSource) error {
+	driver := ds.Driver
+	if driver == "" {
+		driver = *dbDriver
+	}
+	zerolog.Ctx(ctx).Info().Str("source", ds.Name).Str("dsn", dsnName(ds.DSN)).Msg("Connecting to master database server")
+	master, err := connectDB(ctx, driver, ds.DSN)
+	if err != nil {
+		return err
+	}
+	s := &dataSource{name: ds.Name, master: master, masterName: dsnName(ds.DSN)}
+	if len(ds.ReadDSNs) > 0 {
+		if s.replicas, err = connectReplicas(ctx, driver, master, ds.ReadDSNs); err != nil {
+			master.Close()
+			return err
+		}
+	}
+	s.provider = func(isMaster bool) *sqlx.DB {
+		return pickDB(isMaster, s.master, s.replicas)
+	}
+	registerSource(s)
+	return nil
+}
+
+func registerSource(s *dataSource) {
+	sourceLock.Lock()
+	prev := sources[s.name]
+	sources[s.name] = s
+	sourceLock.Unlock()
+	if prev != nil {
+		drainSource(prev)
+	}
+}
+
+// connectSources connect to the data sources configured by flags
+func connectSources(ctx context.Context) error {
+	reads := map[string][]string{}
+	for _, v := range *sourceDsnRead {
+		name, dsn, ok := strings.Cut(v, "=")
+		if !ok {
+			return fmt.Errorf("invalid database.source.dsn.read %q, it should be name=dsn", dsnName(v))
+		}
+		reads[name] = append(reads[name], dsn)
+	}
+	for _, v := range *sourceDsn {
+		name, dsn, ok := strings.Cut(v, "=")
+		if !ok {
+			return fmt.Errorf("invalid database.source.dsn %q, it should be name=dsn", dsnName(v))
+		}
+		if err := AddDataSource(ctx, DataSource{Name: name, DSN: dsn, ReadDSNs: reads[name]}); err != nil {
+			return err
+		}
+	}
+	for _, v := range *sourceRoute {
+		prefix, name, ok := strings.Cut(v, "=")
+		if !ok {
+			return fmt.Errorf("invalid database.source.route %q, it should be table_prefix=name", v)
+		}
+		RouteTable(prefix, name)
+	}
+	return nil
+}
+
+// closeSources close the data sources connected by ormx
+func closeSources() error {
+	sourceLock.Lock()
+	closing := sources
+	sources = map[string]*dataSource{}
+	sourceLock.Unlock()
+
+	var err error
+	for _, s := range closing {
+		if cerr := s.close(); cerr != nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// RouteTable route the queries on the tables having the prefix to the named data source, the longest matched prefix wins
+func RouteTable(prefix, name string) {
+	sourceLock.Lock()
+	defer sourceLock.Unlock()
+	prefixRoutes[prefix] = name
+}
+
+type dataSourceCtxKey struct{}
+
+// WithDataSource route the queries with ctx to the named data source, it overrides the routing rules
+func WithDataSource(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, dataSourceCtxKey{}, name)
+}
+
+// DataSourceFrom return the name of data source set by WithDataSource, ok is false if not set
+func DataSourceFrom(ctx context.Context) (name string, ok bool) {
+	name, ok = ctx.Value(dataSourceCtxKey{}).(string)
+	return name, ok
+}
+
+// RegisterModels register the models to ormx in advance, the table of the model having Database() string method is routed to that data source,
+// the table of the model having Sharding() Sharding method is sharded, and the fields having sensitive option are redacted in log. The models used by the Insert, Patch and Select functions are registered automatically.
+// The functions taking the table name only, such as Count and DeleteByID, return ErrUnknownRoute for the table of a model never registered
+// once the named data sources or shardings are used.
+func RegisterModels(models ...any) {
+	for _, model := range models {
+		registerModel("", model)
+	}
+}
+
+// registerModel register the route and sensitive fields of data, the table is resolved from data if empty
+func registerModel(table string, data any) {
+	registerSensitive(table, data)
+	if data == nil {
+		return
+	}
+	t := dereferencedElemType(reflect.TypeOf(data))
+	if t.Kind() != reflect.Struct {
+		return
+	}
+	if table != "" {
+		if _, ok := modelTables.Load(table); !ok {
+			modelTables.Store(table, struct{}{})
+		}
+	}
+	if _, ok := modelTypes.Load(t); ok {
+		return
+	}
+	modelTypes.Store(t, struct{}{})
+	modelTables.Store(TableName(data), struct{}{})
+	model := reflect.New(t).Interface()
+	if sharded, ok := model.(interface{ Sharding() Sharding }); ok {
+		// the table may be a physical table, the sharding belongs to the logical table of model
+		RegisterSharding(TableName(data), sharded.Sharding())
+	}
+	routed, ok := model.(interface{ Database() string })
+	if !ok {
+		return
+	}
+	if table == "" {
+		table = TableName(data)
+	}
+	sourceLock.Lock()
+	defer sourceLock.Unlock()
+	tableRoutes[table] = routed.Database()
+}
+
+// checkRoute return ErrUnknownRoute if the query on table may be routed wrongly because its model is never seen,
+// that's the named data sources or shardings are registered, but table has neither a route nor a registered model.
+func checkRoute(ctx context.Context, table string) error {
+	if _, ok := ctx.Value(dataSourceCtxKey{}).(string); ok {
+		return nil
+	}
+	if _, ok := modelTables.Load(table); ok {
+		return nil
+	}
+	sourceLock.RLock()
+	defer sourceLock.RUnlock()
+	if len(sources) == 0 && len(shardings) == 0 {
+		return nil
+	}
+	if _, ok := tableRoutes[table]; ok || shardings[table] != nil {
+		return nil
+	}
+	for prefix := range prefixRoutes {
+		if strings.HasPrefix(table, prefix) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: table '%s', register its model by RegisterModels, or route it by RouteTable or WithDataSource", ErrUnknownRoute, table)
+}
+
+// sourceName return the name of data source the query on tables routed to, empty for the default data source
+func sourceName(ctx context.Context, tables []string) string {
+	if name, ok := ctx.Value(dataSourceCtxKey{}).(string); ok {
+		return name
+	}
+	sourceLock.RLock()
+	defer sourceLock.RUnlock()
+	for _, table := range tables {
+		if name, ok := tableRoutes[table]; ok {
+			return name
+		}
+		var longest string
+		for prefix := range prefixRoutes {
+			if strings.HasPrefix(table, prefix) && len(prefix) > len(longest) {
+				longest = prefix
+			}
+		}
+		if longest != "" {
+			return prefixRoutes[longest]
+		}
+	}
+	return ""
+}
+
+// sourceFor return the data source the query on tables routed to, ErrUnknownDataSource if it's not registered.
+// The data source is referenced before it can be replaced by Reconfigure or AddDataSource, so release it after the query finished.
+func sourceFor(ctx context.Context, tables []string) (*dataSource, error) {
+	name := sourceName(ctx, tables)
+	if name == "" {
+		connLock.RLock()
+		defer connLock.RUnlock()
+		s := &dataSource{provider: p, master: db, masterName: dbName, replicas: replicas}
+		s.acquire()
+		return s, nil
+	}
+	sourceLock.RLock()
+	defer sourceLock.RUnlock()
+	s, ok := sources[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q, register it by AddDataSource or RegisterDataSource", ErrUnknownDataSource, name)
+	}
+	s.acquire()
+	return s, nil
+}
@@ -0,0 +1,190 @@
+package ormx
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudfly/ormx/test"
+	"github.com/jmoiron/sqlx"
+)
+
+type archiveRow struct {
+	ID       int64  `db:"id"`
+	Producer string `db:"producer,insert"`
+	Resource string `db:"resource,insert"`
+	Action   string `db:"action,insert"`
+	Message  string `db:"message,insert"`
+}
+
+func (archiveRow) Table() string    { return "archive_test" }
+func (archiveRow) Database() string { return "archive" }
+
+// useDataSources reset the data sources, routes and shardings, they are restored after the test
+func useDataSources(t *testing.T) {
+	sourceLock.Lock()
+	prevSources, prevTables, prevPrefixes, prevShardings := sources, tableRoutes, prefixRoutes, shardings
+	sources, tableRoutes, prefixRoutes, shardings = map[string]*dataSource{}, map[string]string{}, map[string]string{}, map[string]*Sharding{}
+	sourceLock.Unlock()
+	t.Cleanup(func() {
+		closeSources()
+		sourceLock.Lock()
+		sources, tableRoutes, prefixRoutes, shardings = prevSources, prevTables, prevPrefixes, prevShardings
+		sourceLock.Unlock()
+		modelTypes.Delete(reflect.TypeOf(archiveRow{}))
+		modelTypes.Delete(reflect.TypeOf(orderRow{}))
+		modelTables.Delete(archiveRow{}.Table())
+		modelTables.Delete(orderRow{}.Table())
+	})
+}
+
+// sourceDB open a sqlite database having the test table and the tables renamed from test
+func sourceDB(t *testing.T, tables ...string) *sqlx.DB {
+	db := test.SQLite(t)
+	for _, table := range tables {
+		_, err := db.Exec(strings.Replace(test.SQLiteSchema, "TABLE test", "TABLE "+table, 1))
+		test.NoError(t, err)
+	}
+	return db
+}
+
+func countTable(t *testing.T, db *sqlx.DB, table string) int {
+	var n int
+	test.NoError(t, db.Get(&n, "SELECT COUNT(*) FROM "+table))
+	return n
+}
+
+func TestRouteTable(t *testing.T) {
+	useDataSources(t)
+	var (
+		ctx    = context.Background()
+		def    = useSQLite(t)
+		logs   = sourceDB(t, "log_access", "log_audit")
+		audit  = sourceDB(t, "log_audit")
+		events = recordEvents(t)
+	)
+	RegisterDataSource("logs", func(bool) *sqlx.DB { return logs })
+	RegisterDataSource("audit", func(bool) *sqlx.DB { return audit })
+	RouteTable("log_", "logs")
+	RouteTable("log_audit", "audit")
+
+	for _, table := range []string{"log_access", "log_audit", "test"} {
+		_, err := Exec(ctx, "INSERT INTO "+table+" (producer, resource, action, message) VALUES ('unittest', 'source', 'test', '')")
+		test.NoError(t, err)
+	}
+	test.Equal(t, 1, countTable(t, logs, "log_access"))
+	test.Equal(t, 0, countTable(t, logs, "log_audit"))
+	test.Equal(t, 1, countTable(t, audit, "log_audit"))
+	test.Equal(t, 1, countTable(t, def, "test"))
+
+	test.Equal(t, 3, len(*events))
+	test.Equal(t, "logs", (*events)[0].DataSource)
+	test.Equal(t, "audit", (*events)[1].DataSource)
+	test.Equal(t, "", (*events)[2].DataSource)
+
+	// the context overrides the routes
+	var n int
+	test.NoError(t, Get(WithDataSource(ctx, "logs"), &n, "SELECT COUNT(*) FROM test"))
+	test.Equal(t, 0, n)
+	test.NoError(t, Get(WithDataSource(ctx, ""), &n, "SELECT COUNT(*) FROM test"))
+	test.Equal(t, 1, n)
+}
+
+func TestModelDataSource(t *testing.T) {
+	useDataSources(t)
+	var (
+		ctx     = context.Background()
+		def     = useSQLite(t)
+		archive = sourceDB(t, "archive_test")
+	)
+	RegisterDataSource("archive", func(bool) *sqlx.DB { return archive })
+
+	_, err := InsertOne(ctx, "", archiveRow{Producer: "unittest", Resource: "source", Action: "archive"})
+	test.NoError(t, err)
+	test.Equal(t, 1, countTable(t, archive, "archive_test"))
+	test.Equal(t, 0, countTable(t, def, "test"))
+
+	var rows []archiveRow
+	test.NoError(t, SelectWhere(ctx, &rows, "", nil, nil, nil, 0, 0))
+	test.Equal(t, 1, len(rows))
+	test.Equal(t, "archive", rows[0].Action)
+
+	// the transaction runs on the data source of ctx
+	err = RunTxContext(WithDataSource(ctx, "archive"), func(ctx context.Context, tx *sqlx.Tx) error {
+		_, err := ExecTx(ctx, tx, "DELETE FROM archive_test")
+		return err
+	})
+	test.NoError(t, err)
+	test.Equal(t, 0, countTable(t, archive, "archive_test"))
+
+	// the transaction on the default data source refuses the table routed to another one
+	err = RunTxContext(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+		_, err := ExecTx(ctx, tx, "DELETE FROM archive_test")
+		return err
+	})
+	test.Equal(t, true, errors.Is(err, ErrCrossDataSource))
+}
+
+func TestUnknownRoute(t *testing.T) {
+	useDataSources(t)
+	var (
+		ctx     = context.Background()
+		archive = sourceDB(t, "archive_test")
+	)
+	useSQLite(t)
+	RegisterDataSource("archive", func(bool) *sqlx.DB { return archive })
+	_, err := archive.Exec("INSERT INTO archive_test (producer, resource, action, message) VALUES ('unittest', 'source', 'archive', '')")
+	test.NoError(t, err)
+
+	// the model routing the table is never seen
+	filter := KVs{{Key: "action", Value: "archive"}}
+	_, err = Count(ctx, "archive_test", filter)
+	test.Equal(t, true, errors.Is(err, ErrUnknownRoute))
+	test.Equal(t, true, errors.Is(DeleteByID(ctx, "archive_test", 1), ErrUnknownRoute))
+
+	n, err := Count(WithDataSource(ctx, "archive"), "archive_test", filter)
+	test.NoError(t, err)
+	test.Equal(t, int64(1), n)
+
+	RegisterModels(archiveRow{})
+	n, err = Count(ctx, "archive_test", filter)
+	test.NoError(t, err)
+	test.Equal(t, int64(1), n)
+}
+
+func TestUnknownDataSource(t *testing.T) {
+	useDataSources(t)
+	useSQLite(t)
+	ctx := WithDataSource(context.Background(), "missing")
+	test.Equal(t, true, errors.Is(Get(ctx, new(int), "SELECT COUNT(*) FROM test"), ErrUnknownDataSource))
+	test.Equal(t, true, errors.Is(RunTxContext(ctx, func(context.Context, *sqlx.Tx) error { return nil }), ErrUnknownDataSource))
+}
+
+func TestConnectSources(t *testing.T) {
+	useDataSources(t)
+	prevDriver, prevDsn, prevRead, prevRoute := *dbDriver, *sourceDsn, *sourceDsnRead, *sourceRoute
+	t.Cleanup(func() { *dbDriver, *sourceDsn, *sourceDsnRead, *sourceRoute = prevDriver, prevDsn, prevRead, prevRoute })
+	*dbDriver = "sqlite3"
+	*sourceDsn = []string{"archive=file:ormx_source_master?mode=memory&cache=shared"}
+	*sourceDsnRead = []string{"archive=file:ormx_source_slave?mode=memory&cache=shared"}
+	*sourceRoute = []string{"archive_=archive"}
+
+	ctx := context.Background()
+	test.NoError(t, connectSources(ctx))
+	s, err := sourceFor(ctx, []string{"archive_test"})
+	test.NoError(t, err)
+	defer s.release()
+	test.Equal(t, "archive", s.name)
+	test.Equal(t, 1, len(s.replicas.replicas))
+	test.Equal(t, s.master, s.db(true))
+	// the replica serves reads after the health check succeeds
+	test.Equal(t, s.master, s.db(false))
+	s.replicas.check(ctx, time.Second, 1)
+	test.Equal(t, s.replicas.replicas[0].db, s.db(false))
+
+	*sourceRoute = []string{"invalid"}
+	test.Equal(t, true, connectSources(ctx) != nil)
+}
@@ -0,0 +1,276 @@
+package ormx
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	sb "github.com/huandu/go-sqlbuilder"
+)
+
+var (
+	timeType  = reflect.TypeOf(time.Time{})
+	nullTypes = map[reflect.Type]reflect.Type{
+		reflect.TypeOf(sql.NullString{}):  reflect.TypeOf(""),
+		reflect.TypeOf(sql.NullInt64{}):   reflect.TypeOf(int64(0)),
+		reflect.TypeOf(sql.NullInt32{}):   reflect.TypeOf(int32(0)),
+		reflect.TypeOf(sql.NullInt16{}):   reflect.TypeOf(int16(0)),
+		reflect.TypeOf(sql.NullByte{}):    reflect.TypeOf(uint8(0)),
+		reflect.TypeOf(sql.NullFloat64{}): reflect.TypeOf(float64(0)),
+		reflect.TypeOf(sql.NullBool{}):    reflect.TypeOf(false),
+		reflect.TypeOf(sql.NullTime{}):    timeType,
+	}
+)
+
+// ddlColumn is a column defined by the struct field
+type ddlColumn struct {
+	name    string
+	typ     string
+	notNull bool
+	// the default value is the raw sql expression, such as 0, 'unknown' and CURRENT_TIMESTAMP
+	defaultValue string
+	hasDefault   bool
+	primary      bool
+	autoIncr     bool
+}
+
+// ddlIndex is the index on the columns, the columns having the same index name are in the same index in the order of fields
+type ddlIndex struct {
+	name    string
+	unique  bool
+	columns []string
+}
+
+// CreateTableSQL return the CREATE TABLE statement of the model defined by the struct tags, the table is resolved by TableName.
+//
+// The column type is inferred from the Go type of field, and can be set by the options in struct tag:
+//   - type:VARCHAR(32) set the column type explicitly, escape the comma by backslash like type:DECIMAL(10\,2)
+//   - size:64 set the length of string or []byte column, the default is 255
+//   - default:0 set the default value, it's the raw sql expression
+//   - notnull set the column NOT NULL
+//   - index, index:name create an index on the column, the columns having the same index name are in a composite index
+//   - unique, unique:name create an unique index like index
+//
+// The column named by SetPrimaryKey is the primary key, it's auto incremented if the type is integer.
+// The namespace column is added if it's enabled and not defined by the model.
+// The indexes are defined inline on MySQL, and created by the CREATE INDEX statements following the CREATE TABLE on other dialects,
+// the statements are separated by semicolon.
+func CreateTableSQL(model any, dialect sb.Flavor) (string, error) {
+	t := dereferencedElemType(reflect.TypeOf(model))
+	if t == nil || t.Kind() != reflect.Struct {
+		return "", fmt.Errorf("ormx: the model of CREATE TABLE must be struct, not %T", model)
+	}
+	var (
+		table   = TableName(model)
+		columns []ddlColumn
+		indexes []*ddlIndex
+		named   = map[string]*ddlIndex{}
+	)
+	addIndex := func(name string, unique bool, column string) {
+		if name == "" {
+			prefix := "idx_"
+			if unique {
+				prefix = "uk_"
+			}
+			name = prefix + table + "_" + column
+		}
+		if idx, ok := named[name]; ok {
+			idx.columns = append(idx.columns, column)
+			return
+		}
+		idx := &ddlIndex{name: name, unique: unique, columns: []string{column}}
+		named[name] = idx
+		indexes = append(indexes, idx)
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, after := colNameFromTag(field)
+		if name == "" {
+			continue
+		}
+		opts := ParseOptionStr(after)
+		col := ddlColumn{name: name, primary: name == *primaryKey}
+		col.defaultValue, col.hasDefault = opts["default"]
+		_, col.notNull = opts["notnull"]
+
+		if typ := opts["type"]; typ != "" {
+			col.typ = typ
+		} else {
+			typ, integer, err := columnType(field.Type, opts["size"], dialect)
+			if err != nil {
+				return "", fmt.Errorf("ormx: field %s of %s: %w", field.Name, t.Name(), err)
+			}
+			col.typ, col.autoIncr = typ, col.primary && integer
+		}
+		columns = append(columns, col)
+
+		if idx, ok := opts["index"]; ok {
+			addIndex(idx, false, name)
+		}
+		if idx, ok := opts["unique"]; ok {
+			addIndex(idx, true, name)
+		}
+	}
+	if len(columns) == 0 {
+		return "", fmt.Errorf("ormx: no column defined in %s", t.Name())
+	}
+	if ns := *namespaceColumnName; ns != "" && ns != "-" && !containsColumn(columns, ns) {
+		columns = append(columns, ddlColumn{name: ns, typ: "VARCHAR(64)", notNull: true, defaultValue: "''", hasDefault: true})
+	}
+
+	ctb := dialect.NewCreateTableBuilder().CreateTable(dialect.Quote(table))
+	for _, col := range columns {
+		ctb.Define(columnDefinition(col, dialect)...)
+	}
+	if i := primaryColumn(columns); i >= 0 && !(dialect == sb.SQLite && columns[i].autoIncr) {
+		ctb.Define("PRIMARY KEY", "("+dialect.Quote(columns[i].name)+")")
+	}
+
+	statements := []string{}
+	for _, idx := range indexes {
+		cols := make([]string, 0, len(idx.columns))
+		for _, col := range idx.columns {
+			cols = append(cols, dialect.Quote(col))
+		}
+		kind := "INDEX"
+		if idx.unique {
+			kind = "UNIQUE INDEX"
+		}
+		if dialect == sb.MySQL {
+			ctb.Define(kind, dialect.Quote(idx.name), "("+strings.Join(cols, ", ")+")")
+			continue
+		}
+		statements = append(statements, fmt.Sprintf("CREATE %s %s ON %s (%s)", kind, dialect.Quote(idx.name), dialect.Quote(table), strings.Join(cols, ", ")))
+	}
+	return strings.Join(append([]string{ctb.String()}, statements...), ";\n"), nil
+}
+
+// columnDefinition return the parts of column definition in CREATE TABLE
+func columnDefinition(col ddlColumn, dialect sb.Flavor) []string {
+	def := []string{dialect.Quote(col.name), sb.Escape(col.typ)}
+	switch {
+	case col.autoIncr && dialect == sb.SQLite:
+		// the auto incremented primary key of SQLite must be defined inline as INTEGER PRIMARY KEY
+		return append(def[:1], "INTEGER PRIMARY KEY AUTOINCREMENT")
+	case col.autoIncr && dialect == sb.PostgreSQL:
+		def[1] = "BIGSERIAL"
+		if col.typ == "INTEGER" || col.typ == "SMALLINT" {
+			def[1] = "SERIAL"
+		}
+	}
+	if col.notNull || col.primary {
+		def = append(def, "NOT NULL")
+	}
+	if col.hasDefault {
+		def = append(def, "DEFAULT", sb.Escape(col.defaultValue))
+	}
+	if col.autoIncr && dialect == sb.MySQL {
+		def = append(def, "AUTO_INCREMENT")
+	}
+	return def
+}
+
+// columnType infer the column type of Go type, the integer is true if it's an integer type
+func columnType(t reflect.Type, size string, dialect sb.Flavor) (typ string, integer bool, err error) {
+	t = dereferencedType(t)
+	if underlying, ok := nullTypes[t]; ok {
+		t = underlying
+	}
+	if size == "" {
+		size = "255"
+	}
+	if t == timeType {
+		if dialect == sb.PostgreSQL {
+			return "TIMESTAMP", false, nil
+		}
+		return "DATETIME", false, nil
+	}
+	if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+		switch dialect {
+		case sb.PostgreSQL:
+			return "BYTEA", false, nil
+		case sb.SQLite:
+			return "BLOB", false, nil
+		}
+		return "VARBINARY(" + size + ")", false, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return "VARCHAR(" + size + ")", false, nil
+	case reflect.Bool:
+		return "BOOLEAN", false, nil
+	case reflect.Float32:
+		if dialect == sb.MySQL {
+			return "FLOAT", false, nil
+		}
+		return "REAL", false, nil
+	case reflect.Float64:
+		switch dialect {
+		case sb.MySQL:
+			return "DOUBLE", false, nil
+		case sb.SQLite:
+			return "REAL", false, nil
+		}
+		return "DOUBLE PRECISION", false, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return integerType(t.Kind(), dialect), true, nil
+	}
+	return "", false, fmt.Errorf("can't infer the column type of %s, set it by the type option", t)
+}
+
+// integerType return the smallest integer type holding the values of kind, the unsigned types are supported by MySQL only
+func integerType(kind reflect.Kind, dialect sb.Flavor) string {
+	if dialect == sb.SQLite {
+		return "INTEGER"
+	}
+	var (
+		typ      string
+		unsigned = kind >= reflect.Uint && kind <= reflect.Uint64
+	)
+	switch kind {
+	case reflect.Int8, reflect.Uint8:
+		typ = "SMALLINT"
+		if dialect == sb.MySQL {
+			typ = "TINYINT"
+		}
+	case reflect.Int16:
+		typ = "SMALLINT"
+	case reflect.Int32, reflect.Uint16:
+		typ = "INTEGER"
+	case reflect.Uint32:
+		if dialect == sb.MySQL {
+			typ = "INTEGER"
+		} else {
+			typ = "BIGINT"
+		}
+	default:
+		typ = "BIGINT"
+	}
+	if unsigned && dialect == sb.MySQL {
+		typ += " UNSIGNED"
+	}
+	return typ
+}
+
+func primaryColumn(columns []ddlColumn) int {
+	for i, col := range columns {
+		if col.primary {
+			return i
+		}
+	}
+	return -1
+}
+
+func containsColumn(columns []ddlColumn, name string) bool {
+	for _, col := range columns {
+		if col.name == name {
+			return true
+		}
+	}
+	return false
+}
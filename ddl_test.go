@@ -0,0 +1,71 @@
+package ormx
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudfly/ormx/test"
+	sb "github.com/huandu/go-sqlbuilder"
+)
+
+type ddlUser struct {
+	ID       int64           `db:"id"`
+	Email    string          `db:"email,insert,size:128,unique,notnull"`
+	TenantID int32           `db:"tenant_id,insert,index:idx_tenant_created,notnull"`
+	Created  time.Time       `db:"created_time,index:idx_tenant_created,notnull,default:CURRENT_TIMESTAMP"`
+	Score    sql.NullFloat64 `db:"score"`
+	Price    string          `db:"price,insert,type:DECIMAL(10\\,2),default:0"`
+	Flags    uint8           `db:"flags,notnull,default:0"`
+	Ignored  string          `db:"-"`
+}
+
+func (ddlUser) Table() string { return "users" }
+
+func TestCreateTableSQL(t *testing.T) {
+	ddl, err := CreateTableSQL(ddlUser{}, sb.MySQL)
+	test.NoError(t, err)
+	test.Equal(t, "CREATE TABLE `users` (`id` BIGINT NOT NULL AUTO_INCREMENT, `email` VARCHAR(128) NOT NULL, `tenant_id` INTEGER NOT NULL, "+
+		"`created_time` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP, `score` DOUBLE, `price` DECIMAL(10,2) DEFAULT 0, `flags` TINYINT UNSIGNED NOT NULL DEFAULT 0, "+
+		"`namespace` VARCHAR(64) NOT NULL DEFAULT '', PRIMARY KEY (`id`), UNIQUE INDEX `uk_users_email` (`email`), "+
+		"INDEX `idx_tenant_created` (`tenant_id`, `created_time`))", ddl)
+
+	ddl, err = CreateTableSQL(&[]ddlUser{}, sb.PostgreSQL)
+	test.NoError(t, err)
+	statements := strings.Split(ddl, ";\n")
+	test.Equal(t, 3, len(statements))
+	test.Equal(t, true, strings.HasPrefix(statements[0], `CREATE TABLE "users" ("id" BIGSERIAL NOT NULL, `))
+	test.Equal(t, true, strings.Contains(statements[0], `"created_time" TIMESTAMP NOT NULL`))
+	test.Equal(t, `CREATE UNIQUE INDEX "uk_users_email" ON "users" ("email")`, statements[1])
+	test.Equal(t, `CREATE INDEX "idx_tenant_created" ON "users" ("tenant_id", "created_time")`, statements[2])
+
+	_, err = CreateTableSQL(struct {
+		Attrs map[string]string `db:"attrs"`
+	}{}, sb.MySQL)
+	test.Equal(t, true, err != nil)
+	_, err = CreateTableSQL("users", sb.MySQL)
+	test.Equal(t, true, err != nil)
+}
+
+func TestCreateTableSQLite(t *testing.T) {
+	db := useSQLite(t)
+	ddl, err := CreateTableSQL(ddlUser{}, sb.SQLite)
+	test.NoError(t, err)
+	test.Equal(t, true, strings.HasPrefix(ddl, `CREATE TABLE "users" ("id" INTEGER PRIMARY KEY AUTOINCREMENT, `))
+	_, err = db.Exec(ddl)
+	test.NoError(t, err)
+
+	ctx := WithNamespace(context.Background(), "team")
+	id, err := InsertOne(ctx, "", ddlUser{Email: "a@example.com", TenantID: 1, Price: "9.90"})
+	test.NoError(t, err)
+	test.Equal(t, int64(1), id)
+	_, err = InsertOne(ctx, "", ddlUser{Email: "a@example.com", TenantID: 2})
+	test.Equal(t, true, IsDuplicate(err))
+
+	var user ddlUser
+	test.NoError(t, GetByID(ctx, &user, "", 1))
+	test.Equal(t, "a@example.com", user.Email)
+	test.Equal(t, false, user.Created.IsZero())
+}
@@ -7,46 +7,68 @@ import (
 	"github.com/jmoiron/sqlx"
 )
 
-// DeleteWhere delete rows that match the filter from the given table
+// DeleteWhere delete rows that match the filter from the given table, an empty filter returns ErrUnsafeWrite unless the context is created by AllowFullTable.
+// The route of table is unknown from its name, register the model of the routed or sharded table by RegisterModels, otherwise ErrUnknownRoute is returned.
 func DeleteWhere(ctx context.Context, table string, filter KVs) error {
 	return DeleteWhereTx(ctx, nil, table, filter)
 }
 
-// DeleteWhereTx delete rows that match the filter in transaction from the given table
+// DeleteWhereTx delete rows that match the filter in transaction from the given table, an empty filter returns ErrUnsafeWrite unless the context is created by AllowFullTable.
+// The route of table is unknown from its name, register the model of the routed or sharded table by RegisterModels, otherwise ErrUnknownRoute is returned.
 func DeleteWhereTx(ctx context.Context, tx *sqlx.Tx, table string, filter KVs) error {
-	builder := sb.NewDeleteBuilder().DeleteFrom(table)
-	builder = builder.Where(WhereFromKVs(&builder.Cond, filter, nil)...)
-	var (
-		sql, args = Build(ctx, builder)
-		err       error
-	)
-	if tx == nil {
-		_, err = Exec(ctx, sql, args...)
-	} else {
-		_, err = ExecTx(ctx, tx, sql, args...)
+	if err := checkWriteFilter(ctx, table, filter); err != nil {
+		return err
 	}
-	return err
+	tables, err := shardTables(ctx, table, nil, filter)
+	if err != nil {
+		return err
+	}
+	for _, physical := range tables {
+		builder := sb.NewDeleteBuilder().DeleteFrom(physical)
+		builder = builder.Where(WhereFromKVs(&builder.Cond, filter, nil)...)
+		sql, args := Build(ctx, builder)
+		if tx == nil {
+			_, err = Exec(ctx, sql, args...)
+		} else {
+			_, err = ExecTx(ctx, tx, sql, args...)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	invalidateRows(ctx, table)
+	return nil
 }
 
-// DeleteWhere delete rows by id from the given table
+// DeleteByID delete rows by id from the given table.
+// The route of table is unknown from its name, register the model of the routed or sharded table by RegisterModels, otherwise ErrUnknownRoute is returned.
 func DeleteByID(ctx context.Context, table string, id ...any) error {
 	return DeleteByIDTx(ctx, nil, table, id...)
 }
 
-// DeleteWhere delete rows by id in transaction from the table
+// DeleteByIDTx delete rows by id in transaction from the table.
+// The route of table is unknown from its name, register the model of the routed or sharded table by RegisterModels, otherwise ErrUnknownRoute is returned.
 func DeleteByIDTx(ctx context.Context, tx *sqlx.Tx, table string, id ...any) error {
-	builder := sb.NewDeleteBuilder().DeleteFrom(table)
-	builder = builder.Where(WhereFrom(&builder.Cond, id, nil)...)
-	var (
-		err  error
-		sql  string
-		args []any
-	)
-	sql, args = Build(ctx, builder)
-	if tx == nil {
-		_, err = Exec(ctx, sql, args...)
-	} else {
-		_, err = ExecTx(ctx, tx, sql, args...)
+	if err := checkWriteFilter(ctx, table, id); err != nil {
+		return err
+	}
+	tables, err := shardTables(ctx, table, nil, id)
+	if err != nil {
+		return err
+	}
+	for _, physical := range tables {
+		builder := sb.NewDeleteBuilder().DeleteFrom(physical)
+		builder = builder.Where(WhereFrom(&builder.Cond, id, nil)...)
+		sql, args := Build(ctx, builder)
+		if tx == nil {
+			_, err = Exec(ctx, sql, args...)
+		} else {
+			_, err = ExecTx(ctx, tx, sql, args...)
+		}
+		if err != nil {
+			return err
+		}
 	}
-	return err
+	invalidateRows(ctx, table, id...)
+	return nil
 }
@@ -0,0 +1,250 @@
+package ormx
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+var (
+	// ErrUnknownColumn is returned when a field or sort column is not defined by the model, or is not a valid column name
+	ErrUnknownColumn = errors.New("unknown column")
+	// ErrUnsafeWrite is returned when an update or delete has no where condition, or the namespace is the only condition.
+	// Use AllowFullTable to bypass the check.
+	ErrUnsafeWrite = errors.New("unsafe write without where condition")
+	// ErrShardKeyMissing is returned when a query on the sharded table has no shard key and scatter is not allowed,
+	// or a row inserted has no shard key. Use AllowScatter to query all the shards.
+	ErrShardKeyMissing = errors.New("shard key missing")
+	// ErrClosed is returned when a query or transaction starts after Shutdown
+	ErrClosed = errors.New("database is closed")
+	// ErrCircuitOpen is returned when the circuit breaker of the master or slaves of the data source is open
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+	// ErrConcurrencyLimit is returned when the query waits for the concurrency limit longer than the queue timeout
+	ErrConcurrencyLimit = errors.New("too many concurrent queries")
+	// ErrQueryTimeout is matched by the TimeoutError returned when the query or transaction exceeds its deadline
+	ErrQueryTimeout = errors.New("query timeout")
+	// ErrUnknownDataSource is returned when the query is routed to a data source not registered by AddDataSource or RegisterDataSource
+	ErrUnknownDataSource = errors.New("unknown data source")
+	// ErrCrossDataSource is returned when the query in the transaction created by RunTxContext is routed to another data source,
+	// set the data source of the transaction by WithDataSource
+	ErrCrossDataSource = errors.New("query routed to another data source than the transaction")
+	// ErrUnknownRoute is returned by the functions taking the table name only, when the named data sources or shardings are registered,
+	// but neither the route of the table nor its model is registered, so the query may run on a wrong data source or table
+	ErrUnknownRoute = errors.New("unknown route")
+)
+
+// The kinds of database errors, they are matched by the DBError returned from queries with errors.Is
+var (
+	// ErrDuplicateKey is the violation of a primary key or unique index, DBError.Constraint is the name of the index
+	ErrDuplicateKey = errors.New("duplicate key")
+	// ErrForeignKey is the violation of a foreign key
+	ErrForeignKey = errors.New("foreign key violation")
+	// ErrDeadlock is returned when the transaction is rolled back by the deadlock detection
+	ErrDeadlock = errors.New("deadlock")
+	// ErrLockTimeout is returned when the query waits for a lock too long
+	ErrLockTimeout = errors.New("lock wait timeout")
+	// ErrDataTooLong is returned when the value exceeds the size of the column
+	ErrDataTooLong = errors.New("data too long")
+	// ErrConnection is returned when the connection to the database is broken or refused
+	ErrConnection = errors.New("connection error")
+	// ErrReadOnly is returned when writing to a read-only database, such as a replica or the master during failover
+	ErrReadOnly = errors.New("database is read-only")
+)
+
+// DBError is the classified error returned by the database, it matches its Kind by errors.Is, and unwraps to the driver error
+type DBError struct {
+	// Kind is one of ErrDuplicateKey, ErrForeignKey, ErrDeadlock, ErrLockTimeout, ErrDataTooLong, ErrConnection and ErrReadOnly
+	Kind error
+	// Constraint is the name of the index or constraint violated, it's parsed from the message and may be empty
+	Constraint string
+	// Fingerprint is the fingerprint of the query failed as in QueryStats, and Query is the normalized sql without literal values.
+	// They are empty if the error is returned by the transaction.
+	Fingerprint string
+	Query       string
+	Err         error
+}
+
+func (e *DBError) Error() string {
+	msg := e.Kind.Error()
+	if e.Constraint != "" {
+		msg += " " + e.Constraint
+	}
+	if e.Fingerprint != "" {
+		msg += fmt.Sprintf(" in query %s %q", e.Fingerprint, e.Query)
+	}
+	return msg + ": " + e.Err.Error()
+}
+
+func (e *DBError) Unwrap() error {
+	return e.Err
+}
+
+func (e *DBError) Is(target error) bool {
+	return target == e.Kind
+}
+
+// IsRetryable return true if the query or transaction may succeed by retrying, such as deadlock, lock timeout, throttled,
+// and the connection failed before the query sent to the server. The transaction should be retried as a whole.
+//
+// The connection lost after the query sent, such as the server gone away, is ErrConnection but not retryable,
+// as the query may have been executed, retry it only if it's idempotent.
+func IsRetryable(err error) bool {
+	switch errorKind(err) {
+	case ErrDeadlock, ErrLockTimeout:
+		return true
+	case ErrConnection:
+		return isConnectFailure(err)
+	}
+	return errors.Is(err, ErrConcurrencyLimit)
+}
+
+// isConnectFailure return true if the connection failed before the query sent to the server, such as the bad connection
+// returned by driver, the server refused or unreachable
+func isConnectFailure(err error) bool {
+	var (
+		mysqlErr *mysql.MySQLError
+		stateErr sqlStateError
+	)
+	switch {
+	case errors.Is(err, driver.ErrBadConn):
+		return true
+	case errors.As(err, &mysqlErr):
+		switch mysqlErr.Number {
+		case 1040, 1129, 2002, 2003:
+			return true
+		}
+	case errors.As(err, &stateErr):
+		switch stateErr.SQLState() {
+		case "08001", "08004", "57P03":
+			return true
+		}
+	}
+	return false
+}
+
+// dbError wrap err into DBError with the fingerprint and normalized sql of the query if it's a known kind of database error
+func dbError(fingerprint, query string, err error) error {
+	if err == nil || errors.As(err, new(*DBError)) {
+		return err
+	}
+	kind, constraint := classifyError(err)
+	if kind == nil {
+		return err
+	}
+	return &DBError{Kind: kind, Constraint: constraint, Fingerprint: fingerprint, Query: query, Err: err}
+}
+
+// errorKind return the kind of err, nil if unknown; err is not necessary to be wrapped by DBError
+func errorKind(err error) error {
+	var dbErr *DBError
+	if errors.As(err, &dbErr) {
+		return dbErr.Kind
+	}
+	kind, _ := classifyError(err)
+	return kind
+}
+
+// sqlStateError is implemented by the errors of PostgreSQL drivers
+type sqlStateError interface {
+	SQLState() string
+}
+
+// classifyError return the kind of the driver error and the constraint violated.
+// The errors of MySQL and PostgreSQL are classified by the codes, and the ones of SQLite by the messages.
+func classifyError(err error) (error, string) {
+	var (
+		mysqlErr *mysql.MySQLError
+		stateErr sqlStateError
+		netErr   net.Error
+	)
+	switch {
+	case err == nil, errors.Is(err, ErrQueryTimeout):
+		return nil, ""
+	case errors.As(err, &mysqlErr):
+		return classifyMySQL(mysqlErr)
+	case errors.As(err, &stateErr):
+		return classifyPostgres(stateErr.SQLState(), err.Error())
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, mysql.ErrInvalidConn), errors.Is(err, sql.ErrConnDone), errors.As(err, &netErr):
+		return ErrConnection, ""
+	}
+	return classifySQLite(err.Error())
+}
+
+func classifyMySQL(err *mysql.MySQLError) (error, string) {
+	switch err.Number {
+	case 1062, 1586:
+		// Duplicate entry 'x' for key 'table.index', the table is absent before MySQL 8.0.19
+		name := quotedAfter(err.Message, "for key '", '\'')
+		return ErrDuplicateKey, name[strings.LastIndexByte(name, '.')+1:]
+	case 1216, 1217, 1451, 1452:
+		return ErrForeignKey, quotedAfter(err.Message, "CONSTRAINT `", '`')
+	case 1213:
+		return ErrDeadlock, ""
+	case 1205:
+		return ErrLockTimeout, ""
+	case 1406:
+		return ErrDataTooLong, quotedAfter(err.Message, "for column '", '\'')
+	case 1290:
+		// 1290 is returned for the options other than read-only too, such as --secure-file-priv
+		if strings.Contains(err.Message, "read-only") {
+			return ErrReadOnly, ""
+		}
+	case 1792, 1836:
+		return ErrReadOnly, ""
+	case 1040, 1053, 1129, 1158, 1159, 1160, 1161, 2002, 2003, 2006, 2013:
+		return ErrConnection, ""
+	}
+	return nil, ""
+}
+
+func classifyPostgres(state, msg string) (error, string) {
+	switch {
+	case state == "23505":
+		return ErrDuplicateKey, quotedAfter(msg, "constraint \"", '"')
+	case state == "23503":
+		return ErrForeignKey, quotedAfter(msg, "constraint \"", '"')
+	case state == "40P01":
+		return ErrDeadlock, ""
+	case state == "55P03":
+		return ErrLockTimeout, ""
+	case state == "22001":
+		return ErrDataTooLong, ""
+	case state == "25006":
+		return ErrReadOnly, ""
+	case strings.HasPrefix(state, "08"), state == "57P01", state == "57P02", state == "57P03":
+		return ErrConnection, ""
+	}
+	return nil, ""
+}
+
+func classifySQLite(msg string) (error, string) {
+	switch {
+	case strings.HasPrefix(msg, "UNIQUE constraint failed: "):
+		return ErrDuplicateKey, strings.TrimPrefix(msg, "UNIQUE constraint failed: ")
+	case strings.HasPrefix(msg, "FOREIGN KEY constraint failed"):
+		return ErrForeignKey, ""
+	case strings.HasPrefix(msg, "database is locked"), strings.HasPrefix(msg, "database table is locked"):
+		return ErrLockTimeout, ""
+	case strings.HasPrefix(msg, "attempt to write a readonly database"):
+		return ErrReadOnly, ""
+	}
+	return nil, ""
+}
+
+// quotedAfter return the text quoted after prefix in msg, empty if not found
+func quotedAfter(msg, prefix string, quote byte) string {
+	i := strings.Index(msg, prefix)
+	if i < 0 {
+		return ""
+	}
+	msg = msg[i+len(prefix):]
+	if j := strings.IndexByte(msg, quote); j >= 0 {
+		return msg[:j]
+	}
+	return ""
+}
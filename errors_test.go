@@ -0,0 +1,105 @@
+package ormx
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/cloudfly/ormx/sqlparse"
+	"github.com/cloudfly/ormx/test"
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestDBError(t *testing.T) {
+	useSQLite(t)
+	var (
+		ctx    = context.Background()
+		events = recordEvents(t)
+		insert = "INSERT INTO test (id, producer, resource, action) VALUES (1, 'unittest', 'errors', 'test')"
+	)
+	_, err := Exec(ctx, insert)
+	test.NoError(t, err)
+	_, err = Exec(ctx, insert)
+	test.Equal(t, true, IsDuplicate(err))
+	test.Equal(t, true, errors.Is(err, ErrDuplicateKey))
+	test.Equal(t, false, errors.Is(err, ErrForeignKey))
+	test.Equal(t, false, IsRetryable(err))
+
+	var dbErr *DBError
+	test.Equal(t, true, errors.As(err, &dbErr))
+	test.Equal(t, "test.id", dbErr.Constraint)
+	test.Equal(t, "INSERT INTO test (id, producer, resource, action) VALUES (?, ?, ?, ?)", dbErr.Query)
+	test.Equal(t, sqlparse.Fingerprint(insert), dbErr.Fingerprint)
+	test.Equal(t, "duplicate_key", (*events)[len(*events)-1].ErrClass)
+
+	// the driver errors not wrapped are classified too
+	_, err = Master().Exec(insert)
+	test.Equal(t, true, IsDuplicate(err))
+	test.Equal(t, false, IsDuplicate(errors.New("Duplicate")))
+	test.Equal(t, false, IsDuplicate(nil))
+}
+
+type sqlStateErr string
+
+func (e sqlStateErr) Error() string    { return "pq: " + string(e) }
+func (e sqlStateErr) SQLState() string { return string(e) }
+
+func TestClassifyError(t *testing.T) {
+	cases := []struct {
+		err        error
+		kind       error
+		constraint string
+	}{
+		{&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'a' for key 'users.uk_name'"}, ErrDuplicateKey, "uk_name"},
+		{&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'a' for key 'PRIMARY'"}, ErrDuplicateKey, "PRIMARY"},
+		{&mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row: a foreign key constraint fails (`db`.`orders`, CONSTRAINT `fk_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`))"}, ErrForeignKey, "fk_user"},
+		{&mysql.MySQLError{Number: 1213}, ErrDeadlock, ""},
+		{&mysql.MySQLError{Number: 1205}, ErrLockTimeout, ""},
+		{&mysql.MySQLError{Number: 1406, Message: "Data too long for column 'name' at row 1"}, ErrDataTooLong, "name"},
+		{&mysql.MySQLError{Number: 1290, Message: "The MySQL server is running with the --read-only option so it cannot execute this statement"}, ErrReadOnly, ""},
+		{&mysql.MySQLError{Number: 1290, Message: "The MySQL server is running with the --secure-file-priv option so it cannot execute this statement"}, nil, ""},
+		{&mysql.MySQLError{Number: 1040}, ErrConnection, ""},
+		{&mysql.MySQLError{Number: 1146}, nil, ""},
+		{&mysql.MySQLError{Number: mysqlQueryTimeout}, nil, ""},
+		{fmt.Errorf("query: %w", driver.ErrBadConn), ErrConnection, ""},
+		{mysql.ErrInvalidConn, ErrConnection, ""},
+		{sqlStateErr("23505"), ErrDuplicateKey, ""},
+		{sqlStateErr("40P01"), ErrDeadlock, ""},
+		{sqlStateErr("08006"), ErrConnection, ""},
+		{errors.New("FOREIGN KEY constraint failed"), ErrForeignKey, ""},
+		{errors.New("database is locked"), ErrLockTimeout, ""},
+		{errors.New("no such table: missing"), nil, ""},
+	}
+	for _, c := range cases {
+		kind, constraint := classifyError(c.err)
+		test.Equal(t, c.kind, kind)
+		test.Equal(t, c.constraint, constraint)
+	}
+	kind, constraint := classifyPostgres("23505", `duplicate key value violates unique constraint "users_name_key"`)
+	test.Equal(t, ErrDuplicateKey, kind)
+	test.Equal(t, "users_name_key", constraint)
+}
+
+func TestIsRetryable(t *testing.T) {
+	deadlock := dbError("3f1b", "UPDATE test SET action = ?", &mysql.MySQLError{Number: 1213, Message: "Deadlock found"})
+	test.Equal(t, true, IsRetryable(fmt.Errorf("transfer: %w", deadlock)))
+	test.Equal(t, true, IsRetryable(&mysql.MySQLError{Number: 1205}))
+	test.Equal(t, true, IsRetryable(driver.ErrBadConn))
+	test.Equal(t, true, IsRetryable(&mysql.MySQLError{Number: 1040}))
+	test.Equal(t, true, IsRetryable(sqlStateErr("08001")))
+	// the query may have been executed if the connection lost after it sent
+	test.Equal(t, false, IsRetryable(&mysql.MySQLError{Number: 2013}))
+	test.Equal(t, false, IsRetryable(mysql.ErrInvalidConn))
+	test.Equal(t, false, IsRetryable(sqlStateErr("08006")))
+	test.Equal(t, true, IsRetryable(ErrConcurrencyLimit))
+	test.Equal(t, false, IsRetryable(&mysql.MySQLError{Number: 1062}))
+	test.Equal(t, false, IsRetryable(nil))
+
+	var mysqlErr *mysql.MySQLError
+	test.Equal(t, true, errors.As(deadlock, &mysqlErr))
+	test.Equal(t, `deadlock in query 3f1b "UPDATE test SET action = ?": Error 1213: Deadlock found`, deadlock.Error())
+	// DBError is not wrapped twice
+	test.Equal(t, deadlock, dbError("", "", deadlock))
+}
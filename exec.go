@@ -3,9 +3,14 @@ package ormx
 import (
 	"context"
 	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
 
+	"github.com/cloudfly/ormx/sqlparse"
 	"github.com/jmoiron/sqlx"
-	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -15,102 +20,367 @@ var (
 // DBProvider
 type DBProvider func(isMaster bool) *sqlx.DB
 
-// RunTxContext execute a transiction
+type txHooksCtxKey struct{}
+
+// txHooks is the state of the transaction created by RunTxContext, source is the data source it runs on
+type txHooks struct {
+	source *dataSource
+	lock   sync.Mutex
+	funcs  []func()
+}
+
+// RunTxContext execute a transiction, the functions registered by AfterCommit with the ctx passed to f will be called after the transaction committed.
+//
+// The transaction runs on the master of the data source set by WithDataSource, or the default data source.
+// The ExecTx, SelectTx and GetTx with the ctx passed to f fail with ErrCrossDataSource if the sql is routed to another data source,
+// such as the table of a model routed by Database() method, so set the data source by WithDataSource for these tables.
+// The database.timeout.tx is applied if ctx has no deadline, and TimeoutError is returned if the transaction exceeds the deadline.
+// The errors of database are wrapped into DBError, use IsRetryable to check if the transaction should be retried.
 func RunTxContext(ctx context.Context, f func(ctx context.Context, tx *sqlx.Tx) error) error {
-	db := Master()
-	tx, err := db.BeginTxx(ctx, nil)
+	if err := acquireQuery(); err != nil {
+		return err
+	}
+	defer releaseQuery()
+	source, err := sourceFor(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer source.release()
+	ctx, timeout, cancel := withDefaultTimeout(ctx, OpTx)
+	defer cancel()
+	ctx, span := startTxSpan(ctx)
+	start := time.Now()
+	tx, err := source.db(true).BeginTxx(ctx, nil)
+	if err != nil {
+		err = dbError("", "", timeoutError(ctx, OpTx, timeout, err))
+		endSpan(span, 0, err)
+		observe(ctx, QueryEvent{Operation: OpTx, Master: true, InTx: true, Duration: time.Since(start), Err: err})
+		return err
+	}
 
-	if err := f(ctx, tx); err != nil {
-		if err := tx.Rollback(); err != nil {
-			return err
+	hooks := &txHooks{source: source}
+	if err := f(context.WithValue(ctx, txHooksCtxKey{}, hooks), tx); err != nil {
+		if rerr := tx.Rollback(); rerr != nil {
+			err = rerr
 		}
+		err = dbError("", "", timeoutError(ctx, OpTx, timeout, err))
+		endSpan(span, 0, err)
+		observe(ctx, QueryEvent{Operation: OpTx, Master: true, InTx: true, Duration: time.Since(start), Err: err})
+		return err
+	}
+
+	err = dbError("", "", timeoutError(ctx, OpTx, timeout, tx.Commit()))
+	endSpan(span, 0, err)
+	observe(ctx, QueryEvent{Operation: OpTx, Master: true, InTx: true, Duration: time.Since(start), Err: err})
+	if err != nil {
 		return err
 	}
+	hooks.lock.Lock()
+	defer hooks.lock.Unlock()
+	for _, f := range hooks.funcs {
+		f()
+	}
+	return nil
+}
 
-	return tx.Commit()
+// AfterCommit register f to be called after the transaction created by RunTxContext committed, it's dropped if the transaction rollback.
+//
+// f is called immediately if ctx is not in the transaction created by RunTxContext.
+func AfterCommit(ctx context.Context, f func()) {
+	hooks, ok := ctx.Value(txHooksCtxKey{}).(*txHooks)
+	if !ok {
+		f()
+		return
+	}
+	hooks.lock.Lock()
+	defer hooks.lock.Unlock()
+	hooks.funcs = append(hooks.funcs, f)
 }
 
-// Exec execute a sql on master DB
+// Exec execute a sql on master DB of the data source the tables in sql routed to.
+//
+// The query fails with ErrCircuitOpen or ErrConcurrencyLimit if it's rejected by the admission control set by SetLimits, so do Select and Get.
+// The errors of database are wrapped into DBError with the fingerprint of sql, such as ErrDuplicateKey and ErrDeadlock.
 func Exec(ctx context.Context, sql string, args ...interface{}) (driver.Result, error) {
-	zerolog.Ctx(ctx).Info().Str("query", sql).Any("args", args).Msg("Executing sql query")
-	emitMetric(ctx, sql)
-	return Master().ExecContext(ctx, sql, args...)
+	if err := acquireQuery(); err != nil {
+		return nil, err
+	}
+	defer releaseQuery()
+	q, err := newQuery(ctx, sql, args)
+	if err != nil {
+		return nil, err
+	}
+	defer q.source.release()
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+	ctx = q.begin(ctx, q.source.db(true), true, false)
+	if err := q.admit(ctx); err != nil {
+		return nil, err
+	}
+	r, err := q.db.ExecContext(ctx, sql, args...)
+	err = q.wrapError(ctx, err)
+	q.end(ctx, rowsAffected(r, err), err)
+	if err == nil {
+		recordSessionWrite(ctx, q.source)
+	}
+	return r, err
 }
 
 // Exec execute a sql in transaction
 func ExecTx(ctx context.Context, tx *sqlx.Tx, sql string, args ...interface{}) (driver.Result, error) {
-	zerolog.Ctx(ctx).Info().Str("query", sql).Any("args", args).Msg("Executing sql query")
-	emitMetric(ctx, sql)
-	return tx.ExecContext(ctx, sql, args...)
+	q, err := newTxQuery(ctx, sql, args)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+	ctx = q.begin(ctx, nil, true, true)
+	r, err := tx.ExecContext(ctx, sql, args...)
+	err = q.wrapError(ctx, err)
+	q.end(ctx, rowsAffected(r, err), err)
+	if err == nil {
+		recordSessionWrite(ctx, q.source)
+	}
+	return r, err
 }
 
 // Select will query data into dest with raw sql and args.
 //
-// it will auto query from master if the context having FromMaster
+// it will auto query from master if the context having FromMaster, or the Session of the context wrote recently
 func Select(ctx context.Context, dest interface{}, sql string, args ...interface{}) error {
-	var (
-		db *sqlx.DB
-	)
-	if isFromMaster(ctx) {
-		db = Master()
-		zerolog.Ctx(ctx).Info().Str("query", sql).Any("args", args).Msg("Selecting on master")
-	} else {
-		db = Slave()
-		zerolog.Ctx(ctx).Debug().Str("query", sql).Any("args", args).Msg("Selecting on slave")
+	if err := acquireQuery(); err != nil {
+		return err
+	}
+	defer releaseQuery()
+	q, err := newQuery(ctx, sql, args)
+	if err != nil {
+		return err
+	}
+	defer q.source.release()
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+	db, master := readDB(ctx, q.source)
+	ctx = q.begin(ctx, db, master, false)
+	if err := q.admit(ctx); err != nil {
+		return err
 	}
-	emitMetric(ctx, sql)
-	return db.SelectContext(ctx, dest, sql, args...)
+	err = db.SelectContext(ctx, dest, executionHint(ctx, q.op, sql), args...)
+	err = q.wrapError(ctx, err)
+	q.end(ctx, resultRows(dest), err)
+	return err
 }
 
 // Select will query data into dest with raw sql and args.
 //
 // it will auto query from master if the context having FromMaster
 func SelectTx(ctx context.Context, tx *sqlx.Tx, dest interface{}, sql string, args ...interface{}) error {
-	zerolog.Ctx(ctx).Info().Str("query", sql).Any("args", args).Msg("Selecting in transaction")
-	emitMetric(ctx, sql)
-	return tx.SelectContext(ctx, dest, sql, args...)
+	q, err := newTxQuery(ctx, sql, args)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+	ctx = q.begin(ctx, nil, true, true)
+	err = tx.SelectContext(ctx, dest, sql, args...)
+	err = q.wrapError(ctx, err)
+	q.end(ctx, resultRows(dest), err)
+	return err
 }
 
 // Get will get one data into dest with raw sql and args.
 //
-// it will auto query from master if the context having FromMaster
+// it will auto query from master if the context having FromMaster, or the Session of the context wrote recently
 func Get(ctx context.Context, dest interface{}, sql string, args ...interface{}) error {
-	var (
-		db *sqlx.DB
-	)
-	if isFromMaster(ctx) {
-		db = Master()
-		zerolog.Ctx(ctx).Info().Str("query", sql).Any("args", args).Msg("Getting on master")
-	} else {
-		db = Slave()
-		zerolog.Ctx(ctx).Debug().Str("query", sql).Any("args", args).Msg("Getting on slave")
+	if err := acquireQuery(); err != nil {
+		return err
 	}
-	emitMetric(ctx, sql)
-	return db.GetContext(ctx, dest, sql, args...)
+	defer releaseQuery()
+	q, err := newQuery(ctx, sql, args)
+	if err != nil {
+		return err
+	}
+	defer q.source.release()
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+	db, master := readDB(ctx, q.source)
+	ctx = q.begin(ctx, db, master, false)
+	if err := q.admit(ctx); err != nil {
+		return err
+	}
+	err = db.GetContext(ctx, dest, executionHint(ctx, q.op, sql), args...)
+	err = q.wrapError(ctx, err)
+	q.end(ctx, gotRows(err), err)
+	return err
 }
 
 // Get will get one data from tx by using raw sql and args.
 func GetTx(ctx context.Context, tx *sqlx.Tx, dest interface{}, sql string, args ...interface{}) error {
-	zerolog.Ctx(ctx).Info().Str("query", sql).Any("args", args).Msg("Getting in transaction")
-	emitMetric(ctx, sql)
-	return tx.GetContext(ctx, dest, sql, args...)
+	q, err := newTxQuery(ctx, sql, args)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+	ctx = q.begin(ctx, nil, true, true)
+	err = tx.GetContext(ctx, dest, sql, args...)
+	err = q.wrapError(ctx, err)
+	q.end(ctx, gotRows(err), err)
+	return err
 }
 
 // Master return master *sqlx.DB which returned by DBProvider, panic if DBProvider is not Initilized
 func Master() *sqlx.DB {
-	if p == nil {
-		panic("db getter is nil, call ormx.Init to initilaze the DBGetter")
-	}
-	return p(true)
+	return defaultSource().db(true)
 }
 
 // Master return slave *sqlx.DB which returned by DBProvider, panic if DBProvider is not Initilized
 func Slave() *sqlx.DB {
-	if p == nil {
-		panic("db getter is nil, call ormx.Init to initilaze the DBGetter")
+	return defaultSource().db(false)
+}
+
+// queryRun tracks a query from begin to end for the observing and tracing
+type queryRun struct {
+	// source is the data source the query routed to, db is the pool executing the query, nil if in transaction
+	source *dataSource
+	db     *sqlx.DB
+	sql    string
+	args   []any
+	master bool
+	inTx   bool
+	op     Operation
+	tables []string
+	start  time.Time
+	span   trace.Span
+	// gate is the admission control the query admitted by, nil if not admitted, probe is true if the query probes the half open circuit
+	gate  *gate
+	probe bool
+	// timeout is the default timeout applied, 0 if not applied
+	timeout time.Duration
+
+	// tokens of sql are shared by parsing, normalizing and fingerprinting, so the sql is tokenized once
+	tokens     []sqlparse.Token
+	fp         string
+	normalized string
+}
+
+// fingerprint return the fingerprint of the sql, it's computed once
+func (q *queryRun) fingerprint() string {
+	if q.fp == "" {
+		q.fp = sqlparse.FingerprintTokens(q.tokens)
+	}
+	return q.fp
+}
+
+// normalize return the normalized sql, it's computed once
+func (q *queryRun) normalize() string {
+	if q.normalized == "" {
+		q.normalized = sqlparse.NormalizeTokens(q.tokens)
+	}
+	return q.normalized
+}
+
+// newQuery parse the sql and resolve the data source it routed to
+func newQuery(ctx context.Context, sql string, args []any) (*queryRun, error) {
+	q := &queryRun{sql: sql, args: args, tokens: sqlparse.TokenizeDialect(sql, lexicalDialect())}
+	q.op, q.tables = statementInfoOf(sqlparse.ParseTokens(q.tokens))
+	var err error
+	if q.source, err = sourceFor(ctx, q.tables); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// newTxQuery create the query executed in transaction, it fails with ErrCrossDataSource if the query is routed to
+// another data source than the transaction created by RunTxContext
+func newTxQuery(ctx context.Context, sql string, args []any) (*queryRun, error) {
+	q, err := newQuery(ctx, sql, args)
+	if err != nil {
+		return nil, err
+	}
+	// the data source is referenced by the transaction
+	q.source.release()
+	if hooks, ok := ctx.Value(txHooksCtxKey{}).(*txHooks); ok && hooks.source.name != q.source.name {
+		return nil, fmt.Errorf("%w: the transaction runs on data source %q, but the query on %v is routed to %q", ErrCrossDataSource, hooks.source.name, q.tables, q.source.name)
+	}
+	return q, nil
+}
+
+// begin start tracking the query executed on db, the returned ctx carries the span and should be used to execute the query
+func (q *queryRun) begin(ctx context.Context, db *sqlx.DB, master, inTx bool) context.Context {
+	q.db, q.master, q.inTx = db, master, inTx
+	logQuery(ctx, q)
+	ctx, q.span = startQuerySpan(ctx, q.normalize, q.op, q.tables, master)
+	q.start = time.Now()
+	return ctx
+}
+
+// withTimeout apply the default timeout of the operation if ctx has no deadline, the returned cancel should be called after the query
+func (q *queryRun) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	var cancel context.CancelFunc
+	ctx, q.timeout, cancel = withDefaultTimeout(ctx, q.op)
+	return ctx, cancel
+}
+
+// timeoutError wrap the error caused by the deadline into TimeoutError
+func (q *queryRun) timeoutError(ctx context.Context, err error) error {
+	return timeoutError(ctx, q.op, q.timeout, err)
+}
+
+// wrapError wrap the error of the query into TimeoutError, or DBError with the fingerprint and normalized sql if it's a known kind of database error
+func (q *queryRun) wrapError(ctx context.Context, err error) error {
+	err = q.timeoutError(ctx, err)
+	if err == nil || errors.Is(err, ErrQueryTimeout) {
+		return err
+	}
+	return dbError(q.fingerprint(), q.normalize(), err)
+}
+
+// admit wait for the admission of the master or slaves of the data source, the query is ended with the error if rejected
+func (q *queryRun) admit(ctx context.Context) error {
+	g := gateFor(q.source.name, q.master)
+	probe, err := g.acquire(ctx)
+	if err != nil {
+		err = q.timeoutError(ctx, err)
+		q.end(ctx, 0, err)
+		return err
+	}
+	q.gate, q.probe, q.start = g, probe, time.Now()
+	return nil
+}
+
+// end finish the query with the rows affected or returned, and the error
+func (q *queryRun) end(ctx context.Context, rows int64, err error) {
+	duration := time.Since(q.start)
+	if q.gate != nil {
+		q.gate.release(duration, err, q.probe)
+	}
+	endSpan(q.span, rows, err)
+	logSlowQuery(ctx, q, duration, err)
+	recordQueryStat(q, duration, rows, err)
+	observe(ctx, QueryEvent{
+		Operation:  q.op,
+		Tables:     q.tables,
+		DataSource: q.source.name,
+		SQL:        q.sql,
+		Master:     q.master,
+		InTx:       q.inTx,
+		Rows:       rows,
+		Duration:   duration,
+		Err:        err,
+	})
+}
+
+func rowsAffected(r driver.Result, err error) int64 {
+	if err != nil || r == nil {
+		return 0
+	}
+	n, _ := r.RowsAffected()
+	return n
+}
+
+func gotRows(err error) int64 {
+	if err != nil {
+		return 0
 	}
-	return p(false)
+	return 1
 }
@@ -0,0 +1,92 @@
+package ormx
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cloudfly/ormx/test"
+	"github.com/jmoiron/sqlx"
+)
+
+// useSQLite route all the queries to a new sqlite database until the test finished
+func useSQLite(t *testing.T) *sqlx.DB {
+	db := test.SQLite(t)
+	prevProvider, prevDialect := p, *dialect
+	p, *dialect = func(bool) *sqlx.DB { return db }, "sqlite3"
+	t.Cleanup(func() { p, *dialect = prevProvider, prevDialect })
+	return db
+}
+
+func recordEvents(t *testing.T) *[]QueryEvent {
+	var events []QueryEvent
+	SetObserver(ObserverFunc(func(_ context.Context, event QueryEvent) {
+		events = append(events, event)
+	}))
+	t.Cleanup(func() { SetObserver(nil) })
+	return &events
+}
+
+func TestObserver(t *testing.T) {
+	useSQLite(t)
+	var (
+		ctx    = context.Background()
+		events = recordEvents(t)
+		row    = TestRow{Producer: "unittest", Resource: "observer", Action: "test"}
+	)
+
+	id, err := InsertOne(ctx, "", row)
+	test.NoError(t, err)
+	test.Equal(t, 1, len(*events))
+	test.Equal(t, OpInsert, (*events)[0].Operation)
+	test.Equal(t, []string{"test"}, (*events)[0].Tables)
+	test.Equal(t, true, (*events)[0].Master)
+	test.Equal(t, int64(1), (*events)[0].Rows)
+
+	var rows []TestRow
+	test.NoError(t, SelectWhere(ctx, &rows, "", nil, nil, nil, 0, 0))
+	event := (*events)[1]
+	test.Equal(t, OpSelect, event.Operation)
+	test.Equal(t, false, event.Master)
+	test.Equal(t, int64(1), event.Rows)
+
+	var got TestRow
+	err = Get(FromMaster(ctx), &got, "SELECT * FROM test WHERE id = ?", id+1)
+	test.Equal(t, true, IsNotFound(err))
+	event = (*events)[2]
+	test.Equal(t, true, event.Master)
+	test.Equal(t, "not_found", event.ErrClass)
+
+	boom := errors.New("boom")
+	err = RunTxContext(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+		if _, err := ExecTx(ctx, tx, "DELETE FROM test WHERE id = ?", id); err != nil {
+			return err
+		}
+		return boom
+	})
+	test.Equal(t, boom, err)
+	test.Equal(t, OpDelete, (*events)[3].Operation)
+	test.Equal(t, true, (*events)[3].InTx)
+	test.Equal(t, OpTx, (*events)[4].Operation)
+	test.Equal(t, "other", (*events)[4].ErrClass)
+}
+
+func TestMetricHandlerObserver(t *testing.T) {
+	var emitted []string
+	h := MetricHandlerObserver(metricHandlerFunc(func(_ context.Context, table string, write bool) {
+		if write {
+			table += ":write"
+		}
+		emitted = append(emitted, table)
+	}))
+	h.Observe(context.Background(), QueryEvent{Operation: OpUpdate, Tables: []string{"a"}})
+	h.Observe(context.Background(), QueryEvent{Operation: OpSelect, Tables: []string{"b"}})
+	h.Observe(context.Background(), QueryEvent{Operation: OpTx})
+	test.Equal(t, []string{"a:write", "b"}, emitted)
+}
+
+type metricHandlerFunc func(context.Context, string, bool)
+
+func (f metricHandlerFunc) Emit(ctx context.Context, table string, write bool) {
+	f(ctx, table, write)
+}
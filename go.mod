@@ -2,17 +2,33 @@ module github.com/cloudfly/ormx
 
 go 1.22.2
 
-require github.com/hashicorp/golang-lru v1.0.2
+require (
+	github.com/alicebob/miniredis/v2 v2.31.1
+	github.com/cloudfly/flagx v0.2.0
+	github.com/go-sql-driver/mysql v1.8.1
+	github.com/huandu/go-sqlbuilder v1.19.0
+	github.com/jmoiron/sqlx v1.4.0
+	github.com/mattn/go-sqlite3 v1.14.22
+	github.com/redis/go-redis/v9 v9.5.1
+	github.com/rs/zerolog v1.33.0
+	go.opentelemetry.io/otel v1.28.0
+	go.opentelemetry.io/otel/sdk v1.28.0
+	go.opentelemetry.io/otel/trace v1.28.0
+	golang.org/x/sync v0.7.0
+)
 
 require (
 	filippo.io/edwards25519 v1.1.0 // indirect
-	github.com/cloudfly/flagx v0.2.0 // indirect
-	github.com/go-sql-driver/mysql v1.8.1 // indirect
-	github.com/huandu/go-sqlbuilder v1.19.0 // indirect
+	github.com/alicebob/gopher-json v0.0.0-20200520072559-a9ecdc9d1d3a // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/go-logr/logr v1.4.2 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/google/uuid v1.6.0 // indirect
 	github.com/huandu/xstrings v1.4.0 // indirect
-	github.com/jmoiron/sqlx v1.4.0 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.19 // indirect
-	github.com/rs/zerolog v1.33.0 // indirect
-	golang.org/x/sys v0.12.0 // indirect
+	github.com/yuin/gopher-lua v1.1.0 // indirect
+	go.opentelemetry.io/otel/metric v1.28.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
 )
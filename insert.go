@@ -21,28 +21,10 @@ func InsertIgnoreTx(ctx context.Context, tx *sqlx.Tx, table string, data ...any)
 	if len(data) == 0 {
 		return nil
 	}
-	var (
-		err error
-	)
 	if table == "" {
-		table = TableName(data)
-	}
-	ib, err := NewInsertBuilderFromStruct(ctx, table, data[0])
-	if err != nil {
-		return fmt.Errorf("create insert builder from structure error: %w", err)
-	}
-	ib = ib.InsertIgnoreInto(table)
-	sql, args := Build(ctx, ib)
-
-	if tx == nil {
-		_, err = Exec(ctx, sql, args...)
-	} else {
-		_, err = ExecTx(ctx, tx, sql, args...)
-	}
-	if err != nil {
-		return fmt.Errorf("exec error: %w", err)
+		table = TableName(data[0])
 	}
-	return nil
+	return insertShards(ctx, tx, table, data, true)
 }
 
 // InsertManyTx insert rows in transaction, the all data type should be same structure.
@@ -55,29 +37,69 @@ func InsertManyTx(ctx context.Context, tx *sqlx.Tx, table string, data ...any) e
 	if len(data) == 0 {
 		return nil
 	}
-	var (
-		err error
-	)
 	if table == "" {
-		table = TableName(data)
+		table = TableName(data[0])
 	}
-	ib, err := NewInsertBuilderFromStruct(ctx, table, data[0])
+	return insertShards(ctx, tx, table, data, false)
+}
+
+// insertShards insert the rows into the shards of table located by their shard keys,
+// the cached rows are invalidated by the primary keys of data and the auto increment ids of the rows without primary key
+func insertShards(ctx context.Context, tx *sqlx.Tx, table string, data []any, ignore bool) error {
+	tables, groups, err := shardRows(ctx, table, data)
 	if err != nil {
-		return fmt.Errorf("create insert builder from structure error: %w", err)
+		return err
 	}
-	sql, args := Build(ctx, ib)
+	var ids []any
+	for _, physical := range tables {
+		ib, err := NewInsertBuilderFromStruct(ctx, physical, groups[physical]...)
+		if err != nil {
+			return fmt.Errorf("create insert builder from structure error: %w", err)
+		}
+		if ignore {
+			ib = ib.InsertIgnoreInto(physical)
+		}
+		sql, args := Build(ctx, ib)
 
-	if tx == nil {
-		_, err = Exec(ctx, sql, args...)
-	} else {
-		_, err = ExecTx(ctx, tx, sql, args...)
+		var r driver.Result
+		if tx == nil {
+			r, err = Exec(ctx, sql, args...)
+		} else {
+			r, err = ExecTx(ctx, tx, sql, args...)
+		}
+		if err != nil {
+			return fmt.Errorf("exec error: %w", err)
+		}
+		rows := groups[physical]
+		known := primaryKeyValues(rows...)
+		ids = append(append(ids, known...), insertedIDs(r, len(rows)-len(known))...)
 	}
-	if err != nil {
-		return fmt.Errorf("exec error: %w", err)
+	if len(ids) > 0 {
+		invalidateRows(ctx, table, ids...)
 	}
 	return nil
 }
 
+// insertedIDs return the auto increment ids of the n rows inserted by r, LastInsertId is the first id on MySQL and the last one on SQLite.
+// It's nil if the driver doesn't support LastInsertId, such as PostgreSQL.
+func insertedIDs(r driver.Result, n int) []any {
+	if n <= 0 {
+		return nil
+	}
+	id, err := r.LastInsertId()
+	if err != nil || id <= 0 {
+		return nil
+	}
+	if Dialect() == sb.SQLite {
+		id -= int64(n) - 1
+	}
+	ids := make([]any, n)
+	for i := range ids {
+		ids[i] = id + int64(i)
+	}
+	return ids
+}
+
 // InsertOneTx insert rows into table, the data type should be structure.
 func InsertOne(ctx context.Context, table string, data any) (int64, error) {
 	return InsertOneTx(ctx, nil, table, data)
@@ -96,7 +118,11 @@ func InsertOneTx(ctx context.Context, tx *sqlx.Tx, table string, data any) (int6
 	if table == "" {
 		table = TableName(data)
 	}
-	ib, err := NewInsertBuilderFromStruct(ctx, table, data)
+	tables, _, err := shardRows(ctx, table, []any{data})
+	if err != nil {
+		return 0, err
+	}
+	ib, err := NewInsertBuilderFromStruct(ctx, tables[0], data)
 	if err != nil {
 		return 0, fmt.Errorf("create insert builder from structure error: %w", err)
 	}
@@ -114,6 +140,7 @@ func InsertOneTx(ctx context.Context, tx *sqlx.Tx, table string, data any) (int6
 	if err != nil {
 		return 0, fmt.Errorf("get last insert id: %w", err)
 	}
+	invalidateRows(ctx, table, id)
 	return id, nil
 }
 
@@ -129,6 +156,7 @@ func NewInsertBuilderFromStruct(ctx context.Context, table string, data ...any)
 	if table == "" {
 		table = TableName(data[0])
 	}
+	registerModel(table, data[0])
 
 	// 使用第一个数据的类型，获取列名信息。
 	var (
@@ -2,49 +2,258 @@ package ormx
 
 import (
 	"context"
-	"strings"
+	"database/sql"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/cloudfly/flagx"
+	"github.com/cloudfly/ormx/cache"
+	"github.com/cloudfly/ormx/sqlparse"
+)
+
+var (
+	cacheStatsInterval = flagx.NewDuration("database.cache.stats.interval", "30s", "the interval of emitting cache statistics to the MetricHandler implementing CacheMetricHandler")
+	poolStatsInterval  = flagx.NewDuration("database.pool.stats.interval", "30s", "the interval of emitting connection pool statistics to the MetricHandler implementing PoolMetricHandler")
 )
 
+// MetricHandler receives the table and whether the query is writing, it's called after the query executed.
+// Use Observer for the details of the query.
 type MetricHandler interface {
 	Emit(context.Context, string, bool)
 }
 
-var metricHandler MetricHandler
+// CacheMetricHandler is the optional interface of MetricHandler, it receives the cache statistics of each table periodically
+type CacheMetricHandler interface {
+	EmitCache(ctx context.Context, table string, stat cache.Stat)
+}
+
+// PoolMetricHandler is the optional interface of MetricHandler, it receives the connection pool statistics of each database periodically
+type PoolMetricHandler interface {
+	EmitPool(ctx context.Context, stat PoolStat)
+}
+
+// Operation is the kind of the query
+type Operation string
+
+const (
+	OpSelect Operation = "select"
+	OpInsert Operation = "insert"
+	OpUpdate Operation = "update"
+	OpDelete Operation = "delete"
+	OpTx     Operation = "tx"
+	OpOther  Operation = "other"
+)
+
+// IsWrite return true if the operation modifies data
+func (op Operation) IsWrite() bool {
+	return op == OpInsert || op == OpUpdate || op == OpDelete
+}
+
+// QueryEvent describes an executed query, or a finished transaction if Operation is OpTx
+type QueryEvent struct {
+	Operation Operation
+	Tables    []string
+	// DataSource is the name of data source the query routed to, empty for the default one
+	DataSource string
+	SQL        string
+	// Master is true if the query executed on master, the queries in transaction are always on master
+	Master bool
+	InTx   bool
+	// Rows is the rows affected by the writing, or the rows returned by the reading
+	Rows     int64
+	Duration time.Duration
+	Err      error
+	// ErrClass is the class of Err, empty if Err is nil
+	ErrClass string
+	// CacheHit is true if the result is served from cache without executing the query
+	CacheHit bool
+	// SharedLoad is true if the result is loaded by a concurrent call of the same cache key, the query is not executed by this call
+	SharedLoad bool
+}
+
+// Observer receives the event after each query executed
+type Observer interface {
+	Observe(ctx context.Context, event QueryEvent)
+}
+
+// ObserverFunc is the function adapter of Observer
+type ObserverFunc func(ctx context.Context, event QueryEvent)
+
+// Observe implements Observer
+func (f ObserverFunc) Observe(ctx context.Context, event QueryEvent) {
+	f(ctx, event)
+}
+
+// MetricHandlerObserver adapt the MetricHandler to Observer, Emit is called for each table of the query
+func MetricHandlerObserver(h MetricHandler) Observer {
+	return ObserverFunc(func(ctx context.Context, event QueryEvent) {
+		if event.Operation == OpTx || event.CacheHit || event.SharedLoad {
+			return
+		}
+		for _, table := range event.Tables {
+			h.Emit(ctx, table, event.Operation.IsWrite())
+		}
+	})
+}
+
+var (
+	observer      Observer
+	metricHandler MetricHandler
+
+	reporterLock  sync.Mutex
+	reporterStops []chan struct{}
+)
 
-func emitMetric(ctx context.Context, sql string) {
-	if metricHandler == nil {
+// SetObserver set the observer which receives the event of each query
+func SetObserver(o Observer) {
+	observer = o
+}
+
+// SetMetricHandler set the MetricHandler, it works along with the Observer set by SetObserver
+func SetMetricHandler(h MetricHandler) {
+	metricHandler = h
+}
+
+// observe emit the event to the Observer and MetricHandler, the operation and tables are parsed from event.SQL if missing
+func observe(ctx context.Context, event QueryEvent) {
+	if observer == nil && metricHandler == nil {
 		return
 	}
+	if event.Operation == "" {
+		event.Operation, event.Tables = statementInfo(event.SQL)
+	}
+	if event.Err != nil && event.ErrClass == "" {
+		event.ErrClass = errorClass(event.Err)
+	}
+	if observer != nil {
+		observer.Observe(ctx, event)
+	}
+	if metricHandler != nil {
+		MetricHandlerObserver(metricHandler).Observe(ctx, event)
+	}
+}
 
-	var (
-		table string
-		write bool
-	)
-
-	if i := strings.Index(sql, " FROM "); i > 0 {
-		write = false
-		sql = strings.TrimSpace(sql[i+6:])
-	} else if j := strings.Index(sql, " INTO "); j > 0 {
-		write = true
-		sql = strings.TrimSpace(sql[j+6:])
-	} else if k := strings.Index(sql, "UPDATE "); k >= 0 {
-		write = true
-		sql = strings.TrimSpace(sql[k+7:])
-	} else {
-		return
+// errorClass classify the error for metrics
+func errorClass(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, sql.ErrNoRows):
+		return "not_found"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, ErrQueryTimeout):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, ErrCircuitOpen):
+		return "circuit_open"
+	case errors.Is(err, ErrConcurrencyLimit):
+		return "throttled"
+	}
+	switch errorKind(err) {
+	case ErrDuplicateKey:
+		return "duplicate_key"
+	case ErrForeignKey:
+		return "foreign_key"
+	case ErrDeadlock:
+		return "deadlock"
+	case ErrLockTimeout:
+		return "lock_timeout"
+	case ErrDataTooLong:
+		return "data_too_long"
+	case ErrConnection:
+		return "connection"
+	case ErrReadOnly:
+		return "read_only"
 	}
+	return "other"
+}
+
+// statementInfo return the operation and the tables referenced by the sql
+func statementInfo(sql string) (Operation, []string) {
+	return statementInfoOf(sqlparse.Parse(sql))
+}
+
+func statementInfoOf(stmt sqlparse.Statement) (Operation, []string) {
+	switch stmt.Type {
+	case sqlparse.Select:
+		return OpSelect, stmt.TableNames()
+	case sqlparse.Insert, sqlparse.Replace:
+		return OpInsert, stmt.TableNames()
+	case sqlparse.Update:
+		return OpUpdate, stmt.TableNames()
+	case sqlparse.Delete:
+		return OpDelete, stmt.TableNames()
+	}
+	return OpOther, stmt.TableNames()
+}
+
+// resultRows return the count of rows in dest filled by Select
+func resultRows(dest any) int64 {
+	if v := dereferencedValue(reflect.ValueOf(dest)); v.IsValid() && v.Kind() == reflect.Slice {
+		return int64(v.Len())
+	}
+	return 1
+}
 
-	tableName, subSQL, ok := strings.Cut(sql, " ")
-	if ok {
-		table = tableName
-	} else {
-		emitMetric(ctx, subSQL)
+// startReporters emit the cache statistics every database.cache.stats.interval and the pool statistics every database.pool.stats.interval,
+// the previous reporters are stopped
+func startReporters(ctx context.Context) {
+	stopReporters()
+	startReporter(ctx, time.Duration(cacheStatsInterval.Msecs)*time.Millisecond, emitCacheStats)
+	startReporter(ctx, time.Duration(poolStatsInterval.Msecs)*time.Millisecond, emitPoolStats)
+}
+
+// startReporter call emit every interval until stopReporters, 0 disables it
+func startReporter(ctx context.Context, interval time.Duration, emit func(context.Context)) {
+	if interval <= 0 {
 		return
 	}
 
-	metricHandler.Emit(ctx, table, write)
+	reporterLock.Lock()
+	defer reporterLock.Unlock()
+	stop := make(chan struct{})
+	reporterStops = append(reporterStops, stop)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				emit(ctx)
+			}
+		}
+	}()
 }
 
-func SetMetricHandler(h MetricHandler) {
-	metricHandler = h
+func stopReporters() {
+	reporterLock.Lock()
+	defer reporterLock.Unlock()
+	for _, stop := range reporterStops {
+		close(stop)
+	}
+	reporterStops = nil
+}
+
+func emitCacheStats(ctx context.Context) {
+	h, ok := metricHandler.(CacheMetricHandler)
+	if !ok {
+		return
+	}
+	for table, stat := range cache.Stats() {
+		h.EmitCache(ctx, table, stat)
+	}
+}
+
+func emitPoolStats(ctx context.Context) {
+	h, ok := metricHandler.(PoolMetricHandler)
+	if !ok {
+		return
+	}
+	for _, stat := range PoolStats() {
+		h.EmitPool(ctx, stat)
+	}
 }
@@ -0,0 +1,51 @@
+package ormx
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cloudfly/ormx/cache"
+	"github.com/cloudfly/ormx/test"
+)
+
+type testMetricHandler struct {
+	cacheStats map[string]cache.Stat
+}
+
+func (h *testMetricHandler) Emit(context.Context, string, bool) {}
+
+func (h *testMetricHandler) EmitCache(_ context.Context, table string, stat cache.Stat) {
+	h.cacheStats[table] = stat
+}
+
+func TestEmitCacheStats(t *testing.T) {
+	h := &testMetricHandler{cacheStats: map[string]cache.Stat{}}
+	SetMetricHandler(h)
+	defer SetMetricHandler(nil)
+
+	cache.Set(time.Minute, "test_metric", 1, []byte("{}"))
+	cache.Get("test_metric", 1)
+	emitCacheStats(context.Background())
+	test.Equal(t, int64(1), h.cacheStats["test_metric"].Hits)
+	test.Equal(t, int64(1), h.cacheStats["test_metric"].Entries)
+}
+
+func TestStatementInfo(t *testing.T) {
+	cases := []struct {
+		sql    string
+		op     Operation
+		tables []string
+	}{
+		{"select * from `test` t join users u on t.uid = u.id", OpSelect, []string{"test", "users"}},
+		{"DELETE FROM test WHERE id IN (SELECT id FROM expired)", OpDelete, []string{"test", "expired"}},
+		{"INSERT INTO test (a) VALUES (?) ON DUPLICATE KEY UPDATE a = VALUES(a)", OpInsert, []string{"test"}},
+		{"UPDATE test SET a = ?", OpUpdate, []string{"test"}},
+		{"SHOW TABLES", OpOther, nil},
+	}
+	for _, c := range cases {
+		op, tables := statementInfo(c.sql)
+		test.Equal(t, c.op, op)
+		test.Equal(t, c.tables, tables)
+	}
+}
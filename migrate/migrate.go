@@ -0,0 +1,268 @@
+// Package migrate evolves the database schema by the versioned migrations, they run on the database provided to ormx.Init,
+// or the data source set by ormx.WithDataSource on the context, the routes of tables don't apply.
+//
+// The SQL migrations are loaded from fs.FS, such as embed.FS, named as <version>_<name>.up.sql and <version>_<name>.down.sql.
+// The statements of a script are split by semicolons, wrap the statement having semicolons inside, such as a stored procedure,
+// between the lines "-- +migrate StatementBegin" and "-- +migrate StatementEnd" to execute it as a whole.
+// The Go migrations are registered by Register. The applied versions are tracked in the table database.migrate.table.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cloudfly/flagx"
+	"github.com/cloudfly/ormx"
+	"github.com/cloudfly/ormx/sqlparse"
+	sb "github.com/huandu/go-sqlbuilder"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	migrateTable = flagx.NewString("database.migrate.table", "schema_migrations", "the table tracking the applied migrations, the database.table.prefix is prepended")
+	lockTimeout  = flagx.NewDuration("database.migrate.lock.timeout", "1m", "the maximum time waiting for the migration lock held by another process")
+
+	registryLock sync.Mutex
+	registry     = map[int64]*Migration{}
+)
+
+var (
+	// ErrIrreversible is returned when rolling back a migration having no down script
+	ErrIrreversible = errors.New("migration is irreversible")
+	// ErrLocked is returned when the migration lock is held by another process longer than database.migrate.lock.timeout
+	ErrLocked = errors.New("migration lock is held by another process")
+)
+
+// Func migrates the schema in the transaction.
+// Note that the DDL statements of MySQL commit the transaction implicitly, so they are not rolled back if the migration fails.
+type Func func(ctx context.Context, tx *sqlx.Tx) error
+
+// Migration is a versioned change of the schema, Down is nil if it's irreversible
+type Migration struct {
+	Version int64
+	Name    string
+	Up      Func
+	Down    Func
+}
+
+// Register the Go migration, it's loaded by the Migrator created after. It panics if the version is registered twice.
+func Register(version int64, name string, up, down Func) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if _, ok := registry[version]; ok {
+		panic(fmt.Sprintf("migrate: version %d is registered twice", version))
+	}
+	registry[version] = &Migration{Version: version, Name: name, Up: up, Down: down}
+}
+
+// Migrator applies and rolls back the migrations in the order of version.
+//
+// On MySQL and PostgreSQL, the migration lock holds a connection of master until finished, and each migration runs in a transaction
+// on another connection, so the master needs 2 connections at least, the migration blocks forever if database.conn.maxopen is 1.
+type Migrator struct {
+	migrations []*Migration
+}
+
+// New create the Migrator having the Go migrations registered and the SQL migrations in the root of fsys, fsys can be nil.
+// Use fs.Sub to load the SQL migrations in a sub directory.
+func New(fsys fs.FS) (*Migrator, error) {
+	migrations := map[int64]*Migration{}
+	registryLock.Lock()
+	for version, m := range registry {
+		copied := *m
+		migrations[version] = &copied
+	}
+	registryLock.Unlock()
+
+	if fsys != nil {
+		if err := loadSQL(fsys, migrations); err != nil {
+			return nil, err
+		}
+	}
+
+	m := &Migrator{}
+	for _, migration := range migrations {
+		if migration.Up == nil {
+			return nil, fmt.Errorf("migrate: version %d has no up script", migration.Version)
+		}
+		m.migrations = append(m.migrations, migration)
+	}
+	sort.Slice(m.migrations, func(i, j int) bool { return m.migrations[i].Version < m.migrations[j].Version })
+	return m, nil
+}
+
+// Migrations return the migrations loaded in the order of version
+func (m *Migrator) Migrations() []*Migration {
+	return m.migrations
+}
+
+// loadSQL load the SQL migrations in the root of fsys into migrations
+func loadSQL(fsys fs.FS, migrations map[int64]*Migration) error {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+		version, name, up, err := parseFileName(entry.Name())
+		if err != nil {
+			return err
+		}
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return err
+		}
+		m, ok := migrations[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			migrations[version] = m
+		} else if m.Name != name || (up && m.Up != nil) || (!up && m.Down != nil) {
+			return fmt.Errorf("migrate: version %d of %s conflicts with the migration %s", version, entry.Name(), m.Name)
+		}
+		if up {
+			m.Up = sqlFunc(string(content))
+		} else {
+			m.Down = sqlFunc(string(content))
+		}
+	}
+	return nil
+}
+
+// parseFileName parse <version>_<name>.up.sql or <version>_<name>.down.sql
+func parseFileName(file string) (version int64, name string, up bool, err error) {
+	base := strings.TrimSuffix(file, ".sql")
+	switch {
+	case strings.HasSuffix(base, ".up"):
+		base, up = strings.TrimSuffix(base, ".up"), true
+	case strings.HasSuffix(base, ".down"):
+		base = strings.TrimSuffix(base, ".down")
+	default:
+		return 0, "", false, fmt.Errorf("migrate: %s is not named as <version>_<name>.up.sql or <version>_<name>.down.sql", file)
+	}
+	v, name, _ := strings.Cut(base, "_")
+	if version, err = strconv.ParseInt(v, 10, 64); err != nil || version <= 0 {
+		return 0, "", false, fmt.Errorf("migrate: invalid version of %s", file)
+	}
+	return version, name, up, nil
+}
+
+// sqlFunc return the Func executing the statements of script one by one, the script is split by the dialect of ormx when running
+func sqlFunc(script string) Func {
+	return func(ctx context.Context, tx *sqlx.Tx) error {
+		statements, err := splitStatements(script, ormx.Dialect())
+		if err != nil {
+			return err
+		}
+		for _, stmt := range statements {
+			if _, err := ormx.ExecTx(ctx, tx, stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// the markers of the statement executed as a whole, the semicolons between them are not split
+const (
+	statementBegin = "-- +migrate StatementBegin"
+	statementEnd   = "-- +migrate StatementEnd"
+)
+
+// splitStatements split the script by semicolons, the ones in quotes, comments and dollar-quoted bodies of PostgreSQL are ignored.
+// The # starts a comment on MySQL only, and the lines between statementBegin and statementEnd are a single statement.
+func splitStatements(script string, dialect sb.Flavor) ([]string, error) {
+	var (
+		statements []string
+		start      = 0
+		lexical    = sqlparse.Standard
+	)
+	if dialect == sb.MySQL {
+		lexical = sqlparse.MySQL
+	}
+	add := func(stmt string) {
+		if stmt = strings.TrimSpace(stmt); len(sqlparse.TokenizeDialect(stmt, lexical)) > 0 {
+			statements = append(statements, stmt)
+		}
+	}
+	for i := 0; i < len(script); i++ {
+		switch c := script[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			for i++; i < len(script) && script[i] != c; i++ {
+				if script[i] == '\\' {
+					i++
+				}
+			}
+		case c == '-' && strings.HasPrefix(script[i:], "--"), c == '#' && dialect == sb.MySQL:
+			line := lineAt(script, i)
+			if strings.TrimSpace(line) == statementEnd {
+				return nil, fmt.Errorf("migrate: %q without %q", statementEnd, statementBegin)
+			}
+			if strings.TrimSpace(line) != statementBegin {
+				i += len(line) - 1
+				continue
+			}
+			add(script[start:i])
+			body := i + len(line) + 1
+			end := body
+			for end < len(script) && strings.TrimSpace(lineAt(script, end)) != statementEnd {
+				end += len(lineAt(script, end)) + 1
+			}
+			if end >= len(script) {
+				return nil, fmt.Errorf("migrate: %q without %q", statementBegin, statementEnd)
+			}
+			add(script[body:end])
+			i = end + len(lineAt(script, end))
+			start = i
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			if end := strings.Index(script[i+2:], "*/"); end >= 0 {
+				i += end + 3
+			} else {
+				i = len(script)
+			}
+		case c == '$':
+			if tag := dollarTag(script[i:]); tag != "" {
+				if end := strings.Index(script[i+len(tag):], tag); end >= 0 {
+					i += len(tag) + end + len(tag) - 1
+				} else {
+					i = len(script)
+				}
+			}
+		case c == ';':
+			add(script[start:i])
+			start = i + 1
+		}
+	}
+	add(script[start:])
+	return statements, nil
+}
+
+// lineAt return the rest of the line from i, the newline excluded
+func lineAt(script string, i int) string {
+	if end := strings.IndexByte(script[i:], '\n'); end >= 0 {
+		return script[i : i+end]
+	}
+	return script[i:]
+}
+
+// dollarTag return the tag like $$ or $body$ at the beginning of s, empty if not
+func dollarTag(s string) string {
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '$':
+			return s[:i+1]
+		case c == '_', 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', i > 1 && '0' <= c && c <= '9':
+		default:
+			return ""
+		}
+	}
+	return ""
+}
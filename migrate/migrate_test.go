@@ -0,0 +1,220 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/cloudfly/ormx"
+	"github.com/cloudfly/ormx/test"
+	sb "github.com/huandu/go-sqlbuilder"
+	"github.com/jmoiron/sqlx"
+)
+
+// useSQLite init ormx with a sqlite file, the tracking table is prefixed by app_
+func useSQLite(t *testing.T) *sqlx.DB {
+	db, err := sqlx.Connect("sqlite3", filepath.Join(t.TempDir(), "migrate.db"))
+	test.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	test.NoError(t, flag.Set("database.dialect", "sqlite3"))
+	ormx.SetTableNamePrefix("app_")
+	t.Cleanup(func() {
+		flag.Set("database.dialect", "")
+		ormx.SetTableNamePrefix("")
+	})
+	test.NoError(t, ormx.Init(context.Background(), func(bool) *sqlx.DB { return db }))
+	return db
+}
+
+var migrations = fstest.MapFS{
+	"1_create_users.up.sql": {Data: []byte(`
+-- the users; and their names
+CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL DEFAULT 'a;b');
+CREATE TABLE profiles (user_id INTEGER PRIMARY KEY);
+`)},
+	"1_create_users.down.sql": {Data: []byte("DROP TABLE profiles; DROP TABLE users;")},
+	"2_index_users.up.sql":    {Data: []byte("CREATE UNIQUE INDEX uk_name ON users (name)")},
+	"README.md":               {Data: []byte("not a migration")},
+}
+
+func TestMigrate(t *testing.T) {
+	db := useSQLite(t)
+	ctx := context.Background()
+	Register(3, "seed_users", func(ctx context.Context, tx *sqlx.Tx) error {
+		_, err := ormx.ExecTx(ctx, tx, "INSERT INTO users (id, name) VALUES (1, 'admin')")
+		return err
+	}, func(ctx context.Context, tx *sqlx.Tx) error {
+		_, err := ormx.ExecTx(ctx, tx, "DELETE FROM users WHERE id = 1")
+		return err
+	})
+	t.Cleanup(func() { delete(registry, 3) })
+
+	m, err := New(migrations)
+	test.NoError(t, err)
+	test.Equal(t, 3, len(m.Migrations()))
+	statuses, err := m.Status(ctx)
+	test.NoError(t, err)
+	test.Equal(t, []Status{{Version: 1, Name: "create_users"}, {Version: 2, Name: "index_users"}, {Version: 3, Name: "seed_users"}}, statuses)
+
+	test.NoError(t, m.Up(ctx))
+	var n int
+	test.NoError(t, db.Get(&n, "SELECT COUNT(*) FROM users"))
+	test.Equal(t, 1, n)
+	test.NoError(t, db.Get(&n, "SELECT COUNT(*) FROM app_schema_migrations"))
+	test.Equal(t, 3, n)
+	// applying again does nothing
+	test.NoError(t, m.Up(ctx))
+
+	test.NoError(t, m.Down(ctx))
+	test.NoError(t, db.Get(&n, "SELECT COUNT(*) FROM users"))
+	test.Equal(t, 0, n)
+	statuses, err = m.Status(ctx)
+	test.NoError(t, err)
+	test.Equal(t, []bool{true, true, false}, []bool{statuses[0].Applied, statuses[1].Applied, statuses[2].Applied})
+
+	// the migration 2 has no down script
+	err = m.To(ctx, 1)
+	test.Equal(t, true, errors.Is(err, ErrIrreversible))
+
+	// the applied migration not loaded is reported
+	m, err = New(nil)
+	test.NoError(t, err)
+	statuses, err = m.Status(ctx)
+	test.NoError(t, err)
+	test.Equal(t, 3, len(statuses))
+	test.Equal(t, Status{Version: 1, Name: "create_users", Applied: true, Missing: true}, Status{Version: statuses[0].Version, Name: statuses[0].Name, Applied: statuses[0].Applied, Missing: statuses[0].Missing})
+	test.Equal(t, true, m.Down(ctx) != nil)
+}
+
+func TestMigrateTo(t *testing.T) {
+	db := useSQLite(t)
+	ctx := context.Background()
+	m, err := New(fstest.MapFS{
+		"1_create_users.up.sql":   migrations["1_create_users.up.sql"],
+		"1_create_users.down.sql": migrations["1_create_users.down.sql"],
+		"2_add_email.up.sql":      {Data: []byte("ALTER TABLE users ADD COLUMN email TEXT")},
+		"2_add_email.down.sql":    {Data: []byte("ALTER TABLE users DROP COLUMN email")},
+	})
+	test.NoError(t, err)
+
+	test.NoError(t, m.To(ctx, 1))
+	_, err = db.Exec("INSERT INTO users (id, name, email) VALUES (1, 'a', 'a@example.com')")
+	test.Equal(t, true, err != nil)
+	test.NoError(t, m.To(ctx, 2))
+	_, err = db.Exec("INSERT INTO users (id, name, email) VALUES (1, 'a', 'a@example.com')")
+	test.NoError(t, err)
+
+	test.NoError(t, m.To(ctx, 0))
+	_, err = db.Exec("SELECT 1 FROM users")
+	test.Equal(t, true, err != nil)
+}
+
+func TestMigrateDataSource(t *testing.T) {
+	db := useSQLite(t)
+	ctx := context.Background()
+	// the tracking table stays with the migrations on the default data source
+	ormx.RouteTable("app_schema", "missing")
+	t.Cleanup(func() { ormx.RouteTable("app_schema", "") })
+
+	m, err := New(migrations)
+	test.NoError(t, err)
+	test.NoError(t, m.Up(ctx))
+	statuses, err := m.Status(ctx)
+	test.NoError(t, err)
+	test.Equal(t, 2, len(statuses))
+	var n int
+	test.NoError(t, db.Get(&n, "SELECT COUNT(*) FROM app_schema_migrations"))
+	test.Equal(t, 2, n)
+}
+
+func TestNewErrors(t *testing.T) {
+	for _, fsys := range []fstest.MapFS{
+		{"create_users.up.sql": {}},
+		{"1_create_users.sql": {}},
+		{"1_create_users.down.sql": {}},
+		{"1_create_users.up.sql": {}, "1_users.down.sql": {}},
+	} {
+		_, err := New(fsys)
+		test.Equal(t, true, err != nil)
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	statements, err := splitStatements(`
+INSERT INTO t VALUES ('a;b', "c;d", `+"`e;f`"+`); -- comment; here
+/* x; */ UPDATE t SET a = 'it\'s;';
+CREATE FUNCTION f() RETURNS int AS $body$ SELECT 1; $body$ LANGUAGE sql;
+SELECT $$;$$;
+-- trailing comment
+`, sb.PostgreSQL)
+	test.NoError(t, err)
+	test.Equal(t, []string{
+		"INSERT INTO t VALUES ('a;b', \"c;d\", `e;f`)",
+		"-- comment; here\n/* x; */ UPDATE t SET a = 'it\\'s;'",
+		"CREATE FUNCTION f() RETURNS int AS $body$ SELECT 1; $body$ LANGUAGE sql",
+		"SELECT $$;$$",
+	}, statements)
+
+	// # starts a comment on MySQL only
+	statements, err = splitStatements("# comment; here\nSELECT 1", sb.MySQL)
+	test.NoError(t, err)
+	test.Equal(t, []string{"# comment; here\nSELECT 1"}, statements)
+	statements, err = splitStatements("SELECT '{}'::jsonb #> '{a;b}'; SELECT 2", sb.PostgreSQL)
+	test.NoError(t, err)
+	test.Equal(t, []string{"SELECT '{}'::jsonb #> '{a;b}'", "SELECT 2"}, statements)
+	statements, err = splitStatements("SELECT 1 # 2; SELECT 3", sb.PostgreSQL)
+	test.NoError(t, err)
+	test.Equal(t, []string{"SELECT 1 # 2", "SELECT 3"}, statements)
+
+	// the statement between the markers is not split
+	statements, err = splitStatements(`
+CREATE TABLE t (id INT);
+-- +migrate StatementBegin
+CREATE PROCEDURE p()
+BEGIN
+  DELETE FROM t;
+  INSERT INTO t VALUES (1);
+END;
+-- +migrate StatementEnd
+DROP TABLE t;
+`, sb.MySQL)
+	test.NoError(t, err)
+	test.Equal(t, []string{
+		"CREATE TABLE t (id INT)",
+		"CREATE PROCEDURE p()\nBEGIN\n  DELETE FROM t;\n  INSERT INTO t VALUES (1);\nEND;",
+		"DROP TABLE t",
+	}, statements)
+
+	for _, script := range []string{
+		"-- +migrate StatementBegin\nSELECT 1;",
+		"SELECT 1;\n-- +migrate StatementEnd",
+	} {
+		_, err = splitStatements(script, sb.MySQL)
+		test.Equal(t, true, err != nil)
+	}
+}
+
+func TestMigrateStatementBlock(t *testing.T) {
+	db := useSQLite(t)
+	ctx := context.Background()
+	m, err := New(fstest.MapFS{
+		"1_create_users.up.sql": migrations["1_create_users.up.sql"],
+		"2_create_trigger.up.sql": {Data: []byte(`
+-- +migrate StatementBegin
+CREATE TRIGGER users_profile AFTER INSERT ON users
+BEGIN
+  INSERT INTO profiles (user_id) VALUES (new.id);
+END;
+-- +migrate StatementEnd
+INSERT INTO users (id, name) VALUES (1, 'admin');
+`)},
+	})
+	test.NoError(t, err)
+	test.NoError(t, m.Up(ctx))
+	var n int
+	test.NoError(t, db.Get(&n, "SELECT COUNT(*) FROM profiles"))
+	test.Equal(t, 1, n)
+}
@@ -0,0 +1,252 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/cloudfly/ormx"
+	sb "github.com/huandu/go-sqlbuilder"
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog"
+)
+
+// Status is the state of a migration
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	// Missing is true if the migration is applied but not loaded by the Migrator
+	Missing bool
+}
+
+type appliedRow struct {
+	Version   int64  `db:"version"`
+	Name      string `db:"name"`
+	AppliedAt int64  `db:"applied_at"`
+}
+
+// Up apply all the pending migrations in the order of version
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.To(ctx, math.MaxInt64)
+}
+
+// Down roll back the latest applied migration
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.run(ctx, func(ctx context.Context, applied []appliedRow) error {
+		if len(applied) == 0 {
+			return nil
+		}
+		return m.rollback(ctx, applied[len(applied)-1])
+	})
+}
+
+// To migrate the schema to version, the pending migrations up to version are applied in the order of version,
+// and the applied ones after version are rolled back in the reverse order. To(ctx, 0) rolls back all the migrations.
+func (m *Migrator) To(ctx context.Context, version int64) error {
+	return m.run(ctx, func(ctx context.Context, applied []appliedRow) error {
+		done := make(map[int64]bool, len(applied))
+		for i := len(applied) - 1; i >= 0; i-- {
+			if applied[i].Version <= version {
+				done[applied[i].Version] = true
+				continue
+			}
+			if err := m.rollback(ctx, applied[i]); err != nil {
+				return err
+			}
+		}
+		for _, migration := range m.migrations {
+			if migration.Version > version {
+				break
+			}
+			if done[migration.Version] {
+				continue
+			}
+			if err := apply(ctx, migration); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Status return the state of the migrations loaded and the ones applied, in the order of version
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	ctx = pinned(ormx.WithQueryTimeout(ctx, 0))
+	if err := createTable(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make(map[int64]*Status, len(m.migrations))
+	for _, migration := range m.migrations {
+		statuses[migration.Version] = &Status{Version: migration.Version, Name: migration.Name}
+	}
+	for _, row := range applied {
+		s, ok := statuses[row.Version]
+		if !ok {
+			s = &Status{Version: row.Version, Name: row.Name, Missing: true}
+			statuses[row.Version] = s
+		}
+		s.Applied, s.AppliedAt = true, time.Unix(row.AppliedAt, 0)
+	}
+	list := make([]Status, 0, len(statuses))
+	for _, s := range statuses {
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// run call f with the applied migrations while holding the migration lock.
+// The migrations run without the default timeouts of ormx unless ctx has a deadline.
+func (m *Migrator) run(ctx context.Context, f func(ctx context.Context, applied []appliedRow) error) error {
+	ctx = pinned(ormx.WithQueryTimeout(ctx, 0))
+	return withLock(ctx, func(ctx context.Context) error {
+		if err := createTable(ctx); err != nil {
+			return err
+		}
+		applied, err := appliedMigrations(ctx)
+		if err != nil {
+			return err
+		}
+		return f(ctx, applied)
+	})
+}
+
+// pinned route all the queries with ctx to the data source of ctx, the default one if not set,
+// so that the lock, the tracking table and the migrations are on the same database whatever the routes of tables
+func pinned(ctx context.Context) context.Context {
+	name, _ := ormx.DataSourceFrom(ctx)
+	return ormx.WithDataSource(ctx, name)
+}
+
+// find return the migration loaded of version, nil if not found
+func (m *Migrator) find(version int64) *Migration {
+	i := sort.Search(len(m.migrations), func(i int) bool { return m.migrations[i].Version >= version })
+	if i < len(m.migrations) && m.migrations[i].Version == version {
+		return m.migrations[i]
+	}
+	return nil
+}
+
+// apply the migration and record it in the same transaction
+func apply(ctx context.Context, migration *Migration) error {
+	start := time.Now()
+	err := ormx.RunTxContext(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+		if err := migration.Up(ctx, tx); err != nil {
+			return err
+		}
+		_, err := ormx.ExecTx(ctx, tx, tx.Rebind("INSERT INTO "+table()+" (version, name, applied_at) VALUES (?, ?, ?)"), migration.Version, migration.Name, time.Now().Unix())
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("migrate: apply %d_%s: %w", migration.Version, migration.Name, err)
+	}
+	zerolog.Ctx(ctx).Info().Int64("version", migration.Version).Str("name", migration.Name).Dur("duration", time.Since(start)).Msg("Migration applied")
+	return nil
+}
+
+// rollback the applied migration and remove its record in the same transaction
+func (m *Migrator) rollback(ctx context.Context, row appliedRow) error {
+	migration := m.find(row.Version)
+	if migration == nil {
+		return fmt.Errorf("migrate: version %d_%s is applied but not loaded", row.Version, row.Name)
+	}
+	if migration.Down == nil {
+		return fmt.Errorf("migrate: rollback %d_%s: %w", migration.Version, migration.Name, ErrIrreversible)
+	}
+	start := time.Now()
+	err := ormx.RunTxContext(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+		if err := migration.Down(ctx, tx); err != nil {
+			return err
+		}
+		_, err := ormx.ExecTx(ctx, tx, tx.Rebind("DELETE FROM "+table()+" WHERE version = ?"), migration.Version)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("migrate: rollback %d_%s: %w", migration.Version, migration.Name, err)
+	}
+	zerolog.Ctx(ctx).Info().Int64("version", migration.Version).Str("name", migration.Name).Dur("duration", time.Since(start)).Msg("Migration rolled back")
+	return nil
+}
+
+// table return the quoted name of the table tracking the applied migrations
+func table() string {
+	return ormx.Dialect().Quote(tableName())
+}
+
+func tableName() string {
+	return ormx.TableNamePrefix() + *migrateTable
+}
+
+func createTable(ctx context.Context) error {
+	_, err := ormx.Exec(ctx, "CREATE TABLE IF NOT EXISTS "+table()+" (version BIGINT PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at BIGINT NOT NULL)")
+	return err
+}
+
+// appliedMigrations return the applied migrations in the order of version, they are read from master
+func appliedMigrations(ctx context.Context) ([]appliedRow, error) {
+	var rows []appliedRow
+	err := ormx.Select(ormx.FromMaster(ctx), &rows, "SELECT version, name, applied_at FROM "+table()+" ORDER BY version")
+	return rows, err
+}
+
+// withLock call f while holding the advisory lock of the tracking table, so that the replicas of application don't migrate concurrently.
+// The lock is held by a transaction on the master, GET_LOCK is used on MySQL and pg_try_advisory_xact_lock on PostgreSQL.
+// Other dialects like SQLite are not locked.
+//
+// The lock transaction holds a connection while f runs, and the queries of f run on the other connections.
+func withLock(ctx context.Context, f func(ctx context.Context) error) error {
+	var (
+		name    = tableName()
+		timeout = time.Duration(lockTimeout.Msecs) * time.Millisecond
+	)
+	switch ormx.Dialect() {
+	case sb.MySQL:
+		return ormx.RunTxContext(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+			// the lock of MySQL is server wide, so it's named by the database
+			var locked sql.NullInt64
+			if err := ormx.GetTx(ctx, tx, &locked, "SELECT GET_LOCK(CONCAT(COALESCE(DATABASE(), ''), '.', ?), ?)", name, math.Ceil(timeout.Seconds())); err != nil {
+				return err
+			}
+			if locked.Int64 != 1 {
+				return ErrLocked
+			}
+			defer ormx.ExecTx(context.WithoutCancel(ctx), tx, "DO RELEASE_LOCK(CONCAT(COALESCE(DATABASE(), ''), '.', ?))", name)
+			return f(ctx)
+		})
+	case sb.PostgreSQL:
+		hash := fnv.New64a()
+		hash.Write([]byte(name))
+		key := int64(hash.Sum64())
+		return ormx.RunTxContext(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+			deadline := time.Now().Add(timeout)
+			for {
+				var locked bool
+				if err := ormx.GetTx(ctx, tx, &locked, "SELECT pg_try_advisory_xact_lock($1)", key); err != nil {
+					return err
+				}
+				if locked {
+					return f(ctx)
+				}
+				if time.Now().After(deadline) {
+					return ErrLocked
+				}
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(time.Second):
+				}
+			}
+		})
+	}
+	return f(ctx)
+}
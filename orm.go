@@ -13,6 +13,7 @@ var (
 	structTagName       = "db"
 	namespaceColumnName = flagx.NewString("database.table.namespace.column", "namespace", "the column name used to represent row's namespace")
 	primaryKey          = flagx.NewString("database.table.primarykey", "id", "the primary id column name")
+	dialect             = flagx.NewString("database.dialect", "", "the sql dialect used for quoting identifiers, such as mysql, sqlite3, postgres; use database.driver if empty")
 )
 
 // Init the ormx, setting the sqlx.DB getter and common table name prefix
@@ -23,7 +24,22 @@ func Init(ctx context.Context, provider DBProvider) error {
 	if provider != nil {
 		p = provider
 	}
-	return cache.Init()
+	if err := cache.Init(); err != nil {
+		return err
+	}
+	startReporters(context.WithoutCancel(ctx))
+	warnNoRedaction(ctx)
+	return nil
+}
+
+// SetTableNamePrefix set the common prefix of table names, it's prepended by TableName
+func SetTableNamePrefix(prefix string) {
+	*tableNamePrefix = prefix
+}
+
+// TableNamePrefix return the common prefix of table names
+func TableNamePrefix() string {
+	return *tableNamePrefix
 }
 
 // SetStructTagName set the tag name in Go Struct Tag, in which specify the ormx options, default is 'db'
@@ -51,6 +67,11 @@ func isFromMaster(ctx context.Context) bool {
 	return fmt.Sprintf("%v", ctx.Value(masterCtxKey{})) == "true"
 }
 
+// isFromSlave return true if ctx is created by FromSlave
+func isFromSlave(ctx context.Context) bool {
+	return fmt.Sprintf("%v", ctx.Value(masterCtxKey{})) == "false"
+}
+
 // FromMaster force ormx execute sql on master instance when called by this context
 func FromMaster(ctx context.Context) context.Context {
 	return context.WithValue(ctx, masterCtxKey{}, "true")
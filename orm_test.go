@@ -34,6 +34,9 @@ type TestRowPatch struct {
 }
 
 func TestSimple(t *testing.T) {
+	if err := test.Available(); err != nil {
+		t.Skipf("mysql server is not available: %s", err)
+	}
 
 	var (
 		ctx = context.Background()
@@ -0,0 +1,170 @@
+package ormx
+
+import (
+	"context"
+	"math/rand/v2"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/cloudfly/flagx"
+	"github.com/cloudfly/ormx/sqlparse"
+	"github.com/rs/zerolog"
+)
+
+var (
+	logLevels     = flagx.NewString("database.log.levels", "select:debug,insert:info,update:info,delete:info,tx:info,other:info", "the log level of the queries per operation, in format op:level,op:level")
+	logSample     = flagx.NewFloat("database.log.sample", 1, "the ratio of the queries logged, 0 disables the query log and 1 logs all the queries")
+	redactColumns = flagx.NewArrayString("database.log.redact.columns", "the columns whose values are redacted in the query log, in addition to the struct fields having the sensitive option")
+
+	logLevelLock     sync.RWMutex
+	logLevelOverride = map[Operation]zerolog.Level{}
+
+	sensitiveTypes   sync.Map // sensitiveKey => struct{}
+	sensitiveColumns sync.Map // table => *sync.Map of column => struct{}
+)
+
+// the placeholder of the redacted arg
+const redacted = "<redacted>"
+
+// SetQueryLogLevel set the log level of the queries of the operation, it overrides the flag
+func SetQueryLogLevel(op Operation, level zerolog.Level) {
+	logLevelLock.Lock()
+	defer logLevelLock.Unlock()
+	logLevelOverride[op] = level
+}
+
+// queryLogLevel return the log level of the operation, info if not configured
+func queryLogLevel(op Operation) zerolog.Level {
+	logLevelLock.RLock()
+	level, ok := logLevelOverride[op]
+	logLevelLock.RUnlock()
+	if ok {
+		return level
+	}
+	if s, ok := ParseOptionStr(*logLevels)[string(op)]; ok {
+		if level, err := zerolog.ParseLevel(s); err == nil {
+			return level
+		}
+	}
+	return zerolog.InfoLevel
+}
+
+// ctxLogger return the logger in ctx, or the logger set by SetLogger if ctx has none
+func ctxLogger(ctx context.Context) *zerolog.Logger {
+	if l := zerolog.Ctx(ctx); l.GetLevel() != zerolog.Disabled {
+		return l
+	}
+	return &log
+}
+
+// logQuery log the query before executing, the sensitive args are redacted
+func logQuery(ctx context.Context, q *queryRun) {
+	if sample := *logSample; sample <= 0 || sample < 1 && rand.Float64() >= sample {
+		return
+	}
+	event := ctxLogger(ctx).WithLevel(queryLogLevel(q.op))
+	if event == nil {
+		return
+	}
+	event.Str("query", q.sql).Any("args", redactArgs(q.sql, q.tables, q.args)).Bool("master", q.master).Bool("tx", q.inTx).Msg("Executing sql query")
+}
+
+// RegisterSensitive register the fields having the sensitive option in struct tag of the models, such as `db:"password,insert,sensitive"`,
+// the values of these columns are redacted in the query log. The models used by the Insert, Update and Select functions are registered automatically.
+//
+// Only the registered models are known to the redaction, the sensitive option of a model not registered yet takes no effect,
+// such as on the sql executed by Exec before any function used the model. Register the models on startup, or list the columns by database.log.redact.columns.
+func RegisterSensitive(models ...any) {
+	for _, model := range models {
+		registerSensitive("", model)
+	}
+}
+
+type sensitiveKey struct {
+	table string
+	t     reflect.Type
+}
+
+// registerSensitive register the sensitive fields of data to the table, the table is resolved from data if empty
+func registerSensitive(table string, data any) {
+	if data == nil {
+		return
+	}
+	t := dereferencedElemType(reflect.TypeOf(data))
+	if t.Kind() != reflect.Struct {
+		return
+	}
+	if table == "" {
+		table = TableName(data)
+	}
+	key := sensitiveKey{table: table, t: t}
+	if _, ok := sensitiveTypes.Load(key); ok {
+		return
+	}
+	columns, _ := sensitiveColumns.LoadOrStore(table, &sync.Map{})
+	for i := 0; i < t.NumField(); i++ {
+		name, after := colNameFromTag(t.Field(i))
+		if name == "" {
+			continue
+		}
+		if _, ok := ParseOptionStr(after)["sensitive"]; ok {
+			columns.(*sync.Map).Store(strings.ToLower(name), struct{}{})
+		}
+	}
+	sensitiveTypes.Store(key, struct{}{})
+}
+
+// warnNoRedaction warn that the query log is not redacted if no sensitive column is configured or registered
+func warnNoRedaction(ctx context.Context) {
+	if *logSample <= 0 || len(*redactColumns) > 0 {
+		return
+	}
+	registered := false
+	sensitiveTypes.Range(func(_, _ any) bool {
+		registered = true
+		return false
+	})
+	if !registered {
+		ctxLogger(ctx).Warn().Msg("No sensitive column is set by database.log.redact.columns or registered by RegisterSensitive, the args in query log are not redacted until the models are used")
+	}
+}
+
+// isSensitive return true if the column of any table is sensitive
+func isSensitive(tables []string, column string) bool {
+	if column == "" {
+		return false
+	}
+	for _, c := range *redactColumns {
+		if strings.EqualFold(c, column) {
+			return true
+		}
+	}
+	for _, table := range tables {
+		if columns, ok := sensitiveColumns.Load(table); ok {
+			if _, ok := columns.(*sync.Map).Load(column); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// redactArgs replace the args bound to the sensitive columns of the tables by <redacted>
+func redactArgs(sql string, tables []string, args []any) []any {
+	if len(args) == 0 {
+		return args
+	}
+	var (
+		columns = sqlparse.ArgColumns(sql)
+		out     = make([]any, len(args))
+	)
+	for i, arg := range args {
+		if i < len(columns) && isSensitive(tables, columns[i]) {
+			out[i] = redacted
+		} else {
+			out[i] = arg
+		}
+	}
+	return out
+}
@@ -0,0 +1,130 @@
+package ormx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/cloudfly/ormx/test"
+	"github.com/rs/zerolog"
+)
+
+type testSecretRow struct {
+	ID       int64  `db:"id"`
+	Producer string `db:"producer,insert"`
+	Resource string `db:"resource,insert"`
+	Action   string `db:"action,insert,sensitive"`
+	Message  string `db:"message,insert"`
+}
+
+func (testSecretRow) Table() string {
+	return "test"
+}
+
+func parseLogs(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	var entries []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		entry := map[string]any{}
+		test.NoError(t, json.Unmarshal([]byte(line), &entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestQueryLogRedaction(t *testing.T) {
+	useSQLite(t)
+	var (
+		ctx = context.Background()
+		buf = captureLog(t)
+	)
+	SetQueryLogLevel(OpSelect, zerolog.InfoLevel)
+	t.Cleanup(func() {
+		logLevelLock.Lock()
+		defer logLevelLock.Unlock()
+		delete(logLevelOverride, OpSelect)
+	})
+
+	_, err := InsertOne(ctx, "", testSecretRow{Producer: "unittest", Resource: "redact", Action: "s3cr3t"})
+	test.NoError(t, err)
+	var rows []TestRow
+	test.NoError(t, Select(ctx, &rows, "SELECT * FROM test WHERE resource = ? AND action = ?", "redact", "s3cr3t"))
+
+	test.Equal(t, false, strings.Contains(buf.String(), "s3cr3t"))
+	entries := parseLogs(t, buf)
+	test.Equal(t, 2, len(entries))
+	test.Equal(t, "info", entries[0]["level"])
+	test.Equal(t, []any{"unittest", "redact", redacted, ""}, entries[0]["args"])
+	test.Equal(t, []any{"redact", redacted}, entries[1]["args"])
+}
+
+func TestQueryLogLevelAndSample(t *testing.T) {
+	useSQLite(t)
+	var (
+		ctx  = context.Background()
+		buf  = captureLog(t)
+		rows []TestRow
+	)
+	SetLogger(zerolog.New(buf).Level(zerolog.InfoLevel))
+
+	// select is logged at debug level by default
+	test.NoError(t, Select(ctx, &rows, "SELECT * FROM test"))
+	test.Equal(t, 0, buf.Len())
+
+	_, err := Exec(ctx, "DELETE FROM test WHERE id = ?", 1)
+	test.NoError(t, err)
+	test.Equal(t, "info", parseLogs(t, buf)[0]["level"])
+
+	prev := *logSample
+	*logSample = 0
+	t.Cleanup(func() { *logSample = prev })
+	buf.Reset()
+	_, err = Exec(ctx, "DELETE FROM test WHERE id = ?", 1)
+	test.NoError(t, err)
+	test.Equal(t, 0, buf.Len())
+}
+
+func TestQueryLogContextLogger(t *testing.T) {
+	useSQLite(t)
+	var (
+		global = captureLog(t)
+		local  = &bytes.Buffer{}
+		ctx    = zerolog.New(local).WithContext(context.Background())
+	)
+	_, err := Exec(ctx, "DELETE FROM test WHERE id = ?", 1)
+	test.NoError(t, err)
+	test.Equal(t, 0, global.Len())
+	test.Equal(t, 1, len(parseLogs(t, local)))
+}
+
+func TestWarnNoRedaction(t *testing.T) {
+	buf := captureLog(t)
+	var registered []any
+	sensitiveTypes.Range(func(key, _ any) bool {
+		registered = append(registered, key)
+		sensitiveTypes.Delete(key)
+		return true
+	})
+	t.Cleanup(func() {
+		for _, key := range registered {
+			sensitiveTypes.Store(key, struct{}{})
+		}
+	})
+
+	warnNoRedaction(context.Background())
+	test.Equal(t, true, strings.Contains(buf.String(), "not redacted"))
+
+	buf.Reset()
+	*redactColumns = []string{"password"}
+	warnNoRedaction(context.Background())
+	*redactColumns = nil
+	test.Equal(t, 0, buf.Len())
+
+	RegisterSensitive(TestRow{})
+	warnNoRedaction(context.Background())
+	test.Equal(t, 0, buf.Len())
+}
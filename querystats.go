@@ -0,0 +1,186 @@
+package ormx
+
+import (
+	"cmp"
+	"container/heap"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudfly/flagx"
+)
+
+var (
+	queryStatsSize = flagx.NewInt("database.querystats.size", 0, "the maximum number of query fingerprints tracked by QueryStats, the one with the least total time is replaced by the new one when full, 0 disables it")
+
+	queryStatsLock sync.Mutex
+	queryStatsMap  = map[string]*queryStat{}
+	queryStatsHeap queryStatHeap
+)
+
+// the number of latest durations kept for computing the percentiles
+const latencySamples = 512
+
+// QueryStat is the statistics of the queries having the same fingerprint
+type QueryStat struct {
+	Fingerprint string    `json:"fingerprint"`
+	Query       string    `json:"query"`
+	Operation   Operation `json:"operation"`
+	Tables      []string  `json:"tables"`
+	Count       int64     `json:"count"`
+	Errors      int64     `json:"errors"`
+	Rows        int64     `json:"rows"`
+	// Total is the total time spent by the queries
+	Total time.Duration `json:"total"`
+	// Inherited is the total time inherited from the evicted fingerprint, Total overestimates the time by at most it
+	Inherited time.Duration `json:"inherited"`
+	Max       time.Duration `json:"max"`
+	// P50 and P99 are computed from the latest 512 queries
+	P50      time.Duration `json:"p50"`
+	P99      time.Duration `json:"p99"`
+	LastSeen time.Time     `json:"last_seen"`
+}
+
+type queryStat struct {
+	QueryStat
+	samples []time.Duration
+	next    int
+	index   int // the index in queryStatsHeap
+}
+
+func (s *queryStat) add(duration time.Duration, rows int64, err error) {
+	s.Count++
+	s.Rows += rows
+	s.Total += duration
+	s.Max = max(s.Max, duration)
+	s.LastSeen = time.Now()
+	if err != nil && !IsNotFound(err) {
+		s.Errors++
+	}
+	if len(s.samples) < latencySamples {
+		s.samples = append(s.samples, duration)
+	} else {
+		s.samples[s.next] = duration
+		s.next = (s.next + 1) % latencySamples
+	}
+}
+
+func (s *queryStat) snapshot() QueryStat {
+	stat := s.QueryStat
+	sorted := slices.Clone(s.samples)
+	slices.Sort(sorted)
+	stat.P50 = percentile(sorted, 0.5)
+	stat.P99 = percentile(sorted, 0.99)
+	return stat
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(float64(len(sorted)-1)*p+0.5)]
+}
+
+// recordQueryStat add the executed query into the statistics of its fingerprint
+func recordQueryStat(q *queryRun, duration time.Duration, rows int64, err error) {
+	size := *queryStatsSize
+	if size <= 0 {
+		return
+	}
+	fingerprint := q.fingerprint()
+
+	queryStatsLock.Lock()
+	defer queryStatsLock.Unlock()
+	s, ok := queryStatsMap[fingerprint]
+	if !ok {
+		s = &queryStat{QueryStat: QueryStat{
+			Fingerprint: fingerprint,
+			Query:       q.normalize(),
+			Operation:   q.op,
+			Tables:      q.tables,
+		}}
+		if len(queryStatsMap) >= size {
+			replaceQueryStat(s)
+		} else {
+			heap.Push(&queryStatsHeap, s)
+		}
+		queryStatsMap[fingerprint] = s
+	}
+	s.add(duration, rows, err)
+	heap.Fix(&queryStatsHeap, s.index)
+}
+
+// replaceQueryStat replace the fingerprint with the least total time by s, s inherits its total time like the space-saving algorithm,
+// so the new fingerprint is not evicted immediately by the next one, and a frequent fingerprint climbs up to be kept eventually.
+func replaceQueryStat(s *queryStat) {
+	victim := queryStatsHeap[0]
+	delete(queryStatsMap, victim.Fingerprint)
+	s.Total, s.Inherited = victim.Total, victim.Total
+	s.index = 0
+	queryStatsHeap[0] = s
+}
+
+// queryStatHeap is the min-heap of queryStat ordered by total time
+type queryStatHeap []*queryStat
+
+func (h queryStatHeap) Len() int           { return len(h) }
+func (h queryStatHeap) Less(i, j int) bool { return h[i].Total < h[j].Total }
+
+func (h queryStatHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *queryStatHeap) Push(x any) {
+	s := x.(*queryStat)
+	s.index = len(*h)
+	*h = append(*h, s)
+}
+
+func (h *queryStatHeap) Pop() any {
+	old := *h
+	s := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return s
+}
+
+// QueryStats return the statistics of the queries executed by ormx group by fingerprint, sorted by total time in descending order.
+// The queries served from cache are not counted. It's empty unless the flag database.querystats.size is set.
+func QueryStats() []QueryStat {
+	queryStatsLock.Lock()
+	stats := make([]QueryStat, 0, len(queryStatsMap))
+	for _, s := range queryStatsMap {
+		stats = append(stats, s.snapshot())
+	}
+	queryStatsLock.Unlock()
+
+	slices.SortFunc(stats, func(a, b QueryStat) int {
+		return cmp.Or(cmp.Compare(b.Total, a.Total), cmp.Compare(b.Count, a.Count))
+	})
+	return stats
+}
+
+// ResetQueryStats clear the statistics of all the queries
+func ResetQueryStats() {
+	queryStatsLock.Lock()
+	defer queryStatsLock.Unlock()
+	queryStatsMap = map[string]*queryStat{}
+	queryStatsHeap = nil
+}
+
+// QueryStatsHandler return the http.Handler serving QueryStats in json, the query parameter n limits the number of fingerprints.
+func QueryStatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stats := QueryStats()
+		if n, err := strconv.Atoi(r.URL.Query().Get("n")); err == nil && n >= 0 && n < len(stats) {
+			stats = stats[:n]
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(stats); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
@@ -0,0 +1,107 @@
+package ormx
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cloudfly/ormx/test"
+)
+
+func TestQueryStats(t *testing.T) {
+	useSQLite(t)
+	ResetQueryStats()
+	prev := *queryStatsSize
+	*queryStatsSize = 500
+	t.Cleanup(func() {
+		*queryStatsSize = prev
+		ResetQueryStats()
+	})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		_, err := Exec(ctx, "INSERT INTO test (producer, resource, action, message) VALUES (?, ?, ?, '')", "unittest", "stats", "test")
+		test.NoError(t, err)
+	}
+	var rows []TestRow
+	test.NoError(t, Select(ctx, &rows, "SELECT * FROM test WHERE id IN (1, 2)"))
+	test.NoError(t, Select(ctx, &rows, "select * from test where id in (?, ?, ?)", 1, 2, 3))
+	test.Equal(t, true, Select(ctx, &rows, "SELECT * FROM missing WHERE id = 1") != nil)
+
+	stats := QueryStats()
+	test.Equal(t, 3, len(stats))
+	byQuery := make(map[string]QueryStat)
+	for _, s := range stats {
+		byQuery[s.Query] = s
+	}
+
+	insert := byQuery["INSERT INTO test (producer, resource, action, message) VALUES (?, ?, ?, ?)"]
+	test.Equal(t, int64(3), insert.Count)
+	test.Equal(t, int64(3), insert.Rows)
+	test.Equal(t, OpInsert, insert.Operation)
+	test.Equal(t, true, insert.P50 > 0 && insert.P50 <= insert.P99 && insert.P99 <= insert.Max)
+
+	sel := byQuery["SELECT * FROM test WHERE id IN (...)"]
+	test.Equal(t, int64(2), sel.Count)
+	test.Equal(t, int64(5), sel.Rows)
+	test.Equal(t, []string{"test"}, sel.Tables)
+
+	missing := byQuery["SELECT * FROM missing WHERE id = ?"]
+	test.Equal(t, int64(1), missing.Errors)
+
+	for i := 1; i < len(stats); i++ {
+		test.Equal(t, true, stats[i-1].Total >= stats[i].Total)
+	}
+
+	w := httptest.NewRecorder()
+	QueryStatsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/debug/ormx/queries?n=2", nil))
+	test.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	var served []QueryStat
+	test.NoError(t, json.Unmarshal(w.Body.Bytes(), &served))
+	test.Equal(t, 2, len(served))
+	test.Equal(t, stats[0].Fingerprint, served[0].Fingerprint)
+}
+
+func TestQueryStatsEviction(t *testing.T) {
+	ResetQueryStats()
+	prev := *queryStatsSize
+	*queryStatsSize = 2
+	t.Cleanup(func() {
+		*queryStatsSize = prev
+		ResetQueryStats()
+	})
+
+	record := func(sql string, duration time.Duration) {
+		q, err := newQuery(context.Background(), sql, nil)
+		test.NoError(t, err)
+		defer q.source.release()
+		recordQueryStat(q, duration, 0, nil)
+	}
+	record("SELECT * FROM a", 3*time.Millisecond)
+	record("SELECT * FROM b", time.Millisecond)
+	record("SELECT * FROM c", 4*time.Millisecond)
+
+	// c replaces b and inherits its total time
+	stats := QueryStats()
+	test.Equal(t, 2, len(stats))
+	test.Equal(t, "SELECT * FROM c", stats[0].Query)
+	test.Equal(t, 5*time.Millisecond, stats[0].Total)
+	test.Equal(t, time.Millisecond, stats[0].Inherited)
+	test.Equal(t, "SELECT * FROM a", stats[1].Query)
+
+	// the newcomer is kept after replacing the least one, a is replaced by d although d is faster than it
+	record("SELECT * FROM d", time.Millisecond)
+	stats = QueryStats()
+	test.Equal(t, 2, len(stats))
+	test.Equal(t, "SELECT * FROM c", stats[0].Query)
+	test.Equal(t, "SELECT * FROM d", stats[1].Query)
+	test.Equal(t, 4*time.Millisecond, stats[1].Total)
+
+	// disabled by default
+	*queryStatsSize = 0
+	ResetQueryStats()
+	record("SELECT * FROM a", time.Millisecond)
+	test.Equal(t, 0, len(QueryStats()))
+}
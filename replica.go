@@ -0,0 +1,390 @@
+package ormx
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfly/flagx"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	replicaBalance        = flagx.NewString("database.dsn.read.balance", BalanceRoundRobin, "the strategy balancing the reads across replicas: round-robin, least-conn or weighted")
+	replicaWeights        = flagx.NewArrayInt("database.dsn.read.weights", "the weights of the replicas in the order of database.dsn.read, used by the weighted balance; the missing weight is 1")
+	replicaCheckInterval  = flagx.NewDuration("database.dsn.read.check.interval", "5s", "the interval of pinging the replicas, the unhealthy replica is ejected until the ping succeeds; 0 disables the health check")
+	replicaCheckTimeout   = flagx.NewDuration("database.dsn.read.check.timeout", "1s", "the timeout of pinging a replica")
+	replicaCheckThreshold = flagx.NewInt("database.dsn.read.check.failures", 1, "the number of consecutive failed pings before ejecting a replica")
+	replicaLagMethod      = flagx.NewString("database.dsn.read.lag.method", "", "the method measuring the replica lag along with the health check: replica-status uses SHOW REPLICA STATUS, heartbeat uses a heartbeat table written on master; empty disables it")
+	replicaLagTable       = flagx.NewString("database.dsn.read.lag.table", "ormx_heartbeat", "the heartbeat table created on master for the heartbeat lag method, the table name prefix is applied")
+	replicaMaxStaleness   = flagx.NewDuration("database.dsn.read.maxstaleness", "0", "the default maximum lag of the replica serving reads, the replica lagging more or having unknown lag is skipped; 0 means unlimited")
+)
+
+// the methods of measuring the replica lag
+const (
+	LagReplicaStatus = "replica-status"
+	LagHeartbeat     = "heartbeat"
+)
+
+// ReplicaMetricHandler is the optional interface of MetricHandler, it receives the state of each replica after each health check
+type ReplicaMetricHandler interface {
+	EmitReplica(ctx context.Context, stat ReplicaStat)
+}
+
+type maxStalenessCtxKey struct{}
+
+// WithMaxStaleness set the maximum lag of the replica serving the reads with ctx, it overrides the flag.
+// The reads go to master if no replica is fresh enough, a non-positive staleness always reads from master like FromMaster.
+func WithMaxStaleness(ctx context.Context, staleness time.Duration) context.Context {
+	return context.WithValue(ctx, maxStalenessCtxKey{}, staleness)
+}
+
+// maxStaleness return the maximum lag of replica allowed by ctx, 0 if unlimited
+func maxStaleness(ctx context.Context) (time.Duration, bool) {
+	if staleness, ok := ctx.Value(maxStalenessCtxKey{}).(time.Duration); ok {
+		return staleness, true
+	}
+	return time.Duration(replicaMaxStaleness.Msecs) * time.Millisecond, false
+}
+
+// the strategies of balancing reads across replicas
+const (
+	BalanceRoundRobin = "round-robin"
+	BalanceLeastConn  = "least-conn"
+	BalanceWeighted   = "weighted"
+)
+
+// ReplicaStat is the state of a replica
+type ReplicaStat struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Weight  int    `json:"weight"`
+	// Lag is the replication lag measured, -1 if unknown
+	Lag  time.Duration `json:"lag"`
+	Pool sql.DBStats   `json:"pool"`
+}
+
+type replica struct {
+	name     string
+	db       *sqlx.DB
+	weight   int
+	healthy  atomic.Bool
+	failures int
+	// lag is the replication lag in nanoseconds, -1 if unknown
+	lag atomic.Int64
+	// current is the current weight of smooth weighted round-robin
+	current int
+}
+
+// replicaSet balance the reads across the healthy replicas
+type replicaSet struct {
+	replicas []*replica
+	balance  string
+	next     atomic.Uint64
+
+	// master and lagMethod are used by lag measurement, lastBeat is the latest heartbeat written in unix nanoseconds,
+	// it's 0 if the latest heartbeat failed, so the lag is unknown instead of measured against a stale heartbeat
+	master    *sqlx.DB
+	lagMethod string
+	lastBeat  int64
+
+	lock sync.Mutex // guards the current weights, failures and lastBeat
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newReplicaSet create the replicaSet of dbs, all the replicas are healthy initially
+func newReplicaSet(balance string, names []string, dbs []*sqlx.DB, weights []int) *replicaSet {
+	rs := &replicaSet{balance: balance}
+	for i, db := range dbs {
+		r := &replica{name: names[i], db: db, weight: 1}
+		if i < len(weights) && weights[i] > 0 {
+			r.weight = weights[i]
+		}
+		r.healthy.Store(true)
+		r.lag.Store(-1)
+		rs.replicas = append(rs.replicas, r)
+	}
+	return rs
+}
+
+// pick return the db of a healthy replica lagging no more than staleness by the balance strategy, nil if none is available.
+// The lag is not checked if staleness is 0.
+func (rs *replicaSet) pick(staleness time.Duration) *sqlx.DB {
+	switch rs.balance {
+	case BalanceLeastConn:
+		var (
+			best  *replica
+			inUse int
+		)
+		for _, r := range rs.replicas {
+			if !r.available(staleness) {
+				continue
+			}
+			if n := r.db.Stats().InUse; best == nil || n < inUse {
+				best, inUse = r, n
+			}
+		}
+		if best == nil {
+			return nil
+		}
+		return best.db
+	case BalanceWeighted:
+		rs.lock.Lock()
+		defer rs.lock.Unlock()
+		var (
+			best  *replica
+			total int
+		)
+		for _, r := range rs.replicas {
+			if !r.available(staleness) {
+				continue
+			}
+			r.current += r.weight
+			total += r.weight
+			if best == nil || r.current > best.current {
+				best = r
+			}
+		}
+		if best == nil {
+			return nil
+		}
+		best.current -= total
+		return best.db
+	}
+
+	// round-robin, the replicas are iterated twice instead of collecting the available ones, so that picking allocates nothing
+	n := 0
+	for _, r := range rs.replicas {
+		if r.available(staleness) {
+			n++
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+	k := int((rs.next.Add(1) - 1) % uint64(n))
+	for _, r := range rs.replicas {
+		if !r.available(staleness) {
+			continue
+		}
+		if k == 0 {
+			return r.db
+		}
+		k--
+	}
+	// the health changed between the iterations
+	return nil
+}
+
+// available return true if the replica is healthy and lagging no more than staleness, the lag is not checked if staleness is 0
+func (r *replica) available(staleness time.Duration) bool {
+	if !r.healthy.Load() {
+		return false
+	}
+	lag := r.lag.Load()
+	return staleness <= 0 || (lag >= 0 && time.Duration(lag) <= staleness)
+}
+
+// check ping all the replicas, eject the replica failed for threshold times and recover the replica succeeded.
+// The lag of the healthy replicas is measured if lagMethod set.
+func (rs *replicaSet) check(ctx context.Context, timeout time.Duration, threshold int) {
+	if rs.lagMethod == LagHeartbeat {
+		rs.beat(ctx, timeout)
+	}
+	var wg sync.WaitGroup
+	for _, r := range rs.replicas {
+		wg.Add(1)
+		go func(r *replica) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			err := r.db.PingContext(ctx)
+			if err == nil {
+				rs.measureLag(ctx, r)
+			}
+
+			rs.lock.Lock()
+			defer rs.lock.Unlock()
+			if err == nil {
+				r.failures = 0
+				if !r.healthy.Swap(true) {
+					log.Info().Str("replica", r.name).Msg("Replica recovered")
+				}
+				return
+			}
+			r.failures++
+			r.lag.Store(-1)
+			if r.failures >= threshold && r.healthy.Swap(false) {
+				log.Warn().Err(err).Str("replica", r.name).Msg("Replica ejected")
+			}
+		}(r)
+	}
+	wg.Wait()
+
+	if h, ok := metricHandler.(ReplicaMetricHandler); ok {
+		for _, stat := range rs.stats() {
+			h.EmitReplica(ctx, stat)
+		}
+	}
+}
+
+// heartbeatTable return the name of heartbeat table
+func heartbeatTable() string {
+	return *tableNamePrefix + *replicaLagTable
+}
+
+// beat write the current time into the heartbeat table on master, the table is created if not exists.
+// The lastBeat is reset if failed.
+func (rs *replicaSet) beat(ctx context.Context, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var (
+		now   = time.Now().UnixNano()
+		table = Dialect().Quote(heartbeatTable())
+	)
+	r, err := rs.master.ExecContext(ctx, "UPDATE "+table+" SET ts = ? WHERE id = 1", now)
+	if err != nil {
+		_, err = rs.master.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+table+" (id INT PRIMARY KEY, ts BIGINT NOT NULL)")
+	}
+	if err == nil && rowsAffected(r, err) == 0 {
+		_, err = rs.master.ExecContext(ctx, "INSERT INTO "+table+" (id, ts) VALUES (1, ?)", now)
+	}
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to write the replica heartbeat")
+		now = 0
+	}
+	rs.lock.Lock()
+	rs.lastBeat = now
+	rs.lock.Unlock()
+}
+
+// measureLag measure the lag of replica by the lagMethod, the lag is unknown if failed
+func (rs *replicaSet) measureLag(ctx context.Context, r *replica) {
+	var (
+		lag = time.Duration(-1)
+		err error
+	)
+	switch rs.lagMethod {
+	case LagHeartbeat:
+		// the lag is the time elapsed since the latest heartbeat the replica has, rather than compared with lastBeat,
+		// so it keeps growing while the replica stops, and it's right when the heartbeat is written by the other processes too.
+		// It's accurate to the check interval, because the replica may miss the heartbeat written just now.
+		var ts int64
+		if err = r.db.GetContext(ctx, &ts, "SELECT ts FROM "+Dialect().Quote(heartbeatTable())+" WHERE id = 1"); err == nil {
+			now := time.Now().UnixNano()
+			rs.lock.Lock()
+			if rs.lastBeat > 0 {
+				lag = max(time.Duration(now-ts), 0)
+			}
+			rs.lock.Unlock()
+		}
+	case LagReplicaStatus:
+		lag, err = replicaStatusLag(ctx, r.db)
+	default:
+		return
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("replica", r.name).Msg("Failed to measure the replica lag")
+	}
+	r.lag.Store(int64(lag))
+}
+
+// replicaStatusLag return the Seconds_Behind_Source of SHOW REPLICA STATUS, -1 if replication is not running
+func replicaStatusLag(ctx context.Context, db *sqlx.DB) (time.Duration, error) {
+	rows, err := db.QueryxContext(ctx, "SHOW REPLICA STATUS")
+	if err != nil {
+		// the MySQL before 8.0.22 only supports SHOW SLAVE STATUS
+		if rows, err = db.QueryxContext(ctx, "SHOW SLAVE STATUS"); err != nil {
+			return -1, err
+		}
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return -1, rows.Err()
+	}
+	status := make(map[string]any)
+	if err := rows.MapScan(status); err != nil {
+		return -1, err
+	}
+	for _, column := range []string{"Seconds_Behind_Source", "Seconds_Behind_Master"} {
+		v, ok := status[column]
+		if !ok {
+			continue
+		}
+		if b, ok := v.([]byte); ok {
+			v = string(b)
+		}
+		if seconds, err := strconv.ParseInt(fmt.Sprint(v), 10, 64); err == nil {
+			return time.Duration(seconds) * time.Second, nil
+		}
+		// NULL means the replication is not running
+		return -1, nil
+	}
+	return -1, fmt.Errorf("no Seconds_Behind_Source in replica status")
+}
+
+// startCheck ping the replicas periodically until close.
+// The replicas are unhealthy until the first check, which runs immediately, so the reads go to master before the replicas are reachable.
+func (rs *replicaSet) startCheck(interval, timeout time.Duration, threshold int) {
+	if interval <= 0 {
+		return
+	}
+	for _, r := range rs.replicas {
+		r.healthy.Store(false)
+	}
+	rs.stop, rs.done = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(rs.done)
+		rs.check(context.Background(), timeout, threshold)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-rs.stop:
+				return
+			case <-ticker.C:
+				rs.check(context.Background(), timeout, threshold)
+			}
+		}
+	}()
+}
+
+// stats return the state of each replica
+func (rs *replicaSet) stats() []ReplicaStat {
+	stats := make([]ReplicaStat, 0, len(rs.replicas))
+	for _, r := range rs.replicas {
+		stats = append(stats, ReplicaStat{Name: r.name, Healthy: r.healthy.Load(), Weight: r.weight, Lag: time.Duration(r.lag.Load()), Pool: r.db.Stats()})
+	}
+	return stats
+}
+
+// close stop the health check and close all the replicas
+func (rs *replicaSet) close() error {
+	if rs.stop != nil {
+		close(rs.stop)
+		<-rs.done
+	}
+	var err error
+	for _, r := range rs.replicas {
+		if cerr := r.db.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// ReplicaStats return the health and connection pool statistics of each replica connected by Connect
+func ReplicaStats() []ReplicaStat {
+	connLock.RLock()
+	rs := replicas
+	connLock.RUnlock()
+	if rs == nil {
+		return nil
+	}
+	return rs.stats()
+}
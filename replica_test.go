@@ -0,0 +1,212 @@
+package ormx
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudfly/ormx/test"
+	"github.com/jmoiron/sqlx"
+)
+
+func testReplicaSet(t *testing.T, balance string, weights ...int) (*replicaSet, []*sqlx.DB) {
+	dbs := []*sqlx.DB{test.SQLite(t), test.SQLite(t), test.SQLite(t)}
+	return newReplicaSet(balance, []string{"r0", "r1", "r2"}, dbs, weights), dbs
+}
+
+func pickCounts(rs *replicaSet, dbs []*sqlx.DB, n int, staleness time.Duration) []int {
+	counts := make([]int, len(dbs))
+	for i := 0; i < n; i++ {
+		picked := rs.pick(staleness)
+		for j, db := range dbs {
+			if picked == db {
+				counts[j]++
+			}
+		}
+	}
+	return counts
+}
+
+func TestReplicaRoundRobin(t *testing.T) {
+	rs, dbs := testReplicaSet(t, BalanceRoundRobin)
+	test.Equal(t, dbs[0], rs.pick(0))
+	test.Equal(t, dbs[1], rs.pick(0))
+	test.Equal(t, dbs[2], rs.pick(0))
+	test.Equal(t, dbs[0], rs.pick(0))
+
+	// the unhealthy replica is skipped, and picking allocates nothing
+	rs.replicas[1].healthy.Store(false)
+	test.Equal(t, []int{2, 0, 2}, pickCounts(rs, dbs, 4, 0))
+	test.Equal(t, float64(0), testing.AllocsPerRun(100, func() { rs.pick(0) }))
+}
+
+func TestReplicaWeighted(t *testing.T) {
+	rs, dbs := testReplicaSet(t, BalanceWeighted, 3, 1)
+	test.Equal(t, []int{60, 20, 20}, pickCounts(rs, dbs, 100, 0))
+}
+
+func TestReplicaLeastConn(t *testing.T) {
+	rs, dbs := testReplicaSet(t, BalanceLeastConn)
+	ctx := context.Background()
+	for _, i := range []int{0, 2} {
+		conn, err := dbs[i].Connx(ctx)
+		test.NoError(t, err)
+		defer conn.Close()
+	}
+	test.Equal(t, dbs[1], rs.pick(0))
+}
+
+func TestReplicaHealthCheck(t *testing.T) {
+	rs, dbs := testReplicaSet(t, BalanceRoundRobin)
+	ctx := context.Background()
+
+	dbs[1].Close()
+	rs.check(ctx, time.Second, 2)
+	test.Equal(t, true, rs.stats()[1].Healthy)
+	rs.check(ctx, time.Second, 2)
+	test.Equal(t, false, rs.stats()[1].Healthy)
+	test.Equal(t, []int{5, 0, 5}, pickCounts(rs, dbs, 10, 0))
+
+	dbs[0].Close()
+	dbs[2].Close()
+	rs.check(ctx, time.Second, 1)
+	test.Equal(t, (*sqlx.DB)(nil), rs.pick(0))
+}
+
+func TestConnectReplicas(t *testing.T) {
+	var (
+		dir     = t.TempDir()
+		ctx     = context.Background()
+		master  = fmt.Sprintf("file:%s?cache=shared", filepath.Join(dir, "master.db"))
+		replica = []string{
+			fmt.Sprintf("file:%s", filepath.Join(dir, "r1.db")),
+			fmt.Sprintf("file:%s", filepath.Join(dir, "r2.db")),
+			// the unreachable replica doesn't fail Connect
+			fmt.Sprintf("file:%s?mode=ro", filepath.Join(dir, "missing", "r3.db")),
+		}
+		prevDsn, prevRead, prevDriver = *databaseDsn, *databaseDsnRead, *dbDriver
+	)
+	*databaseDsn, *databaseDsnRead, *dbDriver = master, replica, "sqlite3"
+	t.Cleanup(func() {
+		*databaseDsn, *databaseDsnRead, *dbDriver = prevDsn, prevRead, prevDriver
+		db = nil
+	})
+
+	test.NoError(t, Connect(ctx))
+	// the replicas are unhealthy until the first health check, which runs in background
+	var stats []ReplicaStat
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if stats = ReplicaStats(); stats[0].Healthy && stats[1].Healthy {
+			break
+		}
+	}
+	test.Equal(t, 3, len(stats))
+	test.Equal(t, "file:"+filepath.Join(dir, "r1.db"), stats[0].Name)
+	test.Equal(t, true, stats[0].Healthy)
+	test.Equal(t, true, stats[1].Healthy)
+	test.Equal(t, false, stats[2].Healthy)
+	test.Equal(t, true, DefaultProvider(false) != db)
+	test.Equal(t, db, DefaultProvider(true))
+
+	// fallback to master if all the replicas are down
+	for _, r := range replicas.replicas {
+		r.healthy.Store(false)
+	}
+	test.Equal(t, db, DefaultProvider(false))
+
+	test.NoError(t, Close())
+	test.Equal(t, 0, len(ReplicaStats()))
+}
+
+func TestDSNName(t *testing.T) {
+	test.Equal(t, "tcp(127.0.0.1:3306)/db", dsnName("root:p@ss@tcp(127.0.0.1:3306)/db?parseTime=true"))
+	test.Equal(t, "file:test.db", dsnName("file:test.db?mode=ro"))
+}
+
+func TestReplicaLagHeartbeat(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		rs, dbs = testReplicaSet(t, BalanceRoundRobin)
+		master  = test.SQLite(t)
+		emitted = map[string]time.Duration{}
+	)
+	rs.master, rs.lagMethod = master, LagHeartbeat
+	SetMetricHandler(replicaMetricHandler(func(_ context.Context, stat ReplicaStat) { emitted[stat.Name] = stat.Lag }))
+	t.Cleanup(func() { SetMetricHandler(nil) })
+
+	// the replicas have no heartbeat table, the lag is unknown
+	rs.check(ctx, time.Second, 1)
+	test.Equal(t, time.Duration(-1), rs.stats()[0].Lag)
+	test.Equal(t, time.Duration(-1), emitted["r0"])
+	test.Equal(t, true, rs.stats()[0].Healthy)
+
+	// simulate the replication: r0 is up to date, r1 lags 10 seconds, r2 stops replicating
+	var beat int64
+	test.NoError(t, master.Get(&beat, "SELECT ts FROM ormx_heartbeat WHERE id = 1"))
+	rs.lastBeat = beat
+	beat = time.Now().UnixNano()
+	for i, lag := range []time.Duration{0, 10 * time.Second} {
+		_, err := dbs[i].Exec("CREATE TABLE ormx_heartbeat (id INT PRIMARY KEY, ts BIGINT NOT NULL)")
+		test.NoError(t, err)
+		_, err = dbs[i].Exec("INSERT INTO ormx_heartbeat (id, ts) VALUES (1, ?)", beat-int64(lag))
+		test.NoError(t, err)
+	}
+	for _, r := range rs.replicas {
+		rs.measureLag(ctx, r)
+	}
+	stats := rs.stats()
+	test.Equal(t, true, stats[0].Lag >= 0 && stats[0].Lag < time.Second)
+	test.Equal(t, true, stats[1].Lag >= 10*time.Second && stats[1].Lag < 11*time.Second)
+	test.Equal(t, time.Duration(-1), stats[2].Lag)
+
+	test.Equal(t, []int{5, 5, 0}, pickCounts(rs, dbs, 10, time.Minute))
+	test.Equal(t, []int{10, 0, 0}, pickCounts(rs, dbs, 10, time.Second))
+	// the lag is ignored without staleness
+	for _, count := range pickCounts(rs, dbs, 9, 0) {
+		test.Equal(t, 3, count)
+	}
+
+	// the lag is unknown after the heartbeat failed, instead of measured against the stale heartbeat
+	test.NoError(t, master.Close())
+	rs.beat(ctx, time.Second)
+	rs.measureLag(ctx, rs.replicas[0])
+	test.Equal(t, time.Duration(-1), rs.stats()[0].Lag)
+}
+
+func TestWithMaxStaleness(t *testing.T) {
+	master, slave := useMasterSlave(t)
+	var (
+		ctx  = context.Background()
+		r0   = test.SQLite(t)
+		prev = replicas
+	)
+	replicas = newReplicaSet(BalanceRoundRobin, []string{"r0"}, []*sqlx.DB{r0}, nil)
+	t.Cleanup(func() { replicas = prev })
+
+	db, isMaster := readDB(ctx, defaultSource())
+	test.Equal(t, slave, db)
+	test.Equal(t, false, isMaster)
+
+	db, isMaster = readDB(WithMaxStaleness(ctx, 0), defaultSource())
+	test.Equal(t, master, db)
+	test.Equal(t, true, isMaster)
+
+	// the lag of r0 is unknown
+	db, _ = readDB(WithMaxStaleness(ctx, time.Second), defaultSource())
+	test.Equal(t, master, db)
+
+	replicas.replicas[0].lag.Store(int64(500 * time.Millisecond))
+	db, isMaster = readDB(WithMaxStaleness(ctx, time.Second), defaultSource())
+	test.Equal(t, r0, db)
+	test.Equal(t, false, isMaster)
+}
+
+type replicaMetricHandler func(ctx context.Context, stat ReplicaStat)
+
+func (h replicaMetricHandler) Emit(context.Context, string, bool) {}
+
+func (h replicaMetricHandler) EmitReplica(ctx context.Context, stat ReplicaStat) {
+	h(ctx, stat)
+}
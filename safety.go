@@ -0,0 +1,82 @@
+package ormx
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+type fullTableCtxKey struct{}
+
+// AllowFullTable allow the update and delete helpers to write the whole table(or the whole namespace) without where condition when called by this context
+func AllowFullTable(ctx context.Context) context.Context {
+	return context.WithValue(ctx, fullTableCtxKey{}, true)
+}
+
+func isFullTableAllowed(ctx context.Context) bool {
+	allowed, _ := ctx.Value(fullTableCtxKey{}).(bool)
+	return allowed
+}
+
+// checkWriteFilter return ErrUnsafeWrite if the filter generates no where condition, or the namespace column is the only condition
+func checkWriteFilter(ctx context.Context, table string, filter any) error {
+	if isFullTableAllowed(ctx) {
+		return nil
+	}
+	for _, col := range filterColumns(filter) {
+		if col != *namespaceColumnName {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: writing table '%s' without filter, use AllowFullTable if it's intended", ErrUnsafeWrite, table)
+}
+
+// filterColumns return the column names which the filter will generate where conditions on, it follows the rules of WhereFrom,
+// the ones with unknown op generate no condition so they are not returned
+func filterColumns(filter any) []string {
+	if filter == nil {
+		return nil
+	}
+	if kvs, ok := filter.(KVs); ok {
+		cols := make([]string, 0, len(kvs))
+		for _, kv := range kvs {
+			if isWhereOp(kv.Extra) {
+				cols = append(cols, kv.Key)
+			}
+		}
+		return cols
+	}
+
+	v := dereferencedValue(reflect.ValueOf(filter))
+	if !v.IsValid() {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		if v.IsZero() {
+			return nil
+		}
+		var (
+			t    = v.Type()
+			cols []string
+		)
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Field(i)
+			switch field.Kind() {
+			case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+				if field.IsNil() {
+					continue
+				}
+			}
+			if name, _ := colNameFromTag(t.Field(i)); name != "" && isWhereOp(t.Field(i).Tag.Get("op")) {
+				cols = append(cols, name)
+			}
+		}
+		return cols
+	case reflect.Slice:
+		if v.Len() == 0 {
+			return nil
+		}
+	}
+	return []string{*primaryKey}
+}
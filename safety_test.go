@@ -0,0 +1,53 @@
+package ormx
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cloudfly/ormx/test"
+)
+
+func TestCheckWriteFilter(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		action = "patch"
+	)
+
+	for _, filter := range []any{
+		nil,
+		KVs{},
+		KVs{{Key: "namespace", Value: "default"}},
+		KVs{{Key: "id", Value: 1, Extra: "bogus"}},
+		TestRowPatch{},
+		&TestRowPatch{},
+		[]int64{},
+	} {
+		err := checkWriteFilter(ctx, "test", filter)
+		test.Equal(t, true, errors.Is(err, ErrUnsafeWrite))
+		test.NoError(t, checkWriteFilter(AllowFullTable(ctx), "test", filter))
+	}
+
+	for _, filter := range []any{
+		KVs{{Key: "namespace", Value: "default"}, {Key: "action", Value: "test"}},
+		TestRowPatch{Action: &action},
+		[]int64{1, 2},
+		int64(1),
+	} {
+		test.NoError(t, checkWriteFilter(ctx, "test", filter))
+	}
+}
+
+func TestUnsafeWrite(t *testing.T) {
+	ctx := WithNamespace(context.Background(), "default")
+
+	err := DeleteWhere(ctx, "test", nil)
+	test.Equal(t, true, errors.Is(err, ErrUnsafeWrite))
+
+	err = DeleteByID(ctx, "test")
+	test.Equal(t, true, errors.Is(err, ErrUnsafeWrite))
+
+	action := "patch"
+	_, err = PatchWhere(ctx, "test", TestRowPatch{Action: &action}, KVs{})
+	test.Equal(t, true, errors.Is(err, ErrUnsafeWrite))
+}
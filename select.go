@@ -3,106 +3,162 @@ package ormx
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"reflect"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/cloudfly/ormx/cache"
 	sb "github.com/huandu/go-sqlbuilder"
-	"github.com/rs/zerolog"
 )
 
+// GetByID get the row by id from table into dst.
+//
+// The row is cached for the ttl set by flag database.cache.ttl or SetCacheTTL, and the cache is skipped when ctx is created by FromMaster.
+// The not found is also cached for database.cache.negative.ttl if it's set.
+// The row cached in the local cache is copied into dst, the slices and maps of dst are shared by the calls, so don't modify them.
+// The row cached in a remote backend is encoded by json, so the fields ignored by json are empty when it's served from cache.
+// The writes in transaction invalidate the cached row after commit only if the transaction is created by RunTxContext.
 func GetByID(ctx context.Context, dst interface{}, table string, id int64) error {
 	if table == "" {
 		table = TableName(dst)
 	}
 
-	if !isFromMaster(ctx) {
-		// Not reading data from the primary database indicates that some delay is tolerable.
-		// Attempt to read from the local cache.
-		if v, ok := cache.Get(table, id); ok {
-			if content, ok := v.([]byte); ok {
-				if err := json.Unmarshal(content, dst); err == nil {
-					return nil
-				} else {
-					// Deserialization error indicates that the data is unusable. Delete it directly.
-					cache.Remove(table, id)
-				}
-			}
-		}
-	}
-
-	b, err := NewSelectBuilderFromStruct(table, dst)
-	if err != nil {
-		return fmt.Errorf("create select builder error:%w", err)
+	ttl := cacheTTLOf(table)
+	if ttl <= 0 || isFromMaster(ctx) {
+		return getByID(ctx, dst, table, id)
 	}
-	b = b.Where(WhereFrom(&b.Cond, id, nil)...)
 
+	// Not reading data from the primary database indicates that some delay is tolerable.
+	// Attempt to read from the cache.
 	var (
-		statement string
-		args      []any
+		cacheKey = rowCacheKey(ctx, table, id, dst)
+		rowType  = dereferencedType(reflect.TypeOf(dst))
+		row      = reflect.New(rowType)
+		start    = time.Now()
+		loaded   atomic.Bool
 	)
-	statement, args = Build(ctx, b)
-
-	if err := Get(ctx, dst, statement, args...); err != nil {
-		return err
+	hit, err := cache.LoadInto(row.Interface(), rowCacheOptions(ttl), func() (any, error) {
+		// the load may run in background for refreshing the stale row, so it can not use dst and the cancelable ctx
+		loaded.Store(true)
+		row := reflect.New(rowType)
+		if err := getByID(context.WithoutCancel(ctx), row.Interface(), table, id); err != nil {
+			return nil, err
+		}
+		return row.Elem().Interface(), nil
+	}, cacheKey...)
+	if !loaded.Load() {
+		// the row is served from cache, or loaded by a concurrent call
+		observe(ctx, QueryEvent{Operation: OpSelect, Tables: []string{table}, Rows: gotRows(err), Duration: time.Since(start), Err: err, CacheHit: hit, SharedLoad: !hit})
 	}
-	content, err := json.Marshal(dst)
 	if err != nil {
-		zerolog.Ctx(ctx).Warn().Err(err).Str("query", statement).Any("args", args).Msg("Failed to marshal data for cacheing")
-		// 忽略序列化错误，顶多就是无法cache，无关紧要
+		return err
+	}
+	if v := dereferencedValue(reflect.ValueOf(dst)); v.CanSet() {
+		v.Set(row.Elem())
 		return nil
 	}
-	cache.Set(time.Second*10, table, id, content)
-	return nil
+	return getByID(ctx, dst, table, id)
+}
+
+func getByID(ctx context.Context, dst interface{}, table string, id int64) error {
+	tables, err := shardTables(ctx, table, dst, id)
+	if err != nil {
+		return err
+	}
+	return getShards(tables, func(table string) error {
+		b, err := NewSelectBuilderFromStruct(table, dst)
+		if err != nil {
+			return fmt.Errorf("create select builder error:%w", err)
+		}
+		b = b.Where(WhereFrom(&b.Cond, id, nil)...)
+		statement, args := Build(ctx, b)
+		return Get(ctx, dst, statement, args...)
+	})
 }
 
-// GetWhere 使用自定义条件跟新数据
+// GetWhere 使用自定义条件查询数据
+//
+// fields must be columns defined in the struct tags of dst, otherwise ErrUnknownColumn is returned, use AllowRaw to pass sql expressions.
+// The shards of sharded table are queried in order until the row found.
 func GetWhere(ctx context.Context, dst interface{}, table string, fields []string, filter KVs) error {
 	if table == "" {
 		table = TableName(dst)
 	}
+	tables, err := shardTables(ctx, table, dst, filter)
+	if err != nil {
+		return err
+	}
+	return getShards(tables, func(table string) error {
+		return getWhere(ctx, dst, table, fields, filter)
+	})
+}
+
+func getWhere(ctx context.Context, dst interface{}, table string, fields []string, filter KVs) error {
 	builder, err := NewSelectBuilderFromStruct(table, dst)
 	if err != nil {
 		return fmt.Errorf("new select builder error: %w", err)
 	}
-	if len(fields) == 0 {
-		builder = builder.Select(fields...)
+	if len(fields) > 0 {
+		cols, err := selectColumns(ctx, table, modelColumns(dst), fields)
+		if err != nil {
+			return err
+		}
+		builder = builder.Select(cols...)
 	}
 	builder = builder.Where(WhereFrom(&builder.Cond, filter, nil)...)
 	sql, args := Build(ctx, builder)
 	return Get(ctx, dst, sql, args...)
 }
 
-// GetWhere 使用自定义条件跟新数据
+// SelectWhere 使用自定义条件查询数据
+//
+// fields and sort must be columns defined in the struct tags of dst, otherwise ErrUnknownColumn is returned, use AllowRaw to pass sql expressions.
+// The result is cached if ctx is created by WithCache.
+//
+// The rows of the shards hit by the filter are merged by sort, and the page is applied after merged.
+// The sort columns missing from fields are selected too for merging.
 func SelectWhere(ctx context.Context, dst interface{}, table string, fields []string, filter KVs, sort []string, page, pageSize int) error {
 	if table == "" {
 		table = TableName(dst)
 	}
+	tables, err := shardTables(ctx, table, dst, filter)
+	if err != nil {
+		return err
+	}
+	if len(tables) == 1 {
+		return selectWhere(ctx, dst, tables[0], fields, filter, sort, page, pageSize)
+	}
+	fields = sortColumns(fields, sort)
+	return selectShards(ctx, dst, tables, sort, page, pageSize, func(dst any, table string, page, pageSize int) error {
+		return selectWhere(ctx, dst, table, fields, filter, sort, page, pageSize)
+	})
+}
+
+func selectWhere(ctx context.Context, dst interface{}, table string, fields []string, filter KVs, sort []string, page, pageSize int) error {
 	builder, err := NewSelectBuilderFromStruct(table, dst)
 	if err != nil {
 		return fmt.Errorf("new select builder error: %w", err)
 	}
-	if len(fields) == 0 {
-		builder = builder.Select(fields...)
+	known := modelColumns(dst)
+	if len(fields) > 0 {
+		cols, err := selectColumns(ctx, table, known, fields)
+		if err != nil {
+			return err
+		}
+		builder = builder.Select(cols...)
 	}
 	builder = builder.Where(WhereFrom(&builder.Cond, filter, nil)...)
 
 	if len(sort) > 0 {
-		orderByCols := make([]string, 0, 8)
-		for _, col := range sort {
-			if col == "" {
-				continue
-			}
-			if col[0] == '-' {
-				orderByCols = append(orderByCols, strings.TrimLeft(col, "-")+" DESC")
-			} else {
-				orderByCols = append(orderByCols, col+" ASC")
-			}
+		orderByCols, err := orderByColumns(ctx, table, known, sort)
+		if err != nil {
+			return err
+		}
+		if len(orderByCols) > 0 {
+			builder = builder.OrderBy(orderByCols...)
 		}
-		builder = builder.OrderBy(orderByCols...)
 	}
 	if page > 0 && pageSize > 0 {
 		builder = builder.Limit(pageSize).Offset((page - 1) * pageSize)
@@ -110,25 +166,59 @@ func SelectWhere(ctx context.Context, dst interface{}, table string, fields []st
 
 	sql, args := Build(ctx, builder)
 
-	return Select(ctx, dst, sql, args...)
+	return cachedQuery(ctx, table, dst, sql, args, func() error {
+		return Select(ctx, dst, sql, args...)
+	})
 }
 
-// Count select the count of rows in table which match the filter condition
+// Count select the count of rows in table which match the filter condition, the result is cached if ctx is created by WithCache.
+// The counts of the shards hit by the filter are summed.
+// The route of table is unknown from its name, register the model of the routed or sharded table by RegisterModels, otherwise ErrUnknownRoute is returned.
 func Count(ctx context.Context, table string, filter any) (int64, error) {
+	tables, err := shardTables(ctx, table, nil, filter)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, table := range tables {
+		n, err := count(ctx, table, filter)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+func count(ctx context.Context, table string, filter any) (int64, error) {
 	total := sql.NullInt64{}
 	b := sb.NewSelectBuilder().Select("COUNT(1) as total").From(table)
 	b = b.Where(WhereFrom(&b.Cond, filter, nil)...)
 
 	sql, args := Build(ctx, b)
-	err := Get(ctx, &total, sql, args...)
-	if IsNotFound(err) {
-		err = nil
-	}
+	err := cachedQuery(ctx, table, &total, sql, args, func() error {
+		if err := Get(ctx, &total, sql, args...); err != nil && !IsNotFound(err) {
+			return err
+		}
+		return nil
+	})
 	return total.Int64, err
 }
 
-// Count select the count of rows in table which match the filter condition
+// CountBy select the count of rows in table which match the filter condition, grouped by the group columns.
+//
+// group must be plain column names, otherwise ErrUnknownColumn is returned, use AllowRaw to pass sql expressions.
+// The filter must hit a single shard of the sharded table.
+// The route of table is unknown from its name, register the model of the routed or sharded table by RegisterModels, otherwise ErrUnknownRoute is returned.
 func CountBy(ctx context.Context, table string, filter any, group []string) ([]M, error) {
+	table, err := singleShard(ctx, table, filter)
+	if err != nil {
+		return nil, err
+	}
+	group, err = selectColumns(ctx, table, nil, group)
+	if err != nil {
+		return nil, err
+	}
 	cols := []string{"COUNT(1) as total"}
 	if len(group) > 0 {
 		cols = append(cols, group...)
@@ -142,17 +232,26 @@ func CountBy(ctx context.Context, table string, filter any, group []string) ([]M
 
 	data := []M{}
 	sql, args := Build(ctx, b)
-	err := Select(ctx, &data, sql, args...)
+	err = Select(ctx, &data, sql, args...)
 	if IsNotFound(err) {
 		err = nil
 	}
 	return data, err
 }
 
-// Distinct fetch distinct values of the column in table
+// Distinct fetch distinct values of the column in table, the filter must hit a single shard of the sharded table.
+// The route of table is unknown from its name, register the model of the routed or sharded table by RegisterModels, otherwise ErrUnknownRoute is returned.
 func Distinct(ctx context.Context, table, column string, filter KVs) ([]any, error) {
+	table, err := singleShard(ctx, table, filter)
+	if err != nil {
+		return nil, err
+	}
+	col, err := checkColumn(table, nil, column)
+	if err != nil {
+		return nil, err
+	}
 	builder := sb.NewSelectBuilder().From(table)
-	builder = builder.Select(fmt.Sprintf("DISTINCT(%s) as %s", sb.Escape(column), sb.Escape(column)))
+	builder = builder.Select(fmt.Sprintf("DISTINCT(%s) as %s", col, QuoteColumn(column[strings.LastIndex(column, ".")+1:])))
 	conds := WhereFromKVs(&builder.Cond, filter, nil)
 	builder = builder.Where(conds...)
 	sql, args := Build(ctx, builder)
@@ -164,20 +263,38 @@ func Distinct(ctx context.Context, table, column string, filter KVs) ([]any, err
 	return data, nil
 }
 
-// Exist return true if the at least one row found in table by using where condition
+// Exist return true if the at least one row found in table by using where condition, the result is cached if ctx is created by WithCache.
+// The route of table is unknown from its name, register the model of the routed or sharded table by RegisterModels, otherwise ErrUnknownRoute is returned.
 func Exist(ctx context.Context, table string, filter any) (bool, error) {
-	n := sql.NullInt64{}
+	tables, err := shardTables(ctx, table, nil, filter)
+	if err != nil {
+		return false, err
+	}
+	for _, table := range tables {
+		if found, err := exist(ctx, table, filter); err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+func exist(ctx context.Context, table string, filter any) (bool, error) {
+	var (
+		n     = sql.NullInt64{}
+		exist bool
+	)
 	b := sb.NewSelectBuilder().Select("1").From(table).Limit(1)
 	b = b.Where(WhereFrom(&b.Cond, filter, nil)...)
 	statement, args := Build(ctx, b)
-	err := Get(ctx, &n, statement, args...)
-	if err != nil {
-		if IsNotFound(err) {
-			return false, nil
+	err := cachedQuery(ctx, table, &exist, statement, args, func() error {
+		err := Get(ctx, &n, statement, args...)
+		if err != nil && !IsNotFound(err) {
+			return err
 		}
-		return false, err
-	}
-	return true, nil
+		exist = err == nil
+		return nil
+	})
+	return exist, err
 }
 
 // NewSelectBuilderFromStruct create select sql builder by data
@@ -185,6 +302,7 @@ func NewSelectBuilderFromStruct(table string, data any) (*sb.SelectBuilder, erro
 	if table == "" {
 		table = TableName(data)
 	}
+	registerModel(table, data)
 	b := sb.NewSelectBuilder().From(table)
 	if data == nil {
 		b = b.Select("*")
@@ -0,0 +1,246 @@
+package ormx
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfly/flagx"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	sessionWindow = flagx.NewDuration("database.session.window", "5s", "the reads in the session are routed to master within the window after the latest write of the session")
+	sessionGTID   = flagx.NewBool("database.session.gtid", false, "record the gtid executed by master after the writes of the session, the reads are routed to the replica having executed it instead of master")
+)
+
+// SessionHeader is the default http header carrying the session token
+const SessionHeader = "X-Ormx-Session"
+
+// Session provides the read-your-writes consistency, the reads in the session are routed to master after the writes of the session,
+// until the replica catches up or the window passed.
+//
+// The Session is carried by context, use Token and ParseSession to carry it across the requests.
+type Session struct {
+	lock      sync.Mutex
+	lastWrite time.Time
+	gtid      string
+}
+
+// NewSession create an empty Session
+func NewSession() *Session {
+	return &Session{}
+}
+
+// ParseSession decode the session from the token returned by Session.Token, an empty session is returned for empty token.
+// The time of last write in future is clamped to now, so that a forged token can't pin the reads to master forever.
+func ParseSession(token string) (*Session, error) {
+	s := &Session{}
+	if token == "" {
+		return s, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session token: %w", err)
+	}
+	ts, gtid, _ := strings.Cut(string(data), "|")
+	nanos, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session token: %w", err)
+	}
+	if nanos > 0 {
+		s.lastWrite = time.Unix(0, min(nanos, time.Now().UnixNano()))
+	}
+	s.gtid = gtid
+	return s, nil
+}
+
+// Token encode the session into a url safe string, empty if the session has no write
+func (s *Session) Token() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.lastWrite.IsZero() {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(s.lastWrite.UnixNano(), 10) + "|" + s.gtid))
+}
+
+// recordWrite record the time and gtid of the write
+func (s *Session) recordWrite(at time.Time, gtid string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if at.After(s.lastWrite) {
+		s.lastWrite = at
+	}
+	if gtid != "" {
+		s.gtid = gtid
+	}
+}
+
+// marker return the gtid and whether the session wrote within the window
+func (s *Session) marker(window time.Duration) (string, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.lastWrite.IsZero() || time.Since(s.lastWrite) >= window {
+		return "", false
+	}
+	return s.gtid, true
+}
+
+type sessionCtxKey struct{}
+
+// WithSession attach the session to ctx, the writes and reads with the returned ctx are tracked by the session
+func WithSession(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, sessionCtxKey{}, s)
+}
+
+// SessionFrom return the session attached to ctx, nil if not exists
+func SessionFrom(ctx context.Context) *Session {
+	s, _ := ctx.Value(sessionCtxKey{}).(*Session)
+	return s
+}
+
+// SessionMiddleware restore the session from the request header and attach it to the request context,
+// the token of the session is set to the same response header before the response written. Use SessionHeader if header is empty.
+func SessionMiddleware(header string, next http.Handler) http.Handler {
+	if header == "" {
+		header = SessionHeader
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := ParseSession(r.Header.Get(header))
+		if err != nil {
+			s = NewSession()
+		}
+		sw := &sessionWriter{ResponseWriter: w, header: header, session: s}
+		next.ServeHTTP(sw, r.WithContext(WithSession(r.Context(), s)))
+		// the response is written by net/http after the handler returned if nothing written
+		if !sw.wroteHeader {
+			sw.setToken()
+		}
+	})
+}
+
+// sessionWriter set the session token into header before writing the response
+type sessionWriter struct {
+	http.ResponseWriter
+	header      string
+	session     *Session
+	wroteHeader bool
+}
+
+func (w *sessionWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.setToken()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *sessionWriter) setToken() {
+	w.wroteHeader = true
+	if token := w.session.Token(); token != "" {
+		w.Header().Set(w.header, token)
+	}
+}
+
+func (w *sessionWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher, the header is written before flushing
+func (w *sessionWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, the session token is not written to the hijacked connection
+func (w *sessionWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	w.wroteHeader = true
+	return h.Hijack()
+}
+
+// Unwrap return the underlying ResponseWriter for http.ResponseController
+func (w *sessionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// recordSessionWrite record the write on the data source into the session of ctx after the transaction committed
+func recordSessionWrite(ctx context.Context, source *dataSource) {
+	s := SessionFrom(ctx)
+	if s == nil {
+		return
+	}
+	AfterCommit(ctx, func() {
+		var gtid string
+		if *sessionGTID {
+			if err := source.db(true).GetContext(context.WithoutCancel(ctx), &gtid, "SELECT @@gtid_executed"); err != nil {
+				ctxLogger(ctx).Warn().Err(err).Msg("Failed to get the gtid executed for session")
+			}
+		}
+		s.recordWrite(time.Now(), gtid)
+	})
+}
+
+// readDB return the db of source for reading and whether it's master.
+// The read is routed to master if ctx has FromMaster, or the session of ctx wrote recently and the replica has not caught up,
+// or no replica is fresher than the staleness of ctx.
+func readDB(ctx context.Context, source *dataSource) (*sqlx.DB, bool) {
+	if isFromMaster(ctx) {
+		return source.db(true), true
+	}
+	s := SessionFrom(ctx)
+	if s == nil || isFromSlave(ctx) {
+		return slaveFor(ctx, source)
+	}
+	gtid, recent := s.marker(time.Duration(sessionWindow.Msecs) * time.Millisecond)
+	if !recent {
+		return slaveFor(ctx, source)
+	}
+	master := source.db(true)
+	if gtid != "" {
+		slave, isMaster := slaveFor(ctx, source)
+		if isMaster || slave == master {
+			return master, true
+		}
+		var caughtUp bool
+		if err := slave.GetContext(ctx, &caughtUp, "SELECT GTID_SUBSET(?, @@gtid_executed)", gtid); err == nil && caughtUp {
+			return slave, false
+		}
+	}
+	return master, true
+}
+
+// slaveFor return the slave of source satisfying the staleness set by WithMaxStaleness, the master is returned if none satisfied.
+// It's the same as the slave of source if ctx has no staleness.
+func slaveFor(ctx context.Context, source *dataSource) (*sqlx.DB, bool) {
+	staleness, ok := maxStaleness(ctx)
+	switch {
+	case !ok:
+		return source.db(false), false
+	case staleness <= 0:
+		return source.db(true), true
+	case source.replicas == nil:
+		return source.db(false), false
+	}
+	if db := source.replicas.pick(staleness); db != nil {
+		return db, false
+	}
+	return source.db(true), true
+}
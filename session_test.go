@@ -0,0 +1,155 @@
+package ormx
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cloudfly/ormx/test"
+	"github.com/jmoiron/sqlx"
+)
+
+// useMasterSlave route the queries to two separated sqlite databases, the slave never catches up
+func useMasterSlave(t *testing.T) (*sqlx.DB, *sqlx.DB) {
+	master, slave := test.SQLite(t), test.SQLite(t)
+	prevProvider, prevDialect := p, *dialect
+	p, *dialect = func(isMaster bool) *sqlx.DB {
+		if isMaster {
+			return master
+		}
+		return slave
+	}, "sqlite3"
+	t.Cleanup(func() { p, *dialect = prevProvider, prevDialect })
+	return master, slave
+}
+
+const insertTestRow = "INSERT INTO test (producer, resource, action, message) VALUES ('unittest', 'session', 'test', '')"
+
+func countRows(t *testing.T, ctx context.Context) int {
+	var n int
+	test.NoError(t, Get(ctx, &n, "SELECT COUNT(*) FROM test"))
+	return n
+}
+
+func TestSession(t *testing.T) {
+	useMasterSlave(t)
+	var (
+		ctx     = context.Background()
+		session = NewSession()
+		sctx    = WithSession(ctx, session)
+	)
+	test.Equal(t, "", session.Token())
+	test.Equal(t, 0, countRows(t, sctx))
+
+	_, err := Exec(sctx, insertTestRow)
+	test.NoError(t, err)
+	test.Equal(t, 1, countRows(t, sctx))
+	test.Equal(t, 0, countRows(t, ctx))
+	test.Equal(t, 0, countRows(t, FromSlave(sctx)))
+
+	// the session is restored from token
+	restored, err := ParseSession(session.Token())
+	test.NoError(t, err)
+	test.Equal(t, 1, countRows(t, WithSession(ctx, restored)))
+
+	_, err = ParseSession("!invalid")
+	test.Equal(t, true, err != nil)
+
+	// the last write in future is clamped to now
+	forged, err := ParseSession(base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(time.Now().Add(time.Hour).UnixNano(), 10) + "|")))
+	test.NoError(t, err)
+	test.Equal(t, true, !forged.lastWrite.After(time.Now()))
+
+	prev := sessionWindow.Msecs
+	sessionWindow.Msecs = 1
+	t.Cleanup(func() { sessionWindow.Msecs = prev })
+	time.Sleep(2 * time.Millisecond)
+	test.Equal(t, 0, countRows(t, sctx))
+}
+
+func TestSessionTx(t *testing.T) {
+	useMasterSlave(t)
+	var (
+		session = NewSession()
+		ctx     = WithSession(context.Background(), session)
+		boom    = errors.New("boom")
+	)
+
+	err := RunTxContext(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+		if _, err := ExecTx(ctx, tx, insertTestRow); err != nil {
+			return err
+		}
+		test.Equal(t, "", session.Token())
+		return boom
+	})
+	test.Equal(t, boom, err)
+	test.Equal(t, "", session.Token())
+
+	err = RunTxContext(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+		_, err := ExecTx(ctx, tx, insertTestRow)
+		return err
+	})
+	test.NoError(t, err)
+	test.Equal(t, true, session.Token() != "")
+	test.Equal(t, 1, countRows(t, ctx))
+}
+
+func TestSessionMiddleware(t *testing.T) {
+	useMasterSlave(t)
+	handler := SessionMiddleware("", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			if _, err := Exec(r.Context(), insertTestRow); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Write([]byte{byte('0' + countRows(t, r.Context()))})
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	test.Equal(t, "1", w.Body.String())
+	token := w.Header().Get(SessionHeader)
+	test.Equal(t, true, token != "")
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(SessionHeader, token)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	test.Equal(t, "1", w.Body.String())
+	test.Equal(t, token, w.Header().Get(SessionHeader))
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	test.Equal(t, "0", w.Body.String())
+	test.Equal(t, "", w.Header().Get(SessionHeader))
+
+	// the token is set if the handler writes nothing, and the writer supports flushing
+	handler = SessionMiddleware("", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := Exec(r.Context(), insertTestRow); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Path == "/flush" {
+			w.(http.Flusher).Flush()
+		}
+	}))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	test.Equal(t, http.StatusOK, w.Code)
+	test.Equal(t, true, w.Header().Get(SessionHeader) != "")
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/flush", nil))
+	test.Equal(t, true, w.Flushed)
+	test.Equal(t, true, w.Result().Header.Get(SessionHeader) != "")
+
+	// hijacking is not supported by the recorder
+	_, _, err := (&sessionWriter{ResponseWriter: httptest.NewRecorder()}).Hijack()
+	test.Equal(t, http.ErrNotSupported, err)
+}
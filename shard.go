@@ -0,0 +1,487 @@
+package ormx
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var shardings = map[string]*Sharding{} // logical table => sharding, guarded by sourceLock
+
+// ShardStrategy locates the shard of a shard key value
+type ShardStrategy interface {
+	// Shards return the number of shards
+	Shards() int
+	// Shard return the shard index in [0, Shards()) of the key
+	Shard(ctx context.Context, key any) (int, error)
+}
+
+// Sharding splits a logical table into physical tables by the shard key, such as orders_00..orders_63.
+//
+// A model declares its sharding by the method `Sharding() Sharding`, or call RegisterSharding for the table.
+type Sharding struct {
+	// Key is the column of shard key
+	Key      string
+	Strategy ShardStrategy
+	// Format is the format of physical table name with the logical table and shard index, default "%s_%02d"
+	Format string
+	// Sources are the data sources the shards spread over in contiguous blocks, e.g. the shards 0..31 in Sources[0] and 32..63 in Sources[1] for 64 shards and 2 sources.
+	// The physical tables are routed like the other tables if empty.
+	Sources []string
+	// Scatter allows the queries without shard key to run on all the shards and merge the results, otherwise ErrShardKeyMissing is returned.
+	// Use AllowScatter to allow it for a context.
+	Scatter bool
+}
+
+// table return the physical table name of the shard
+func (s *Sharding) table(table string, shard int) string {
+	format := s.Format
+	if format == "" {
+		format = "%s_%02d"
+	}
+	return fmt.Sprintf(format, table, shard)
+}
+
+// RegisterSharding register the sharding of the logical table, it replaces the previous one.
+// It panics if the Strategy is nil or has no shard.
+func RegisterSharding(table string, s Sharding) {
+	if s.Strategy == nil || s.Strategy.Shards() <= 0 {
+		panic(fmt.Sprintf("the sharding of table %s has no shard", table))
+	}
+	sourceLock.Lock()
+	defer sourceLock.Unlock()
+	shardings[table] = &s
+	n := s.Strategy.Shards()
+	for i := 0; i < n && len(s.Sources) > 0; i++ {
+		tableRoutes[s.table(table, i)] = s.Sources[i*len(s.Sources)/n]
+	}
+}
+
+func shardingOf(table string) *Sharding {
+	sourceLock.RLock()
+	defer sourceLock.RUnlock()
+	return shardings[table]
+}
+
+type scatterCtxKey struct{}
+
+// AllowScatter allow the queries on the sharded tables without shard key to run on all the shards when called by this context
+func AllowScatter(ctx context.Context) context.Context {
+	return context.WithValue(ctx, scatterCtxKey{}, true)
+}
+
+func isScatterAllowed(ctx context.Context, s *Sharding) bool {
+	allowed, _ := ctx.Value(scatterCtxKey{}).(bool)
+	return allowed || s.Scatter
+}
+
+// ShardTable return the physical table of the logical table holding the shard key, the table itself is returned if it's not sharded.
+// The queries on the physical table are routed to the data source of the shard.
+func ShardTable(ctx context.Context, table string, key any) (string, error) {
+	s := shardingOf(table)
+	if s == nil {
+		return table, nil
+	}
+	shard, err := s.Strategy.Shard(ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("locate shard of %s: %w", table, err)
+	}
+	return s.table(table, shard), nil
+}
+
+// shardTables return the physical tables of table hit by the filter, the table itself if it's not sharded.
+// All the shards are returned if the filter has no shard key and scatter is allowed, otherwise ErrShardKeyMissing is returned.
+// The model is registered in advance for its sharding, ErrUnknownRoute is returned if model is nil and the table may be routed wrongly.
+func shardTables(ctx context.Context, table string, model any, filter any) ([]string, error) {
+	if model == nil {
+		if err := checkRoute(ctx, table); err != nil {
+			return nil, err
+		}
+	}
+	registerModel(table, model)
+	s := shardingOf(table)
+	if s == nil {
+		return []string{table}, nil
+	}
+	keys, ok := shardKeyValues(s.Key, filter)
+	if !ok {
+		if !isScatterAllowed(ctx, s) {
+			return nil, fmt.Errorf("%w: querying table '%s' without %s, use AllowScatter to query all the shards", ErrShardKeyMissing, table, s.Key)
+		}
+		tables := make([]string, s.Strategy.Shards())
+		for i := range tables {
+			tables[i] = s.table(table, i)
+		}
+		return tables, nil
+	}
+	var tables []string
+	for _, key := range keys {
+		shard, err := s.Strategy.Shard(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("locate shard of %s: %w", table, err)
+		}
+		if t := s.table(table, shard); !slices.Contains(tables, t) {
+			tables = append(tables, t)
+		}
+	}
+	return tables, nil
+}
+
+// singleShard return the only physical table of table hit by the filter, ErrShardKeyMissing is returned if the filter hits multiple shards
+func singleShard(ctx context.Context, table string, filter any) (string, error) {
+	tables, err := shardTables(ctx, table, nil, filter)
+	if err != nil {
+		return "", err
+	}
+	if len(tables) != 1 {
+		return "", fmt.Errorf("%w: the query on table '%s' can not run across shards", ErrShardKeyMissing, table)
+	}
+	return tables[0], nil
+}
+
+// shardKeyValues return the values of the key column the filter equals to, false if the filter has no equal condition on the key.
+// It follows the rules of WhereFrom.
+func shardKeyValues(key string, filter any) ([]any, bool) {
+	if filter == nil {
+		return nil, false
+	}
+	keyValues := func(value any, op string) ([]any, bool) {
+		isSlice := value != nil && dereferencedType(reflect.TypeOf(value)).Kind() == reflect.Slice
+		switch {
+		case op == "in" || op == "" && isSlice:
+			return Any2Slice(dereferencedValue(reflect.ValueOf(value)).Interface()), true
+		case op == "e" || op == "":
+			return []any{value}, true
+		}
+		return nil, false
+	}
+	if kvs, ok := filter.(KVs); ok {
+		for _, kv := range kvs {
+			if column := kv.Key[strings.LastIndex(kv.Key, ".")+1:]; column != key {
+				continue
+			}
+			if values, ok := keyValues(kv.Value, kv.Extra); ok {
+				return values, true
+			}
+		}
+		return nil, false
+	}
+	v := dereferencedValue(reflect.ValueOf(filter))
+	if v.Kind() == reflect.Struct {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if name, _ := colNameFromTag(t.Field(i)); name != key || v.Field(i).Kind() == reflect.Ptr && v.Field(i).IsNil() {
+				continue
+			}
+			if values, ok := keyValues(dereferencedValue(v.Field(i)).Interface(), t.Field(i).Tag.Get("op")); ok {
+				return values, true
+			}
+		}
+		return nil, false
+	}
+	if ids, ok := filterIDs(filter); ok && key == *primaryKey {
+		return ids, true
+	}
+	return nil, false
+}
+
+// shardRows group the rows by the physical tables of table their shard keys located, the tables are in the order of first row.
+// ErrShardKeyMissing is returned if the row has no shard key column.
+func shardRows(ctx context.Context, table string, data []any) ([]string, map[string][]any, error) {
+	registerModel(table, data[0])
+	s := shardingOf(table)
+	if s == nil {
+		return []string{table}, map[string][]any{table: data}, nil
+	}
+	var (
+		tables []string
+		groups = map[string][]any{}
+	)
+	for _, item := range data {
+		key, ok := columnValue(dereferencedValue(reflect.ValueOf(item)), s.Key)
+		if !ok || !key.IsValid() {
+			return nil, nil, fmt.Errorf("%w: inserting into table '%s' without %s", ErrShardKeyMissing, table, s.Key)
+		}
+		physical, err := ShardTable(ctx, table, key.Interface())
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, ok := groups[physical]; !ok {
+			tables = append(tables, physical)
+		}
+		groups[physical] = append(groups[physical], item)
+	}
+	return tables, groups, nil
+}
+
+// columnValue return the dereferenced value of the struct field tagged by the column
+func columnValue(v reflect.Value, column string) (reflect.Value, bool) {
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if name, _ := colNameFromTag(t.Field(i)); name == column {
+			return dereferencedValue(v.Field(i)), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// getShards call get on the tables in order until a row found, sql.ErrNoRows is returned if no row found in all the tables
+func getShards(tables []string, get func(table string) error) error {
+	for _, table := range tables {
+		if err := get(table); !IsNotFound(err) {
+			return err
+		}
+	}
+	return sql.ErrNoRows
+}
+
+// selectShards select the rows from each table into dst and merge them by sort, the page is applied after merged
+func selectShards(ctx context.Context, dst any, tables []string, sort []string, page, pageSize int, query func(dst any, table string, page, pageSize int) error) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("the dst of scatter query should be pointer of slice, got %T", dst)
+	}
+	less, err := shardRowLess(ctx, rv.Elem().Type().Elem(), sort)
+	if err != nil {
+		return err
+	}
+	// each shard returns the rows of the first pages, the rows of the page are in them after merged
+	limit := 0
+	if page > 0 && pageSize > 0 {
+		limit = page * pageSize
+	}
+	rows := reflect.MakeSlice(rv.Elem().Type(), 0, 0)
+	for _, table := range tables {
+		part := reflect.New(rv.Elem().Type())
+		if err := query(part.Interface(), table, min(1, limit), limit); err != nil && !IsNotFound(err) {
+			return err
+		}
+		rows = reflect.AppendSlice(rows, part.Elem())
+	}
+	if less != nil {
+		sortRows(rows, less)
+	}
+	if limit > 0 {
+		start := min((page-1)*pageSize, rows.Len())
+		rows = rows.Slice(start, min(start+pageSize, rows.Len()))
+	}
+	rv.Elem().Set(rows)
+	return nil
+}
+
+// sortColumns append the sort columns missing from fields, the rows of shards are merged by them.
+// The empty fields selecting all the columns is returned as is.
+func sortColumns(fields, sort []string) []string {
+	if len(fields) == 0 {
+		return fields
+	}
+	fields = slices.Clip(fields)
+	for _, item := range sort {
+		column := strings.TrimPrefix(item, "-")
+		name := column[strings.LastIndex(column, ".")+1:]
+		if name == "" {
+			continue
+		}
+		selected := slices.ContainsFunc(fields, func(field string) bool {
+			field = field[strings.LastIndex(field, ".")+1:]
+			return field == "*" || field == name
+		})
+		if !selected {
+			fields = append(fields, column)
+		}
+	}
+	return fields
+}
+
+func sortRows(rows reflect.Value, less func(a, b reflect.Value) bool) {
+	sort.SliceStable(rows.Interface(), func(i, j int) bool {
+		return less(rows.Index(i), rows.Index(j))
+	})
+}
+
+// shardRowLess return the function comparing the rows of elemType by sort, nil if sort is empty.
+// The sort items must be the columns of the model, the raw expressions can not be merged.
+func shardRowLess(ctx context.Context, elemType reflect.Type, sort []string) (func(a, b reflect.Value) bool, error) {
+	type order struct {
+		column string
+		desc   bool
+	}
+	var orders []order
+	for _, item := range sort {
+		if item == "" {
+			continue
+		}
+		o := order{column: item}
+		if item[0] == '-' {
+			o.column, o.desc = item[1:], true
+		}
+		o.column = o.column[strings.LastIndex(o.column, ".")+1:]
+		if _, ok := columnValue(reflect.New(dereferencedType(elemType)).Elem(), o.column); !ok || isRawAllowed(ctx, item) {
+			return nil, fmt.Errorf("%w: %q can not be merged across shards", ErrUnknownColumn, item)
+		}
+		orders = append(orders, o)
+	}
+	if len(orders) == 0 {
+		return nil, nil
+	}
+	return func(a, b reflect.Value) bool {
+		for _, o := range orders {
+			av, _ := columnValue(dereferencedValue(a), o.column)
+			bv, _ := columnValue(dereferencedValue(b), o.column)
+			if c := compareValues(av, bv); c != 0 {
+				return c < 0 != o.desc
+			}
+		}
+		return false
+	}, nil
+}
+
+// compareValues compare the column values for sorting, the invalid(NULL) value is the smallest
+func compareValues(a, b reflect.Value) int {
+	a, b = sortableValue(a), sortableValue(b)
+	switch {
+	case !a.IsValid() || !b.IsValid():
+		return cmp.Compare(boolInt(a.IsValid()), boolInt(b.IsValid()))
+	case a.Kind() != b.Kind():
+		return cmp.Compare(fmt.Sprint(a.Interface()), fmt.Sprint(b.Interface()))
+	}
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return cmp.Compare(a.Int(), b.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return cmp.Compare(a.Uint(), b.Uint())
+	case reflect.Float32, reflect.Float64:
+		return cmp.Compare(a.Float(), b.Float())
+	case reflect.String:
+		return cmp.Compare(a.String(), b.String())
+	case reflect.Bool:
+		return cmp.Compare(boolInt(a.Bool()), boolInt(b.Bool()))
+	}
+	if at, ok := a.Interface().(time.Time); ok {
+		return at.Compare(b.Interface().(time.Time))
+	}
+	return cmp.Compare(fmt.Sprint(a.Interface()), fmt.Sprint(b.Interface()))
+}
+
+// sortableValue dereference the value and unwrap the driver.Valuer like sql.NullInt64
+func sortableValue(v reflect.Value) reflect.Value {
+	v = dereferencedValue(v)
+	if !v.IsValid() {
+		return v
+	}
+	if valuer, ok := v.Interface().(driver.Valuer); ok {
+		value, err := valuer.Value()
+		if err != nil || value == nil {
+			return reflect.Value{}
+		}
+		return reflect.ValueOf(value)
+	}
+	return v
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// shardKeyInt convert the integer or numeric string key to int64
+func shardKeyInt(key any) (int64, error) {
+	v := dereferencedValue(reflect.ValueOf(key))
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint()), nil
+	case reflect.String:
+		return strconv.ParseInt(v.String(), 10, 64)
+	}
+	return 0, fmt.Errorf("unsupported shard key %v(%T)", key, key)
+}
+
+type hashMod int
+
+// HashMod locate the shard by key mod n, the key not integer is hashed by FNV-1a. It panics if n is not positive.
+func HashMod(n int) ShardStrategy {
+	if n <= 0 {
+		panic(fmt.Sprintf("HashMod with %d shards", n))
+	}
+	return hashMod(n)
+}
+
+func (h hashMod) Shards() int { return int(h) }
+
+func (h hashMod) Shard(_ context.Context, key any) (int, error) {
+	if n, err := shardKeyInt(key); err == nil {
+		return int((n%int64(h) + int64(h)) % int64(h)), nil
+	}
+	hash := fnv.New32a()
+	fmt.Fprint(hash, dereferencedValue(reflect.ValueOf(key)))
+	return int(hash.Sum32() % uint32(h)), nil
+}
+
+type rangeShards []int64
+
+// RangeShards locate the shard by the integer key range, the shard i holds the keys in [bounds[i-1], bounds[i]),
+// there are len(bounds)+1 shards and the bounds should be ascending.
+func RangeShards(bounds ...int64) ShardStrategy {
+	return rangeShards(bounds)
+}
+
+func (r rangeShards) Shards() int { return len(r) + 1 }
+
+func (r rangeShards) Shard(_ context.Context, key any) (int, error) {
+	n, err := shardKeyInt(key)
+	if err != nil {
+		return 0, err
+	}
+	return sort.Search(len(r), func(i int) bool { return n < r[i] }), nil
+}
+
+type lookupShards struct {
+	n      int
+	lookup func(ctx context.Context, key any) (int, error)
+}
+
+// LookupShards locate the shard of n shards by the lookup function, such as reading a directory table
+func LookupShards(n int, lookup func(ctx context.Context, key any) (int, error)) ShardStrategy {
+	return &lookupShards{n: n, lookup: lookup}
+}
+
+// LookupTable locate the shard of n shards by the shardColumn of the row whose keyColumn equals to the key in table
+func LookupTable(n int, table, keyColumn, shardColumn string) ShardStrategy {
+	return LookupShards(n, func(ctx context.Context, key any) (int, error) {
+		var shard int
+		statement := fmt.Sprintf("SELECT %s FROM %s WHERE %s = ?", QuoteColumn(shardColumn), Dialect().Quote(table), QuoteColumn(keyColumn))
+		if err := Get(ctx, &shard, statement, key); err != nil {
+			return 0, fmt.Errorf("lookup shard of %v: %w", key, err)
+		}
+		return shard, nil
+	})
+}
+
+func (l *lookupShards) Shards() int { return l.n }
+
+func (l *lookupShards) Shard(ctx context.Context, key any) (int, error) {
+	shard, err := l.lookup(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	if shard < 0 || shard >= l.n {
+		return 0, fmt.Errorf("shard %d of %v is out of range [0, %d)", shard, key, l.n)
+	}
+	return shard, nil
+}
@@ -0,0 +1,248 @@
+package ormx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudfly/ormx/test"
+	"github.com/jmoiron/sqlx"
+)
+
+type orderRow struct {
+	ID       int64  `db:"id,insert"`
+	TenantID int64  `db:"tenant_id,insert"`
+	Amount   int64  `db:"amount,insert"`
+	Name     string `db:"name,insert"`
+}
+
+func (orderRow) Table() string { return "orders" }
+
+// the orders are sharded by tenant_id into orders_00..orders_03, the shards 0 and 1 are in db0, 2 and 3 are in db1
+func (orderRow) Sharding() Sharding {
+	return Sharding{Key: "tenant_id", Strategy: HashMod(4), Sources: []string{"db0", "db1"}}
+}
+
+type orderPatch struct {
+	Amount *int64 `db:"amount"`
+}
+
+// useShards register two sqlite files as data sources db0 and db1 having the order shards
+func useShards(t *testing.T) (*sqlx.DB, *sqlx.DB) {
+	useDataSources(t)
+	useSQLite(t)
+	var dbs []*sqlx.DB
+	for i := 0; i < 2; i++ {
+		db, err := sqlx.Connect("sqlite3", filepath.Join(t.TempDir(), fmt.Sprintf("shard%d.db", i)))
+		test.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		for shard := 2 * i; shard < 2*i+2; shard++ {
+			_, err := db.Exec(fmt.Sprintf("CREATE TABLE orders_%02d (id INTEGER PRIMARY KEY, tenant_id INTEGER NOT NULL, amount INTEGER NOT NULL, name TEXT NOT NULL)", shard))
+			test.NoError(t, err)
+		}
+		RegisterDataSource(fmt.Sprintf("db%d", i), func(bool) *sqlx.DB { return db })
+		dbs = append(dbs, db)
+	}
+	return dbs[0], dbs[1]
+}
+
+func insertOrders(t *testing.T, ctx context.Context) {
+	var orders []any
+	for i := int64(1); i <= 8; i++ {
+		// tenant i%4 has the orders i and i+4
+		orders = append(orders, orderRow{ID: i, TenantID: i % 4, Amount: i * 10, Name: fmt.Sprintf("order%d", i)})
+	}
+	test.NoError(t, InsertMany(ctx, "", orders...))
+}
+
+func TestShardInsert(t *testing.T) {
+	db0, db1 := useShards(t)
+	ctx := context.Background()
+	insertOrders(t, ctx)
+	test.Equal(t, 2, countTable(t, db0, "orders_00"))
+	test.Equal(t, 2, countTable(t, db0, "orders_01"))
+	test.Equal(t, 2, countTable(t, db1, "orders_02"))
+	test.Equal(t, 2, countTable(t, db1, "orders_03"))
+
+	id, err := InsertOne(ctx, "", orderRow{ID: 9, TenantID: 6, Amount: 90, Name: "order9"})
+	test.NoError(t, err)
+	test.Equal(t, int64(9), id)
+	test.Equal(t, 3, countTable(t, db1, "orders_02"))
+
+	table, err := ShardTable(ctx, "orders", 7)
+	test.NoError(t, err)
+	test.Equal(t, "orders_03", table)
+	var n int
+	test.NoError(t, Get(ctx, &n, "SELECT COUNT(*) FROM "+table))
+	test.Equal(t, 2, n)
+}
+
+func TestShardSelect(t *testing.T) {
+	useShards(t)
+	ctx := context.Background()
+	insertOrders(t, ctx)
+
+	var rows []orderRow
+	test.NoError(t, SelectWhere(ctx, &rows, "", nil, KVs{{Key: "tenant_id", Value: 1}}, []string{"id"}, 0, 0))
+	test.Equal(t, []int64{1, 5}, orderIDs(rows))
+
+	test.NoError(t, SelectWhere(ctx, &rows, "", nil, KVs{{Key: "tenant_id", Value: []int64{1, 2}, Extra: "in"}}, []string{"-amount"}, 0, 0))
+	test.Equal(t, []int64{6, 5, 2, 1}, orderIDs(rows))
+
+	var row orderRow
+	test.NoError(t, GetWhere(ctx, &row, "", nil, KVs{{Key: "tenant_id", Value: 3}, {Key: "amount", Value: 70}}))
+	test.Equal(t, int64(7), row.ID)
+
+	n, err := Count(ctx, "orders", KVs{{Key: "tenant_id", Value: []int64{0, 3}}})
+	test.NoError(t, err)
+	test.Equal(t, int64(4), n)
+
+	// the queries without shard key fail unless scatter allowed
+	err = SelectWhere(ctx, &rows, "", nil, KVs{{Key: "name", Value: "order1"}}, nil, 0, 0)
+	test.Equal(t, true, errors.Is(err, ErrShardKeyMissing))
+	_, err = Count(ctx, "orders", KVs{})
+	test.Equal(t, true, errors.Is(err, ErrShardKeyMissing))
+	_, err = InsertOne(ctx, "orders", TestRow{Producer: "unittest"})
+	test.Equal(t, true, errors.Is(err, ErrShardKeyMissing))
+	_, err = Distinct(ctx, "orders", "name", KVs{{Key: "tenant_id", Value: []int64{0, 3}}})
+	test.Equal(t, true, errors.Is(err, ErrShardKeyMissing))
+}
+
+func TestShardScatter(t *testing.T) {
+	useShards(t)
+	ctx := AllowScatter(context.Background())
+	insertOrders(t, ctx)
+
+	var rows []orderRow
+	test.NoError(t, SelectWhere(ctx, &rows, "", nil, nil, []string{"-amount"}, 2, 3))
+	test.Equal(t, []int64{5, 4, 3}, orderIDs(rows))
+	test.NoError(t, SelectWhere(ctx, &rows, "", nil, KVs{{Key: "amount", Value: 40, Extra: "gt"}}, []string{"name"}, 0, 0))
+	test.Equal(t, []int64{5, 6, 7, 8}, orderIDs(rows))
+	test.NoError(t, SelectWhere(ctx, &rows, "", nil, nil, []string{"id"}, 3, 3))
+	test.Equal(t, []int64{7, 8}, orderIDs(rows))
+	// the sort column not in fields is selected for merging
+	test.NoError(t, SelectWhere(ctx, &rows, "", []string{"id"}, nil, []string{"-amount"}, 1, 3))
+	test.Equal(t, []int64{8, 7, 6}, orderIDs(rows))
+
+	err := SelectWhere(AllowRaw(ctx, "amount % 3"), &rows, "", nil, nil, []string{"amount % 3"}, 0, 0)
+	test.Equal(t, true, errors.Is(err, ErrUnknownColumn))
+
+	n, err := Count(ctx, "orders", KVs{})
+	test.NoError(t, err)
+	test.Equal(t, int64(8), n)
+
+	exist, err := Exist(ctx, "orders", KVs{{Key: "name", Value: "order8"}})
+	test.NoError(t, err)
+	test.Equal(t, true, exist)
+
+	var row orderRow
+	test.NoError(t, GetByID(ctx, &row, "", 6))
+	test.Equal(t, int64(2), row.TenantID)
+	test.Equal(t, true, IsNotFound(GetByID(ctx, &row, "", 100)))
+
+	amount := int64(0)
+	affected, err := PatchWhere(ctx, "orders", orderPatch{Amount: &amount}, KVs{{Key: "amount", Value: 60, Extra: "gte"}})
+	test.NoError(t, err)
+	test.Equal(t, int64(3), affected)
+	test.NoError(t, DeleteWhere(ctx, "orders", KVs{{Key: "amount", Value: 0}}))
+	n, err = Count(ctx, "orders", KVs{})
+	test.NoError(t, err)
+	test.Equal(t, int64(5), n)
+}
+
+func TestShardCache(t *testing.T) {
+	useShards(t)
+	ctx := WithCache(context.Background(), time.Minute)
+	insertOrders(t, ctx)
+
+	var rows []orderRow
+	test.NoError(t, SelectWhere(ctx, &rows, "", nil, KVs{{Key: "tenant_id", Value: 1}}, []string{"id"}, 0, 0))
+	test.Equal(t, []int64{10, 50}, orderAmounts(rows))
+	n, err := Count(ctx, "orders", KVs{{Key: "tenant_id", Value: 1}, {Key: "amount", Value: 0}})
+	test.NoError(t, err)
+	test.Equal(t, int64(0), n)
+
+	// the results cached by the physical tables are removed by the write on the logical table
+	amount := int64(0)
+	_, err = PatchWhere(ctx, "orders", orderPatch{Amount: &amount}, KVs{{Key: "tenant_id", Value: 1}, {Key: "id", Value: 5}})
+	test.NoError(t, err)
+	test.NoError(t, SelectWhere(ctx, &rows, "", nil, KVs{{Key: "tenant_id", Value: 1}}, []string{"id"}, 0, 0))
+	test.Equal(t, []int64{10, 0}, orderAmounts(rows))
+	n, err = Count(ctx, "orders", KVs{{Key: "tenant_id", Value: 1}, {Key: "amount", Value: 0}})
+	test.NoError(t, err)
+	test.Equal(t, int64(1), n)
+}
+
+func TestShardStrategy(t *testing.T) {
+	ctx := context.Background()
+	for key, shard := range map[any]int{int64(5): 1, -3: 1, "12": 0, uint8(7): 3} {
+		got, err := HashMod(4).Shard(ctx, key)
+		test.NoError(t, err)
+		test.Equal(t, shard, got)
+	}
+	s1, _ := HashMod(4).Shard(ctx, "tenant-a")
+	s2, _ := HashMod(4).Shard(ctx, "tenant-a")
+	test.Equal(t, s1, s2)
+
+	ranges := RangeShards(100, 200)
+	test.Equal(t, 3, ranges.Shards())
+	for key, shard := range map[int64]int{-1: 0, 99: 0, 100: 1, 199: 1, 200: 2} {
+		got, err := ranges.Shard(ctx, key)
+		test.NoError(t, err)
+		test.Equal(t, shard, got)
+	}
+	_, err := ranges.Shard(ctx, "tenant-a")
+	test.Equal(t, true, err != nil)
+
+	// the strategy without shard is refused
+	for _, register := range []func(){
+		func() { HashMod(0) },
+		func() { RegisterSharding("bogus", Sharding{Key: "id"}) },
+		func() { RegisterSharding("bogus", Sharding{Key: "id", Strategy: hashMod(-1)}) },
+	} {
+		test.Equal(t, true, panics(register))
+	}
+}
+
+func panics(f func()) (panicked bool) {
+	defer func() { panicked = recover() != nil }()
+	f()
+	return false
+}
+
+func TestLookupTable(t *testing.T) {
+	db := useSQLite(t)
+	_, err := db.Exec("CREATE TABLE tenant_shard (tenant TEXT PRIMARY KEY, shard INTEGER NOT NULL); INSERT INTO tenant_shard VALUES ('a', 2), ('b', 9)")
+	test.NoError(t, err)
+
+	var (
+		ctx    = context.Background()
+		lookup = LookupTable(4, "tenant_shard", "tenant", "shard")
+	)
+	shard, err := lookup.Shard(ctx, "a")
+	test.NoError(t, err)
+	test.Equal(t, 2, shard)
+	_, err = lookup.Shard(ctx, "b")
+	test.Equal(t, true, err != nil)
+	_, err = lookup.Shard(ctx, "c")
+	test.Equal(t, true, IsNotFound(err))
+}
+
+func orderIDs(rows []orderRow) []int64 {
+	ids := make([]int64, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.ID)
+	}
+	return ids
+}
+
+func orderAmounts(rows []orderRow) []int64 {
+	amounts := make([]int64, 0, len(rows))
+	for _, row := range rows {
+		amounts = append(amounts, row.Amount)
+	}
+	return amounts
+}
@@ -0,0 +1,160 @@
+package ormx
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfly/flagx"
+	sb "github.com/huandu/go-sqlbuilder"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	slowQueryThreshold       = flagx.NewDuration("database.slowquery.threshold", "0", "the queries taking longer than it are logged as slow query, 0 disables the slow query log")
+	slowQueryExplain         = flagx.NewBool("database.slowquery.explain", false, "run EXPLAIN for the slow SELECT and log the plan along with the slow query")
+	slowQueryExplainInterval = flagx.NewDuration("database.slowquery.explain.interval", "1m", "the minimum interval of running EXPLAIN for the same query")
+	slowQueryExplainTimeout  = flagx.NewDuration("database.slowquery.explain.timeout", "5s", "the timeout of running EXPLAIN")
+
+	slowQueryThresholdOverride atomic.Int64 // the threshold set by SetSlowQueryThreshold, negative if not set
+
+	explainLock sync.Mutex
+	explainLast = map[string]time.Time{}
+	explainWG   sync.WaitGroup
+)
+
+func init() {
+	slowQueryThresholdOverride.Store(-1)
+}
+
+// the directory of ormx source code, the frames in it are skipped when finding the caller
+var sourceDir = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Dir(file)
+}()
+
+// SetSlowQueryThreshold set the threshold of slow query, it overrides the flag. The threshold 0 disables the slow query log,
+// and a negative threshold drops the override, so the flag database.slowquery.threshold takes effect again.
+func SetSlowQueryThreshold(threshold time.Duration) {
+	slowQueryThresholdOverride.Store(int64(threshold))
+}
+
+func slowThreshold() time.Duration {
+	if threshold := time.Duration(slowQueryThresholdOverride.Load()); threshold >= 0 {
+		return threshold
+	}
+	return time.Duration(slowQueryThreshold.Msecs) * time.Millisecond
+}
+
+// logSlowQuery log the query if it takes longer than the threshold, the plan is attached for SELECT if explain enabled.
+func logSlowQuery(ctx context.Context, q *queryRun, duration time.Duration, err error) {
+	threshold := slowThreshold()
+	if threshold <= 0 || duration < threshold {
+		return
+	}
+	normalized := q.normalize()
+	event := ctxLogger(ctx).Warn().
+		Str("query", normalized).
+		Any("args", redactArgs(q.sql, q.tables, q.args)).
+		Dur("duration", duration).
+		Str("caller", caller()).
+		Bool("master", q.master).
+		Bool("tx", q.inTx)
+	if err != nil {
+		event = event.Err(err)
+	}
+	if !*slowQueryExplain || q.op != OpSelect || !explainAllowed(q.fingerprint()) {
+		event.Msg("Slow query")
+		return
+	}
+
+	// the connection of transaction is busy, explain on the pool of master instead
+	db := q.db
+	if db == nil {
+		db = q.source.db(true)
+	}
+
+	explainWG.Add(1)
+	go func() {
+		defer explainWG.Done()
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Duration(slowQueryExplainTimeout.Msecs)*time.Millisecond)
+		defer cancel()
+		plan, err := explain(ctx, db, q.sql, q.args)
+		if err != nil {
+			event.AnErr("explain_error", err).Msg("Slow query")
+			return
+		}
+		event.Any("plan", plan).Msg("Slow query")
+	}()
+}
+
+// explainAllowed return true if the query of the fingerprint is not explained in the interval, it records the time if allowed
+func explainAllowed(fingerprint string) bool {
+	var (
+		now      = time.Now()
+		interval = time.Duration(slowQueryExplainInterval.Msecs) * time.Millisecond
+	)
+	explainLock.Lock()
+	defer explainLock.Unlock()
+	if last, ok := explainLast[fingerprint]; ok && now.Sub(last) < interval {
+		return false
+	}
+	if len(explainLast) >= 1024 {
+		for k, last := range explainLast {
+			if now.Sub(last) >= interval {
+				delete(explainLast, k)
+			}
+		}
+	}
+	explainLast[fingerprint] = now
+	return true
+}
+
+// explain run EXPLAIN for the query and return the rows of plan
+func explain(ctx context.Context, db *sqlx.DB, query string, args []any) ([]map[string]any, error) {
+	prefix := "EXPLAIN "
+	if Dialect() == sb.SQLite {
+		prefix = "EXPLAIN QUERY PLAN "
+	}
+	rows, err := db.QueryxContext(ctx, prefix+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var plan []map[string]any
+	for rows.Next() {
+		row := make(map[string]any)
+		if err := rows.MapScan(row); err != nil {
+			return nil, err
+		}
+		for k, v := range row {
+			if b, ok := v.([]byte); ok {
+				row[k] = string(b)
+			}
+		}
+		plan = append(plan, row)
+	}
+	return plan, rows.Err()
+}
+
+// caller return the location of the first frame outside ormx
+func caller() string {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.File, sourceDir+"/") || strings.HasSuffix(frame.File, "_test.go") {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
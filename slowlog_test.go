@@ -0,0 +1,90 @@
+package ormx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudfly/ormx/test"
+	"github.com/rs/zerolog"
+)
+
+func captureLog(t *testing.T) *bytes.Buffer {
+	var (
+		buf  = &bytes.Buffer{}
+		prev = log
+	)
+	SetLogger(zerolog.New(buf))
+	t.Cleanup(func() { SetLogger(prev) })
+	return buf
+}
+
+func TestSlowQuery(t *testing.T) {
+	useSQLite(t)
+	var (
+		ctx = context.Background()
+		buf = captureLog(t)
+	)
+	SetSlowQueryThreshold(time.Nanosecond)
+	*slowQueryExplain = true
+	*redactColumns = []string{"producer", "action"}
+	t.Cleanup(func() {
+		SetSlowQueryThreshold(-1)
+		*slowQueryExplain = false
+		*redactColumns = nil
+		explainLast = map[string]time.Time{}
+	})
+
+	var rows []TestRow
+	for i := 0; i < 2; i++ {
+		test.NoError(t, Select(ctx, &rows, "SELECT * FROM test WHERE producer = ? AND id > 10", "secret"))
+		explainWG.Wait()
+	}
+	_, err := Exec(ctx, "UPDATE test SET action = ? WHERE id = ?", "secret", 1)
+	test.NoError(t, err)
+
+	var entries []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		entry := map[string]any{}
+		test.NoError(t, json.Unmarshal([]byte(line), &entry))
+		test.Equal(t, false, strings.Contains(line, "secret"))
+		if entry["message"] != "Slow query" {
+			continue
+		}
+		test.Equal(t, true, strings.Contains(entry["caller"].(string), "slowlog_test.go"))
+		entries = append(entries, entry)
+	}
+	test.Equal(t, 3, len(entries))
+
+	test.Equal(t, "SELECT * FROM test WHERE producer = ? AND id > ?", entries[0]["query"])
+	test.Equal(t, []any{"<redacted>"}, entries[0]["args"])
+	test.Equal(t, false, entries[0]["master"])
+	test.Equal(t, true, entries[0]["plan"] != nil)
+	// the EXPLAIN is rate limited per query
+	test.Equal(t, nil, entries[1]["plan"])
+	test.Equal(t, []any{"<redacted>", float64(1)}, entries[2]["args"])
+	test.Equal(t, nil, entries[2]["plan"])
+}
+
+func TestSlowQueryDisabled(t *testing.T) {
+	useSQLite(t)
+	buf := captureLog(t)
+	var rows []TestRow
+	test.NoError(t, Select(context.Background(), &rows, "SELECT * FROM test"))
+	test.Equal(t, false, strings.Contains(buf.String(), "Slow query"))
+
+	// 0 overrides the flag to disable it, and a negative one restores the flag
+	prev := slowQueryThreshold.Msecs
+	slowQueryThreshold.Msecs = 1
+	t.Cleanup(func() {
+		slowQueryThreshold.Msecs = prev
+		SetSlowQueryThreshold(-1)
+	})
+	SetSlowQueryThreshold(0)
+	test.Equal(t, time.Duration(0), slowThreshold())
+	SetSlowQueryThreshold(-1)
+	test.Equal(t, time.Millisecond, slowThreshold())
+}
@@ -0,0 +1,154 @@
+package sqlparse
+
+import (
+	"strconv"
+	"strings"
+)
+
+// the operators comparing the column on the left with the placeholder on the right
+var comparisons = map[string]bool{
+	"=": true, "<>": true, "!=": true, "<": true, ">": true, "<=": true, ">=": true, "<=>": true,
+	"LIKE": true, "REGEXP": true, "RLIKE": true,
+}
+
+// ArgColumns return the column each bind argument of sql is compared with or assigned to, the element is empty if unknown.
+// The i-th element is for the i-th ? or $i+1 placeholder, the columns are unqualified and lower cased.
+//
+// The columns are recognized in INSERT column list, SET col = ?, col = ?, col IN (?, ?), col BETWEEN ? AND ? and similar patterns.
+func ArgColumns(sql string) []string {
+	var (
+		tokens  = Tokenize(sql)
+		columns []string
+		seq     int
+	)
+	set := func(tok Token, column string) {
+		i := seq
+		if strings.HasPrefix(tok.Value, "$") {
+			n, err := strconv.Atoi(tok.Value[1:])
+			if err != nil || n < 1 {
+				return
+			}
+			i = n - 1
+		} else if tok.Value == "?" {
+			seq++
+		} else {
+			return
+		}
+		for len(columns) <= i {
+			columns = append(columns, "")
+		}
+		if columns[i] == "" {
+			columns[i] = column
+		}
+	}
+
+	insertColumns := insertColumnList(tokens)
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case insertColumns != nil && (t.Is("VALUES") || t.Is("VALUE")):
+			i = assignRows(tokens, i+1, insertColumns, set)
+		case t.Is("IN") && i+1 < len(tokens) && isPunct(tokens[i+1], "("):
+			column := columnBefore(tokens, i)
+			end := closeParen(tokens, i+1)
+			if end < 0 {
+				end = len(tokens) - 1
+			}
+			for j := i + 2; j < end; j++ {
+				if tokens[j].Kind == Placeholder {
+					set(tokens[j], column)
+				}
+			}
+			i = end
+		case t.Is("BETWEEN"):
+			// BETWEEN ? AND ?, the negative sign may precede the placeholders
+			column, start, found := columnBefore(tokens, i), i, 0
+			for j := start + 1; j < len(tokens) && j <= start+5 && found < 2; j++ {
+				if tokens[j].Kind == Placeholder {
+					set(tokens[j], column)
+					i, found = j, found+1
+				}
+			}
+		case t.Kind == Placeholder:
+			column := ""
+			if i >= 2 && comparisons[tokens[i-1].Upper()] {
+				column = columnBefore(tokens, i-1)
+			}
+			set(t, column)
+		}
+	}
+	return columns
+}
+
+// insertColumnList return the lower cased column list of INSERT or REPLACE statement, nil if not found
+func insertColumnList(tokens []Token) []string {
+	if len(tokens) == 0 || !(tokens[0].Is("INSERT") || tokens[0].Is("REPLACE")) {
+		return nil
+	}
+	for i, t := range tokens {
+		if t.Is("VALUES") || t.Is("VALUE") || t.Is("SELECT") || t.Is("SET") {
+			return nil
+		}
+		if !isPunct(t, "(") {
+			continue
+		}
+		end := closeParen(tokens, i)
+		if end < 0 {
+			return nil
+		}
+		var columns []string
+		for _, c := range tokens[i+1 : end] {
+			if isName(c) {
+				columns = append(columns, strings.ToLower(c.Value))
+			}
+		}
+		return columns
+	}
+	return nil
+}
+
+// assignRows assign the columns to the placeholders in the rows of VALUES starting from tokens[start], it returns the index of the last token consumed
+func assignRows(tokens []Token, start int, columns []string, set func(Token, string)) int {
+	i := start
+	for i < len(tokens) && isPunct(tokens[i], "(") {
+		end := closeParen(tokens, i)
+		if end < 0 {
+			return len(tokens) - 1
+		}
+		field, depth := 0, 0
+		for j := i + 1; j < end; j++ {
+			switch t := tokens[j]; {
+			case isPunct(t, "("):
+				depth++
+			case isPunct(t, ")"):
+				depth--
+			case isPunct(t, ",") && depth == 0:
+				field++
+			case t.Kind == Placeholder:
+				column := ""
+				if field < len(columns) {
+					column = columns[field]
+				}
+				set(t, column)
+			}
+		}
+		i = end + 1
+		if i >= len(tokens) || !isPunct(tokens[i], ",") {
+			return end
+		}
+		i++
+	}
+	return i - 1
+}
+
+// columnBefore return the lower cased column name ending at tokens[i-1], NOT before IN, LIKE or BETWEEN is skipped
+func columnBefore(tokens []Token, i int) string {
+	i--
+	if i >= 0 && tokens[i].Is("NOT") {
+		i--
+	}
+	if i < 0 || !isName(tokens[i]) || (tokens[i].Kind == Ident && reserved[tokens[i].Upper()]) {
+		return ""
+	}
+	return strings.ToLower(tokens[i].Value)
+}
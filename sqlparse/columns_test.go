@@ -0,0 +1,27 @@
+package sqlparse
+
+import (
+	"testing"
+
+	"github.com/cloudfly/ormx/test"
+)
+
+func TestArgColumns(t *testing.T) {
+	cases := []struct {
+		sql  string
+		want []string
+	}{
+		{"SELECT * FROM users WHERE name = ? AND u.`Password` = ?", []string{"name", "password"}},
+		{"INSERT INTO `users` (`name`, `password`, created) VALUES (?, ?, NOW()), (?, ?, NOW())", []string{"name", "password", "name", "password"}},
+		{"INSERT INTO users (name, token) VALUES (?, LOWER(?)) ON DUPLICATE KEY UPDATE token = ?", []string{"name", "token", "token"}},
+		{"UPDATE users SET token = ?, updated = NOW() WHERE id IN (?, ?) LIMIT ?", []string{"token", "id", "id", ""}},
+		{"SELECT * FROM t WHERE a NOT IN (?, ?) AND b BETWEEN ? AND ? AND c LIKE ?", []string{"a", "a", "b", "b", "c"}},
+		{"SELECT * FROM t WHERE a BETWEEN ? AND ? LIMIT ?", []string{"a", "a", ""}},
+		{"SELECT * FROM t WHERE a > $2 AND b = $1", []string{"b", "a"}},
+		{"SELECT COUNT(?) FROM t WHERE ? = a", []string{"", ""}},
+		{"SELECT 1", nil},
+	}
+	for _, c := range cases {
+		test.Equal(t, c.want, ArgColumns(c.sql))
+	}
+}
@@ -0,0 +1,232 @@
+// Package sqlparse is a lightweight sql tokenizer, it classifies the statement and extracts the referenced tables without a full grammar.
+package sqlparse
+
+import (
+	"strings"
+)
+
+// Kind is the kind of Token
+type Kind int
+
+const (
+	// Ident is an unquoted identifier or keyword
+	Ident Kind = iota
+	// QuotedIdent is an identifier quoted by backtick or double quote, Value is unquoted
+	QuotedIdent
+	// String is a literal string quoted by single quote, Value is the raw text including quotes
+	String
+	// Number is a literal number
+	Number
+	// Placeholder is the bind parameter, such as ?, $1 and :name
+	Placeholder
+	// Variable is the user or system variable, such as @v and @@version
+	Variable
+	// Punct is the operator or punctuation
+	Punct
+)
+
+// Token is the lexical unit of sql
+type Token struct {
+	Kind  Kind
+	Value string
+}
+
+// Is return true if the token is the unquoted keyword, case insensitive
+func (t Token) Is(keyword string) bool {
+	return t.Kind == Ident && strings.EqualFold(t.Value, keyword)
+}
+
+// Upper return the upper case value of the Ident
+func (t Token) Upper() string {
+	if t.Kind != Ident {
+		return t.Value
+	}
+	return strings.ToUpper(t.Value)
+}
+
+// Dialect is the lexical rules of database
+type Dialect int
+
+const (
+	// MySQL treats # as the start of line comment
+	MySQL Dialect = iota
+	// Standard is the dialect of the other databases such as PostgreSQL and SQLite, # is an operator
+	Standard
+)
+
+// Tokenize split the sql of MySQL into tokens, the whitespaces and comments are dropped
+func Tokenize(sql string) []Token {
+	return TokenizeDialect(sql, MySQL)
+}
+
+// TokenizeDialect split the sql of dialect into tokens, the whitespaces and comments are dropped.
+// The minus sign before a number is folded into the Number when it can't be a binary operator, such as after = or (.
+func TokenizeDialect(sql string, dialect Dialect) []Token {
+	var (
+		tokens = make([]Token, 0, len(sql)/4)
+		i      = 0
+	)
+	for i < len(sql) {
+		c := sql[i]
+		switch {
+		case isSpace(c):
+			i++
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-', c == '#' && dialect == MySQL:
+			// line comment
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+		case c == '-' && isNumberStart(sql, i+1) && isUnary(tokens):
+			j := scanNumber(sql, i+1)
+			tokens = append(tokens, Token{Kind: Number, Value: sql[i:j]})
+			i = j
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				i = len(sql)
+			} else {
+				i += end + 4
+			}
+		case c == '\'':
+			j := scanQuoted(sql, i, '\'')
+			tokens = append(tokens, Token{Kind: String, Value: sql[i:j]})
+			i = j
+		case c == '`' || c == '"':
+			j := scanQuoted(sql, i, c)
+			tokens = append(tokens, Token{Kind: QuotedIdent, Value: unquote(sql[i:j], c)})
+			i = j
+		case isNumberStart(sql, i):
+			j := scanNumber(sql, i)
+			tokens = append(tokens, Token{Kind: Number, Value: sql[i:j]})
+			i = j
+		case c == '?':
+			tokens = append(tokens, Token{Kind: Placeholder, Value: "?"})
+			i++
+		case (c == '$' || c == ':') && i+1 < len(sql) && isIdentChar(sql[i+1]):
+			j := i + 1
+			for j < len(sql) && isIdentChar(sql[j]) {
+				j++
+			}
+			tokens = append(tokens, Token{Kind: Placeholder, Value: sql[i:j]})
+			i = j
+		case c == '@':
+			j := i + 1
+			for j < len(sql) && (sql[j] == '@' || isIdentChar(sql[j])) {
+				j++
+			}
+			tokens = append(tokens, Token{Kind: Variable, Value: sql[i:j]})
+			i = j
+		case isIdentChar(c):
+			j := i
+			for j < len(sql) && (isIdentChar(sql[j]) || sql[j] == '$') {
+				j++
+			}
+			tokens = append(tokens, Token{Kind: Ident, Value: sql[i:j]})
+			i = j
+		default:
+			j := i + 1
+			if j < len(sql) && isOperatorPair(c, sql[j]) {
+				j++
+			}
+			tokens = append(tokens, Token{Kind: Punct, Value: sql[i:j]})
+			i = j
+		}
+	}
+	return tokens
+}
+
+// scanQuoted return the end index of the quoted text started at i, the doubled quote and backslash are escapes
+func scanQuoted(sql string, i int, quote byte) int {
+	for j := i + 1; j < len(sql); j++ {
+		switch sql[j] {
+		case '\\':
+			if quote == '\'' {
+				j++
+			}
+		case quote:
+			if j+1 < len(sql) && sql[j+1] == quote {
+				j++
+				continue
+			}
+			return j + 1
+		}
+	}
+	return len(sql)
+}
+
+func unquote(s string, quote byte) string {
+	s = strings.TrimPrefix(s, string(quote))
+	s = strings.TrimSuffix(s, string(quote))
+	return strings.ReplaceAll(s, string([]byte{quote, quote}), string(quote))
+}
+
+// isNumberStart return true if a number starts at i
+func isNumberStart(sql string, i int) bool {
+	return i < len(sql) && (isDigit(sql[i]) || (sql[i] == '.' && i+1 < len(sql) && isDigit(sql[i+1])))
+}
+
+// isUnary return true if the operator following tokens is unary, that's no operand before it
+func isUnary(tokens []Token) bool {
+	if len(tokens) == 0 {
+		return true
+	}
+	switch last := tokens[len(tokens)-1]; last.Kind {
+	case Punct:
+		return last.Value != ")" && last.Value != "]"
+	case Ident:
+		upper := last.Upper()
+		return reserved[upper] || spaceBeforeParen[upper] || upper == "CASE" || upper == "RETURN"
+	}
+	return false
+}
+
+func scanNumber(sql string, i int) int {
+	j := i
+	if strings.HasPrefix(sql[i:], "0x") || strings.HasPrefix(sql[i:], "0X") {
+		j += 2
+		for j < len(sql) && isHex(sql[j]) {
+			j++
+		}
+		return j
+	}
+	for j < len(sql) && (isDigit(sql[j]) || sql[j] == '.') {
+		j++
+	}
+	if j < len(sql) && (sql[j] == 'e' || sql[j] == 'E') {
+		k := j + 1
+		if k < len(sql) && (sql[k] == '+' || sql[k] == '-') {
+			k++
+		}
+		if k < len(sql) && isDigit(sql[k]) {
+			j = k
+			for j < len(sql) && isDigit(sql[j]) {
+				j++
+			}
+		}
+	}
+	return j
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHex(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func isOperatorPair(a, b byte) bool {
+	switch string([]byte{a, b}) {
+	case "<=", ">=", "<>", "!=", "||", "&&", ":=", "::", "<<", ">>", "->", "#>":
+		return true
+	}
+	return false
+}
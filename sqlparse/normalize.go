@@ -0,0 +1,181 @@
+package sqlparse
+
+import (
+	"encoding/hex"
+	"hash/fnv"
+	"slices"
+	"strings"
+)
+
+// the keywords keep a space before the following parenthesis, other identifiers followed by parenthesis are function calls
+var spaceBeforeParen = map[string]bool{
+	"IN": true, "AND": true, "OR": true, "NOT": true, "EXISTS": true, "ANY": true, "ALL": true, "SOME": true,
+	"WHEN": true, "THEN": true, "ELSE": true, "BY": true, "BETWEEN": true, "IS": true, "LIKE": true,
+}
+
+// Normalize replace the literal strings and numbers in sql by placeholder ?, and rewrite the sql in a canonical spacing without comments.
+// The IN-list of placeholders is collapsed into IN (...), the repeated rows of VALUES are collapsed into the first one.
+//
+// The normalized sql is safe to be logged or reported because the literal values are removed.
+func Normalize(sql string) string {
+	return NormalizeTokens(Tokenize(sql))
+}
+
+// NormalizeTokens is Normalize on the tokens returned by Tokenize, the tokens are not modified
+func NormalizeTokens(tokens []Token) string {
+	return format(normalizeTokens(tokens))
+}
+
+// Fingerprint return the stable fingerprint of sql, the sqls different only in literal values, length of IN-list,
+// rows of VALUES, placeholder style, spacing, comments and case of keywords have the same fingerprint.
+func Fingerprint(sql string) string {
+	return FingerprintTokens(Tokenize(sql))
+}
+
+// FingerprintTokens is Fingerprint on the tokens returned by Tokenize, the tokens are not modified
+func FingerprintTokens(tokens []Token) string {
+	tokens = normalizeTokens(tokens)
+	for i, t := range tokens {
+		switch {
+		case t.Kind == Ident:
+			tokens[i].Value = t.Upper()
+		case t.Kind == Placeholder && t.Value != "...":
+			tokens[i].Value = "?"
+		}
+	}
+	h := fnv.New64a()
+	h.Write([]byte(format(tokens)))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// normalizeTokens replace the literals by placeholder, and collapse the IN-lists and VALUES rows
+func normalizeTokens(tokens []Token) []Token {
+	out := make([]Token, 0, len(tokens))
+	for _, t := range tokens {
+		if t.Kind == String || t.Kind == Number {
+			t = Token{Kind: Placeholder, Value: "?"}
+		}
+		out = append(out, t)
+	}
+	return collapseLists(out)
+}
+
+// collapseLists collapse the IN-lists of placeholders into IN (...), and the repeated rows after VALUES into the first one
+func collapseLists(tokens []Token) []Token {
+	out := tokens[:0]
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		out = append(out, t)
+		if i+1 >= len(tokens) || !isPunct(tokens[i+1], "(") {
+			continue
+		}
+		switch {
+		case t.Is("IN"):
+			end := closeParen(tokens, i+1)
+			if end > 0 && isPlaceholderList(tokens[i+2:end]) {
+				out = append(out, tokens[i+1], Token{Kind: Placeholder, Value: "..."}, tokens[end])
+				i = end
+			}
+		case t.Is("VALUES") || t.Is("VALUE"):
+			end := closeParen(tokens, i+1)
+			if end < 0 {
+				continue
+			}
+			row := tokens[i+1 : end+1]
+			out = append(out, row...)
+			i = end
+			// skip the following rows having the same tokens with the first row
+			for i+1 < len(tokens) && isPunct(tokens[i+1], ",") && i+1+len(row) < len(tokens) && slices.EqualFunc(tokens[i+2:i+2+len(row)], row, sameToken) {
+				i += 1 + len(row)
+			}
+		}
+	}
+	return out
+}
+
+// sameToken return true if a and b are the same, the placeholders of any style are the same
+func sameToken(a, b Token) bool {
+	return a == b || a.Kind == Placeholder && b.Kind == Placeholder
+}
+
+// closeParen return the index of parenthesis closing tokens[open], -1 if not closed
+func closeParen(tokens []Token, open int) int {
+	depth := 0
+	for i := open; i < len(tokens); i++ {
+		if isPunct(tokens[i], "(") {
+			depth++
+		} else if isPunct(tokens[i], ")") {
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// isPlaceholderList return true if tokens is a non-empty list of placeholders separated by comma, the negative sign is allowed
+func isPlaceholderList(tokens []Token) bool {
+	expectValue := true
+	for _, t := range tokens {
+		switch {
+		case expectValue && isPunct(t, "-"):
+		case expectValue && t.Kind == Placeholder:
+			expectValue = false
+		case !expectValue && isPunct(t, ","):
+			expectValue = true
+		default:
+			return false
+		}
+	}
+	return len(tokens) > 0 && !expectValue
+}
+
+// format join the tokens into sql
+func format(tokens []Token) string {
+	var b strings.Builder
+	for i, t := range tokens {
+		if i > 0 && needSpace(tokens, i) {
+			b.WriteByte(' ')
+		}
+		if t.Kind == QuotedIdent {
+			b.WriteByte('`')
+			b.WriteString(strings.ReplaceAll(t.Value, "`", "``"))
+			b.WriteByte('`')
+			continue
+		}
+		b.WriteString(t.Value)
+	}
+	return b.String()
+}
+
+// the keywords followed by table name, the parenthesis after the table name is the column list but not function call
+var beforeTable = map[string]bool{"INTO": true, "TABLE": true, "EXISTS": true, "REFERENCES": true}
+
+// needSpace return true if a space is needed between tokens[i-1] and tokens[i]
+func needSpace(tokens []Token, i int) bool {
+	prev, t := tokens[i-1], tokens[i]
+	if prev.Kind == Punct && (prev.Value == "(" || prev.Value == ".") {
+		return false
+	}
+	if t.Kind == Punct {
+		switch t.Value {
+		case ",", ")", ".", ";":
+			return false
+		case "(":
+			if prev.Kind == Ident {
+				upper := prev.Upper()
+				return reserved[upper] || spaceBeforeParen[upper] || isTableName(tokens, i-1)
+			}
+		}
+	}
+	return true
+}
+
+// isTableName return true if tokens[i] is the last part of a table name
+func isTableName(tokens []Token, i int) bool {
+	for i >= 2 && tokens[i-1].Kind == Punct && tokens[i-1].Value == "." {
+		i -= 2
+	}
+	return i >= 1 && beforeTable[tokens[i-1].Upper()]
+}
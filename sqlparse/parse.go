@@ -0,0 +1,323 @@
+package sqlparse
+
+import (
+	"strings"
+)
+
+// Type is the type of statement, it's the lower case leading verb, such as select, insert, update and delete
+type Type string
+
+const (
+	Select   Type = "select"
+	Insert   Type = "insert"
+	Replace  Type = "replace"
+	Update   Type = "update"
+	Delete   Type = "delete"
+	Create   Type = "create"
+	Alter    Type = "alter"
+	Drop     Type = "drop"
+	Truncate Type = "truncate"
+	Other    Type = "other"
+)
+
+var verbs = map[string]Type{
+	"SELECT":   Select,
+	"INSERT":   Insert,
+	"REPLACE":  Replace,
+	"UPDATE":   Update,
+	"DELETE":   Delete,
+	"CREATE":   Create,
+	"ALTER":    Alter,
+	"DROP":     Drop,
+	"TRUNCATE": Truncate,
+}
+
+// Table is a table referenced by the statement
+type Table struct {
+	// Name is the table name, it's qualified by schema if the sql does, such as db.table
+	Name  string
+	Alias string
+}
+
+// Statement is the parsed result of sql
+type Statement struct {
+	Type Type
+	// Tables are the referenced tables in order of appearance, the CTE names are excluded
+	Tables []Table
+	// CTEs are the names defined by WITH clause
+	CTEs []string
+}
+
+// TableNames return the distinct names of referenced tables
+func (s Statement) TableNames() []string {
+	var names []string
+	seen := make(map[string]struct{}, len(s.Tables))
+	for _, t := range s.Tables {
+		if _, ok := seen[t.Name]; ok {
+			continue
+		}
+		seen[t.Name] = struct{}{}
+		names = append(names, t.Name)
+	}
+	return names
+}
+
+// the keywords end the table reference, they are never aliases
+var reserved = map[string]bool{}
+
+func init() {
+	for _, k := range strings.Fields(`WHERE JOIN INNER LEFT RIGHT FULL CROSS OUTER NATURAL STRAIGHT_JOIN ON USING
+		GROUP ORDER LIMIT HAVING SET VALUES VALUE SELECT UNION EXCEPT INTERSECT FOR WINDOW OFFSET RETURNING
+		LOCK USE FORCE IGNORE PARTITION WITH AS FROM INTO UPDATE DELETE INSERT REPLACE DUPLICATE FETCH TABLESAMPLE
+		TABLE IF`) {
+		reserved[k] = true
+	}
+}
+
+// expecting is what the parser expects for the next token
+type expecting int
+
+const (
+	expectNone expecting = iota
+	expectTable
+	expectAlias
+	expectCTEName
+	expectCTEAs
+	expectCTEBody
+	expectCTENext
+)
+
+type frame struct {
+	expect expecting
+	// list is true if the tables are separated by comma, such as in FROM and UPDATE
+	list bool
+	// tablePos is true if the frame is opened by parenthesis at the table position, such as subquery in FROM
+	tablePos bool
+	// cteBody is true if the frame is opened by parenthesis of CTE definition
+	cteBody bool
+	// query is true if the frame is a statement or subquery, FROM in the other frames is part of function, such as EXTRACT(YEAR FROM t)
+	query bool
+	// noAlias is true if the expected tables have no alias, such as the target of ALTER TABLE t ADD COLUMN c INT
+	noAlias bool
+}
+
+// Parse the sql, it never fails, the unrecognized parts are ignored
+func Parse(sql string) Statement {
+	return ParseTokens(Tokenize(sql))
+}
+
+// ParseTokens parse the tokens returned by Tokenize, so that the tokens can be shared with NormalizeTokens and FingerprintTokens
+func ParseTokens(tokens []Token) Statement {
+	var (
+		stmt    = Statement{}
+		ctes    = map[string]bool{}
+		stack   = []*frame{{query: true}}
+		current = -1 // the index of the table receiving alias, -1 for none
+	)
+	for i := 0; i < len(tokens); i++ {
+		var (
+			tok = tokens[i]
+			f   = stack[len(stack)-1]
+		)
+
+		if stmt.Type == "" && len(stack) == 1 && tok.Kind == Ident {
+			if t, ok := verbs[tok.Upper()]; ok {
+				stmt.Type = t
+			}
+		}
+
+		switch f.expect {
+		case expectTable:
+			if tok.Is("IF") {
+				// IF [NOT] EXISTS
+				for i+1 < len(tokens) && !tokens[i].Is("EXISTS") {
+					i++
+				}
+				continue
+			}
+			f.expect = expectNone
+			if isPunct(tok, "(") {
+				// subquery or parenthesized table list
+				stack = append(stack, &frame{tablePos: true, expect: expectTable, list: true})
+				continue
+			}
+			if isAlias(tok) {
+				name, next := qualifiedName(tokens, i)
+				i = next - 1
+				current = -1
+				if !ctes[name] {
+					stmt.Tables = append(stmt.Tables, Table{Name: name})
+					current = len(stmt.Tables) - 1
+				}
+				f.expect = expectAlias
+				if f.noAlias && !(f.list && i+1 < len(tokens) && isPunct(tokens[i+1], ",")) {
+					// the tokens following the table of DDL are the definitions, only the comma continues the list
+					f.expect, current = expectNone, -1
+				}
+				continue
+			}
+		case expectAlias:
+			f.expect = expectNone
+			if tok.Is("AS") && i+1 < len(tokens) && isName(tokens[i+1]) {
+				i++
+				tok = tokens[i]
+			}
+			if isAlias(tok) {
+				if current >= 0 {
+					stmt.Tables[current].Alias = tok.Value
+				}
+				f.expect = expectAlias
+				continue
+			}
+			if isPunct(tok, ",") && f.list {
+				f.expect = expectTable
+				continue
+			}
+		case expectCTEName:
+			f.expect = expectNone
+			if tok.Is("RECURSIVE") {
+				f.expect = expectCTEName
+				continue
+			}
+			if isName(tok) {
+				ctes[tok.Value] = true
+				stmt.CTEs = append(stmt.CTEs, tok.Value)
+				f.expect = expectCTEAs
+				continue
+			}
+		case expectCTEAs:
+			if isPunct(tok, "(") {
+				// the column list of CTE
+				i = skipParens(tokens, i)
+				continue
+			}
+			f.expect = expectNone
+			if tok.Is("AS") {
+				f.expect = expectCTEBody
+				continue
+			}
+		case expectCTEBody:
+			if isPunct(tok, "(") {
+				f.expect = expectNone
+				stack = append(stack, &frame{cteBody: true})
+				continue
+			}
+			if tok.Kind == Ident {
+				// such as MATERIALIZED in postgres
+				continue
+			}
+			f.expect = expectNone
+		case expectCTENext:
+			f.expect = expectNone
+			if isPunct(tok, ",") {
+				f.expect = expectCTEName
+				continue
+			}
+		}
+
+		switch {
+		case isPunct(tok, "("):
+			stack = append(stack, &frame{})
+		case isPunct(tok, ")"):
+			if len(stack) == 1 {
+				continue
+			}
+			closed := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			parent := stack[len(stack)-1]
+			if closed.tablePos {
+				// the alias of subquery is not recorded
+				current = -1
+				parent.expect = expectAlias
+			} else if closed.cteBody {
+				parent.expect = expectCTENext
+			}
+		case isPunct(tok, ";"):
+			stack = stack[:1]
+			stack[0].expect = expectNone
+			current = -1
+		case tok.Kind == Ident:
+			switch tok.Upper() {
+			case "SELECT":
+				f.query = true
+			case "FROM":
+				if f.query {
+					f.expect, f.list, f.noAlias = expectTable, true, false
+				}
+			case "UPDATE":
+				// not ON DUPLICATE KEY UPDATE or FOR UPDATE
+				if i == 0 || !(tokens[i-1].Is("KEY") || tokens[i-1].Is("FOR")) {
+					f.expect, f.list, f.noAlias = expectTable, true, false
+				}
+			case "JOIN", "STRAIGHT_JOIN", "INTO":
+				f.expect, f.list, f.noAlias = expectTable, false, false
+			case "TRUNCATE":
+				f.expect, f.list, f.noAlias = expectTable, false, true
+			case "TABLE":
+				f.expect, f.list, f.noAlias = expectTable, true, isDDL(stmt.Type)
+			case "USING":
+				// DELETE FROM t1 USING t2, but not JOIN ... USING (col)
+				if i+1 < len(tokens) && !isPunct(tokens[i+1], "(") {
+					f.expect, f.list, f.noAlias = expectTable, true, false
+				}
+			case "WITH":
+				f.expect = expectCTEName
+			}
+		}
+	}
+	if stmt.Type == "" {
+		stmt.Type = Other
+		for _, tok := range tokens {
+			if t, ok := verbs[tok.Upper()]; ok && tok.Kind == Ident {
+				stmt.Type = t
+				break
+			}
+		}
+	}
+	return stmt
+}
+
+// isDDL return true if the statement defines the schema, the tables of it have no alias
+func isDDL(typ Type) bool {
+	return typ == Create || typ == Alter || typ == Drop || typ == Truncate
+}
+
+func isPunct(tok Token, value string) bool {
+	return tok.Kind == Punct && tok.Value == value
+}
+
+func isName(tok Token) bool {
+	return tok.Kind == Ident || tok.Kind == QuotedIdent
+}
+
+// isAlias return true if the token can be a table name or alias
+func isAlias(tok Token) bool {
+	return tok.Kind == QuotedIdent || (tok.Kind == Ident && !reserved[tok.Upper()])
+}
+
+// qualifiedName read the name like schema.table from i, return the name and the index after it
+func qualifiedName(tokens []Token, i int) (string, int) {
+	parts := []string{tokens[i].Value}
+	j := i + 1
+	for j+1 < len(tokens) && isPunct(tokens[j], ".") && isName(tokens[j+1]) {
+		parts = append(parts, tokens[j+1].Value)
+		j += 2
+	}
+	return strings.Join(parts, "."), j
+}
+
+// skipParens return the index of the parenthesis closing the one at i
+func skipParens(tokens []Token, i int) int {
+	depth := 0
+	for ; i < len(tokens); i++ {
+		if isPunct(tokens[i], "(") {
+			depth++
+		} else if isPunct(tokens[i], ")") {
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(tokens) - 1
+}
@@ -0,0 +1,262 @@
+package sqlparse
+
+import (
+	"testing"
+
+	"github.com/cloudfly/ormx/test"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name   string
+		sql    string
+		typ    Type
+		tables []Table
+		ctes   []string
+	}{
+		{
+			name:   "select",
+			sql:    "SELECT id, name FROM users WHERE id = ?",
+			typ:    Select,
+			tables: []Table{{Name: "users"}},
+		},
+		{
+			name:   "lowercase with alias",
+			sql:    "select u.id from users u where u.id in (?, ?)",
+			typ:    Select,
+			tables: []Table{{Name: "users", Alias: "u"}},
+		},
+		{
+			name:   "back quoted and schema qualified",
+			sql:    "SELECT * FROM `db`.`order items` AS `oi` LIMIT 10",
+			typ:    Select,
+			tables: []Table{{Name: "db.order items", Alias: "oi"}},
+		},
+		{
+			name:   "joins",
+			sql:    "SELECT * FROM orders o LEFT JOIN users AS u ON o.user_id = u.id INNER JOIN items i USING (order_id) WHERE o.id = 1",
+			typ:    Select,
+			tables: []Table{{Name: "orders", Alias: "o"}, {Name: "users", Alias: "u"}, {Name: "items", Alias: "i"}},
+		},
+		{
+			name:   "comma join",
+			sql:    "SELECT * FROM a, b AS bb, c WHERE a.id = bb.id",
+			typ:    Select,
+			tables: []Table{{Name: "a"}, {Name: "b", Alias: "bb"}, {Name: "c"}},
+		},
+		{
+			name:   "subquery in from and where",
+			sql:    "SELECT t.n FROM (SELECT COUNT(1) AS n FROM logs WHERE level = 'error') t WHERE t.n > (SELECT MAX(n) FROM stats)",
+			typ:    Select,
+			tables: []Table{{Name: "logs"}, {Name: "stats"}},
+		},
+		{
+			name:   "subquery in from followed by comma join",
+			sql:    "SELECT * FROM (SELECT id FROM a) x, b y",
+			typ:    Select,
+			tables: []Table{{Name: "a"}, {Name: "b", Alias: "y"}},
+		},
+		{
+			name:   "cte",
+			sql:    "WITH recent AS (SELECT * FROM orders WHERE created > ?), top (uid) AS (SELECT user_id FROM recent GROUP BY user_id) SELECT * FROM top JOIN users u ON u.id = top.uid",
+			typ:    Select,
+			tables: []Table{{Name: "orders"}, {Name: "users", Alias: "u"}},
+			ctes:   []string{"recent", "top"},
+		},
+		{
+			name:   "recursive cte",
+			sql:    "WITH RECURSIVE tree AS (SELECT id FROM nodes WHERE parent IS NULL UNION ALL SELECT n.id FROM nodes n JOIN tree ON n.parent = tree.id) DELETE FROM nodes WHERE id IN (SELECT id FROM tree)",
+			typ:    Delete,
+			tables: []Table{{Name: "nodes"}, {Name: "nodes", Alias: "n"}, {Name: "nodes"}},
+			ctes:   []string{"tree"},
+		},
+		{
+			name:   "insert values",
+			sql:    "INSERT INTO `test` (`producer`, `resource`) VALUES (?, ?), (?, ?)",
+			typ:    Insert,
+			tables: []Table{{Name: "test"}},
+		},
+		{
+			name:   "insert ignore select",
+			sql:    "insert ignore into archive select * from events where id < 100",
+			typ:    Insert,
+			tables: []Table{{Name: "archive"}, {Name: "events"}},
+		},
+		{
+			name:   "insert on duplicate key update",
+			sql:    "INSERT INTO counters (k, v) VALUES (?, 1) ON DUPLICATE KEY UPDATE v = v + 1",
+			typ:    Insert,
+			tables: []Table{{Name: "counters"}},
+		},
+		{
+			name:   "replace",
+			sql:    "REPLACE INTO kv VALUES (?, ?)",
+			typ:    Replace,
+			tables: []Table{{Name: "kv"}},
+		},
+		{
+			name:   "update",
+			sql:    "UPDATE test SET action = ? WHERE id = ?",
+			typ:    Update,
+			tables: []Table{{Name: "test"}},
+		},
+		{
+			name:   "multi table update",
+			sql:    "UPDATE orders o JOIN users u ON o.uid = u.id SET o.name = u.name WHERE u.id = ?",
+			typ:    Update,
+			tables: []Table{{Name: "orders", Alias: "o"}, {Name: "users", Alias: "u"}},
+		},
+		{
+			name:   "delete",
+			sql:    "DELETE FROM test WHERE id IN (?)",
+			typ:    Delete,
+			tables: []Table{{Name: "test"}},
+		},
+		{
+			name:   "multi table delete",
+			sql:    "DELETE t1 FROM t1 LEFT JOIN t2 ON t1.id = t2.id WHERE t2.id IS NULL",
+			typ:    Delete,
+			tables: []Table{{Name: "t1"}, {Name: "t2"}},
+		},
+		{
+			name:   "delete using",
+			sql:    "DELETE FROM films USING producers WHERE producer_id = producers.id",
+			typ:    Delete,
+			tables: []Table{{Name: "films"}, {Name: "producers"}},
+		},
+		{
+			name:   "select for update",
+			sql:    "SELECT * FROM accounts WHERE id = ? FOR UPDATE",
+			typ:    Select,
+			tables: []Table{{Name: "accounts"}},
+		},
+		{
+			name:   "function with from",
+			sql:    "SELECT EXTRACT(YEAR FROM created_time), TRIM(LEADING 'x' FROM name) FROM test",
+			typ:    Select,
+			tables: []Table{{Name: "test"}},
+		},
+		{
+			name:   "comments and strings",
+			sql:    "/* FROM fake */ SELECT 'FROM nothing', \"a\" -- FROM fake2\nFROM real_table # FROM fake3",
+			typ:    Select,
+			tables: []Table{{Name: "real_table"}},
+		},
+		{
+			name:   "union in parentheses",
+			sql:    "(SELECT id FROM a) UNION (SELECT id FROM b)",
+			typ:    Select,
+			tables: []Table{{Name: "a"}, {Name: "b"}},
+		},
+		{
+			name:   "create table",
+			sql:    "CREATE TABLE IF NOT EXISTS ormx_migrations (version BIGINT PRIMARY KEY)",
+			typ:    Create,
+			tables: []Table{{Name: "ormx_migrations"}},
+		},
+		{
+			name:   "alter table",
+			sql:    "ALTER TABLE foo ADD COLUMN x int",
+			typ:    Alter,
+			tables: []Table{{Name: "foo"}},
+		},
+		{
+			name:   "drop tables",
+			sql:    "DROP TABLE IF EXISTS a, b CASCADE",
+			typ:    Drop,
+			tables: []Table{{Name: "a"}, {Name: "b"}},
+		},
+		{
+			name:   "create table as select",
+			sql:    "CREATE TABLE archive AS SELECT * FROM logs l WHERE l.id < 10",
+			typ:    Create,
+			tables: []Table{{Name: "archive"}, {Name: "logs", Alias: "l"}},
+		},
+		{
+			name:   "truncate",
+			sql:    "TRUNCATE TABLE logs",
+			typ:    Truncate,
+			tables: []Table{{Name: "logs"}},
+		},
+		{
+			name: "no table",
+			sql:  "SELECT 1",
+			typ:  Select,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			stmt := Parse(c.sql)
+			test.Equal(t, c.typ, stmt.Type)
+			test.Equal(t, c.tables, stmt.Tables)
+			test.Equal(t, c.ctes, stmt.CTEs)
+		})
+	}
+}
+
+func TestTableNames(t *testing.T) {
+	stmt := Parse("SELECT * FROM a JOIN b ON a.id = b.id JOIN a AS a2 ON a2.id = b.id")
+	test.Equal(t, []string{"a", "b"}, stmt.TableNames())
+}
+
+func TestTokenize(t *testing.T) {
+	tokens := Tokenize("SELECT `a``b`, 'it''s', 1.5e3, 0x1F, $1, :name, @@version FROM t WHERE x <> ?")
+	test.Equal(t, []Token{
+		{Kind: Ident, Value: "SELECT"},
+		{Kind: QuotedIdent, Value: "a`b"},
+		{Kind: Punct, Value: ","},
+		{Kind: String, Value: "'it''s'"},
+		{Kind: Punct, Value: ","},
+		{Kind: Number, Value: "1.5e3"},
+		{Kind: Punct, Value: ","},
+		{Kind: Number, Value: "0x1F"},
+		{Kind: Punct, Value: ","},
+		{Kind: Placeholder, Value: "$1"},
+		{Kind: Punct, Value: ","},
+		{Kind: Placeholder, Value: ":name"},
+		{Kind: Punct, Value: ","},
+		{Kind: Variable, Value: "@@version"},
+		{Kind: Ident, Value: "FROM"},
+		{Kind: Ident, Value: "t"},
+		{Kind: Ident, Value: "WHERE"},
+		{Kind: Ident, Value: "x"},
+		{Kind: Punct, Value: "<>"},
+		{Kind: Placeholder, Value: "?"},
+	}, tokens)
+}
+
+func TestTokenizeDialect(t *testing.T) {
+	sql := "SELECT data #> '{a}' FROM t # comment"
+	test.Equal(t, []Token{
+		{Kind: Ident, Value: "SELECT"},
+		{Kind: Ident, Value: "data"},
+	}, TokenizeDialect(sql, MySQL))
+	test.Equal(t, []Token{
+		{Kind: Ident, Value: "SELECT"},
+		{Kind: Ident, Value: "data"},
+		{Kind: Punct, Value: "#>"},
+		{Kind: String, Value: "'{a}'"},
+		{Kind: Ident, Value: "FROM"},
+		{Kind: Ident, Value: "t"},
+		{Kind: Punct, Value: "#"},
+		{Kind: Ident, Value: "comment"},
+	}, TokenizeDialect(sql, Standard))
+	test.Equal(t, Fingerprint("SELECT * FROM t WHERE x = 1"), Fingerprint("SELECT * FROM t WHERE x = -1"))
+}
+
+func TestNormalize(t *testing.T) {
+	cases := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT * FROM test WHERE id = 1", "SELECT * FROM test WHERE id = ?"},
+		{"select  name,\n\tCOUNT(1) from `test` where name = 'it''s' -- comment\n group by name", "select name, COUNT(?) from `test` where name = ? group by name"},
+		{"INSERT INTO `test` (`a`, `b`) VALUES (?, 0x1F), ($1, -2.5e3)", "INSERT INTO `test` (`a`, `b`) VALUES (?, ?)"},
+		{"SELECT a - 1, -b FROM t WHERE x = -1 AND y IN (-2, 3)", "SELECT a - ?, - b FROM t WHERE x = ? AND y IN (...)"},
+		{"CREATE TABLE IF NOT EXISTS db.t (id INT, name VARCHAR(32))", "CREATE TABLE IF NOT EXISTS db.t (id INT, name VARCHAR(?))"},
+		{"SELECT t.id FROM t WHERE t.id IN (1, 2) AND (a = :name OR b IS NULL)", "SELECT t.id FROM t WHERE t.id IN (...) AND (a = :name OR b IS NULL)"},
+	}
+	for _, c := range cases {
+		test.Equal(t, c.want, Normalize(c.sql))
+	}
+}
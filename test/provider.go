@@ -2,16 +2,19 @@ package test
 
 import (
 	"fmt"
+	"sync"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 )
 
 var (
-	db *sqlx.DB
+	db        *sqlx.DB
+	dbErr     error
+	dbConnect sync.Once
 )
 
-func init() {
+func connect() {
 	var (
 		user     = "root"
 		password = "123456"
@@ -19,13 +22,16 @@ func init() {
 		database = "test"
 	)
 	dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8mb4&parseTime=True&loc=Local&timeout=30s&readTimeout=30s&writeTimeout=30s", user, password, addr, database)
-	var err error
-	db, err = sqlx.Connect("mysql", dsn)
-	if err != nil {
-		panic(err)
-	}
+	db, dbErr = sqlx.Connect("mysql", dsn)
+}
+
+// Available return the error of connecting to the test mysql server, nil means the server is ready
+func Available() error {
+	dbConnect.Do(connect)
+	return dbErr
 }
 
 func Provider(master bool) *sqlx.DB {
+	dbConnect.Do(connect)
 	return db
 }
@@ -0,0 +1,38 @@
+package test
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLiteSchema is the sqlite version of test.sql
+const SQLiteSchema = `
+CREATE TABLE test (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  producer varchar(64) NOT NULL,
+  resource varchar(32) NOT NULL,
+  action varchar(32) NOT NULL,
+  message text,
+  created_time timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_time timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`
+
+var sqliteSeq atomic.Int64
+
+// SQLite open a new in-memory sqlite database with the test table created, it's closed after the test finished
+func SQLite(t testing.TB) *sqlx.DB {
+	dsn := fmt.Sprintf("file:ormx_test_%d?mode=memory&cache=shared", sqliteSeq.Add(1))
+	db, err := sqlx.Connect("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec(SQLiteSchema); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
@@ -0,0 +1,111 @@
+package ormx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudfly/flagx"
+	"github.com/go-sql-driver/mysql"
+	sb "github.com/huandu/go-sqlbuilder"
+)
+
+var (
+	timeoutRead  = flagx.NewDuration("database.timeout.read", "0", "the timeout of the reading queries whose context has no deadline; 0 disables it")
+	timeoutWrite = flagx.NewDuration("database.timeout.write", "0", "the timeout of the writing queries whose context has no deadline; 0 disables it")
+	timeoutTx    = flagx.NewDuration("database.timeout.tx", "0", "the timeout of the transactions whose context has no deadline; 0 disables it")
+	timeoutHint  = flagx.NewBool("database.timeout.hint", false, "add the MAX_EXECUTION_TIME hint of the deadline to the SELECT queries on MySQL, so that the server stops the query too")
+)
+
+// the error number of MySQL when the MAX_EXECUTION_TIME exceeded
+const mysqlQueryTimeout = 3024
+
+// TimeoutError is returned when the query or transaction exceeds its deadline, it matches ErrQueryTimeout by errors.Is
+type TimeoutError struct {
+	Operation Operation
+	// Timeout is the default timeout applied by ormx, 0 if the deadline is set by the caller
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Timeout > 0 {
+		return fmt.Sprintf("%s timeout after %s: %v", e.Operation, e.Timeout, e.Err)
+	}
+	return fmt.Sprintf("%s timeout: %v", e.Operation, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrQueryTimeout
+}
+
+type queryTimeoutCtxKey struct{}
+
+// WithQueryTimeout set the timeout of the queries and transactions with ctx if ctx has no deadline, it overrides the flags; 0 disables the timeout
+func WithQueryTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, queryTimeoutCtxKey{}, timeout)
+}
+
+// defaultTimeout return the timeout of the operation, the one set by WithQueryTimeout takes precedence
+func defaultTimeout(ctx context.Context, op Operation) time.Duration {
+	if timeout, ok := ctx.Value(queryTimeoutCtxKey{}).(time.Duration); ok {
+		return timeout
+	}
+	switch op {
+	case OpSelect:
+		return time.Duration(timeoutRead.Msecs) * time.Millisecond
+	case OpTx:
+		return time.Duration(timeoutTx.Msecs) * time.Millisecond
+	}
+	return time.Duration(timeoutWrite.Msecs) * time.Millisecond
+}
+
+// withDefaultTimeout apply the default timeout of the operation to ctx if it has no deadline, the timeout is 0 if not applied
+func withDefaultTimeout(ctx context.Context, op Operation) (context.Context, time.Duration, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, 0, func() {}
+	}
+	timeout := defaultTimeout(ctx, op)
+	if timeout <= 0 {
+		return ctx, 0, func() {}
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, timeout, cancel
+}
+
+// timeoutError wrap err into TimeoutError if it's caused by the deadline of ctx, or the MAX_EXECUTION_TIME of MySQL
+func timeoutError(ctx context.Context, op Operation, timeout time.Duration, err error) error {
+	var mysqlErr *mysql.MySQLError
+	switch {
+	case err == nil, errors.Is(err, ErrQueryTimeout):
+		return err
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(ctx.Err(), context.DeadlineExceeded),
+		errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlQueryTimeout:
+		return &TimeoutError{Operation: op, Timeout: timeout, Err: err}
+	}
+	return err
+}
+
+// executionHint add the MAX_EXECUTION_TIME hint of the deadline of ctx into the SELECT query if database.timeout.hint enabled on MySQL
+func executionHint(ctx context.Context, op Operation, sql string) string {
+	if !*timeoutHint || op != OpSelect || Dialect() != sb.MySQL {
+		return sql
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return sql
+	}
+	trimmed := strings.TrimLeft(sql, " \t\r\n")
+	if len(trimmed) < 6 || !strings.EqualFold(trimmed[:6], "SELECT") || strings.Contains(strings.ToUpper(sql), "MAX_EXECUTION_TIME") {
+		return sql
+	}
+	ms := max(time.Until(deadline).Milliseconds(), 1)
+	return trimmed[:6] + " /*+ MAX_EXECUTION_TIME(" + strconv.FormatInt(ms, 10) + ") */" + trimmed[6:]
+}
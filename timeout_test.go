@@ -0,0 +1,92 @@
+package ormx
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cloudfly/ormx/test"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+func TestQueryTimeout(t *testing.T) {
+	useSQLite(t)
+	var (
+		ctx = WithQueryTimeout(context.Background(), time.Nanosecond)
+		n   int
+	)
+	err := Get(ctx, &n, "SELECT COUNT(*) FROM test")
+	var timeoutErr *TimeoutError
+	test.Equal(t, true, errors.As(err, &timeoutErr))
+	test.Equal(t, OpSelect, timeoutErr.Operation)
+	test.Equal(t, time.Nanosecond, timeoutErr.Timeout)
+	test.Equal(t, true, errors.Is(err, ErrQueryTimeout))
+	test.Equal(t, true, errors.Is(err, context.DeadlineExceeded))
+
+	_, err = Exec(ctx, insertTestRow)
+	test.Equal(t, true, errors.As(err, &timeoutErr))
+	test.Equal(t, OpInsert, timeoutErr.Operation)
+
+	// the deadline of caller takes precedence
+	deadline, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	test.NoError(t, Get(deadline, &n, "SELECT COUNT(*) FROM test"))
+
+	// 0 disables the timeout
+	test.NoError(t, Get(WithQueryTimeout(ctx, 0), &n, "SELECT COUNT(*) FROM test"))
+
+	// no timeout is applied by default
+	for _, op := range []Operation{OpSelect, OpInsert, OpTx} {
+		test.Equal(t, time.Duration(0), defaultTimeout(context.Background(), op))
+	}
+}
+
+func TestTxTimeout(t *testing.T) {
+	useSQLite(t)
+	prev := timeoutTx.Msecs
+	timeoutTx.Msecs = 20
+	t.Cleanup(func() { timeoutTx.Msecs = prev })
+
+	err := RunTxContext(context.Background(), func(ctx context.Context, tx *sqlx.Tx) error {
+		<-ctx.Done()
+		_, err := ExecTx(ctx, tx, insertTestRow)
+		return err
+	})
+	var timeoutErr *TimeoutError
+	test.Equal(t, true, errors.As(err, &timeoutErr))
+	test.Equal(t, OpTx, timeoutErr.Operation)
+	test.Equal(t, 20*time.Millisecond, timeoutErr.Timeout)
+}
+
+func TestTimeoutError(t *testing.T) {
+	ctx := context.Background()
+	test.Equal(t, nil, timeoutError(ctx, OpSelect, 0, nil))
+	err := errors.New("bad connection")
+	test.Equal(t, err, timeoutError(ctx, OpSelect, 0, err))
+
+	err = timeoutError(ctx, OpSelect, 0, &mysql.MySQLError{Number: mysqlQueryTimeout})
+	test.Equal(t, true, errors.Is(err, ErrQueryTimeout))
+	var mysqlErr *mysql.MySQLError
+	test.Equal(t, true, errors.As(err, &mysqlErr))
+	// the timeout error is not wrapped twice
+	test.Equal(t, err, timeoutError(ctx, OpSelect, time.Second, err))
+}
+
+func TestExecutionHint(t *testing.T) {
+	prevHint, prevDialect := *timeoutHint, *dialect
+	*timeoutHint, *dialect = true, "mysql"
+	t.Cleanup(func() { *timeoutHint, *dialect = prevHint, prevDialect })
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	hinted := executionHint(ctx, OpSelect, " select id FROM test")
+	test.Equal(t, true, hinted[:len("select /*+ MAX_EXECUTION_TIME(")] == "select /*+ MAX_EXECUTION_TIME(")
+	test.Equal(t, hinted, executionHint(ctx, OpSelect, hinted))
+	test.Equal(t, "UPDATE test SET count = 1", executionHint(ctx, OpUpdate, "UPDATE test SET count = 1"))
+	test.Equal(t, "SELECT 1", executionHint(context.Background(), OpSelect, "SELECT 1"))
+
+	*dialect = "sqlite3"
+	test.Equal(t, "SELECT 1", executionHint(ctx, OpSelect, "SELECT 1"))
+}
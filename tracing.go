@@ -0,0 +1,116 @@
+package ormx
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"sync/atomic"
+
+	sb "github.com/huandu/go-sqlbuilder"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/cloudfly/ormx"
+
+// the attribute keys of the span, the db.* keys follow the OpenTelemetry semantic conventions
+const (
+	AttrDBSystem    = attribute.Key("db.system")
+	AttrDBStatement = attribute.Key("db.statement")
+	AttrDBOperation = attribute.Key("db.operation")
+	AttrDBTable     = attribute.Key("db.sql.table")
+	AttrRole        = attribute.Key("ormx.role")
+	AttrRows        = attribute.Key("ormx.rows")
+	AttrErrorClass  = attribute.Key("ormx.error.class")
+)
+
+var (
+	tracer atomic.Pointer[trace.Tracer]
+)
+
+// SetTracerProvider set the OpenTelemetry TracerProvider, a span is created for each query and transaction after set.
+// The queries in RunTxContext are the children of the transaction span. Set nil to disable the tracing.
+func SetTracerProvider(tp trace.TracerProvider) {
+	if tp == nil {
+		tracer.Store(nil)
+		return
+	}
+	t := tp.Tracer(tracerName)
+	tracer.Store(&t)
+}
+
+// currentTracer return the tracer set by SetTracerProvider, nil if tracing disabled
+func currentTracer() trace.Tracer {
+	if t := tracer.Load(); t != nil {
+		return *t
+	}
+	return nil
+}
+
+// startTxSpan start the span of transaction, the span is nil if tracing disabled
+func startTxSpan(ctx context.Context) (context.Context, trace.Span) {
+	t := currentTracer()
+	if t == nil {
+		return ctx, nil
+	}
+	return t.Start(ctx, "ormx.tx", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		AttrDBSystem.String(dbSystem(Dialect())),
+		AttrRole.String("master"),
+	))
+}
+
+// startQuerySpan start the span of query, the span is nil if tracing disabled. The statement returns the normalized sql, it's called only if tracing enabled.
+func startQuerySpan(ctx context.Context, statement func() string, op Operation, tables []string, master bool) (context.Context, trace.Span) {
+	t := currentTracer()
+	if t == nil {
+		return ctx, nil
+	}
+	name := strings.ToUpper(string(op))
+	if len(tables) > 0 {
+		name += " " + tables[0]
+	}
+	role := "slave"
+	if master {
+		role = "master"
+	}
+	return t.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		AttrDBSystem.String(dbSystem(Dialect())),
+		AttrDBStatement.String(statement()),
+		AttrDBOperation.String(string(op)),
+		AttrDBTable.StringSlice(tables),
+		AttrRole.String(role),
+	))
+}
+
+// endSpan record the result into span and end it, sql.ErrNoRows is not treated as error
+func endSpan(span trace.Span, rows int64, err error) {
+	if span == nil {
+		return
+	}
+	span.SetAttributes(AttrRows.Int64(rows))
+	if err != nil {
+		span.SetAttributes(AttrErrorClass.String(errorClass(err)))
+		if !errors.Is(err, sql.ErrNoRows) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+	}
+	span.End()
+}
+
+// dbSystem return the db.system attribute value of flavor
+func dbSystem(flavor sb.Flavor) string {
+	switch flavor {
+	case sb.SQLite:
+		return "sqlite"
+	case sb.PostgreSQL:
+		return "postgresql"
+	case sb.SQLServer:
+		return "mssql"
+	case sb.ClickHouse:
+		return "clickhouse"
+	}
+	return "mysql"
+}
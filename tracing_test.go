@@ -0,0 +1,100 @@
+package ormx
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cloudfly/ormx/test"
+	"github.com/jmoiron/sqlx"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { SetTracerProvider(nil) })
+	return recorder
+}
+
+func spanAttrs(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestTracing(t *testing.T) {
+	useSQLite(t)
+	var (
+		ctx      = context.Background()
+		recorder = recordSpans(t)
+	)
+
+	_, err := Exec(ctx, "INSERT INTO test (producer, resource, action, message) VALUES ('unittest', 'tracing', 'test', '')")
+	test.NoError(t, err)
+	var rows []TestRow
+	test.NoError(t, Select(ctx, &rows, "SELECT * FROM test t WHERE t.resource = 'tracing'"))
+
+	spans := recorder.Ended()
+	test.Equal(t, 2, len(spans))
+	test.Equal(t, "INSERT test", spans[0].Name())
+	attrs := spanAttrs(spans[0])
+	test.Equal(t, "sqlite", attrs[AttrDBSystem].AsString())
+	test.Equal(t, "INSERT INTO test (producer, resource, action, message) VALUES (?, ?, ?, ?)", attrs[AttrDBStatement].AsString())
+	test.Equal(t, "master", attrs[AttrRole].AsString())
+	test.Equal(t, int64(1), attrs[AttrRows].AsInt64())
+
+	test.Equal(t, "SELECT test", spans[1].Name())
+	attrs = spanAttrs(spans[1])
+	test.Equal(t, "SELECT * FROM test t WHERE t.resource = ?", attrs[AttrDBStatement].AsString())
+	test.Equal(t, []string{"test"}, attrs[AttrDBTable].AsStringSlice())
+	test.Equal(t, "slave", attrs[AttrRole].AsString())
+	test.Equal(t, int64(1), attrs[AttrRows].AsInt64())
+
+	var row TestRow
+	err = Get(ctx, &row, "SELECT * FROM test WHERE id = ?", 100)
+	test.Equal(t, true, IsNotFound(err))
+	span := recorder.Ended()[2]
+	test.Equal(t, codes.Unset, span.Status().Code)
+	test.Equal(t, "not_found", spanAttrs(span)[AttrErrorClass].AsString())
+}
+
+func TestTracingTx(t *testing.T) {
+	useSQLite(t)
+	var (
+		ctx      = context.Background()
+		recorder = recordSpans(t)
+		boom     = errors.New("boom")
+	)
+
+	err := RunTxContext(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+		if _, err := ExecTx(ctx, tx, "DELETE FROM test WHERE id = ?", 1); err != nil {
+			return err
+		}
+		if _, err := ExecTx(ctx, tx, "UPDATE missing SET a = 1"); err == nil {
+			t.Fatal("expect error on missing table")
+		}
+		return boom
+	})
+	test.Equal(t, boom, err)
+
+	spans := recorder.Ended()
+	test.Equal(t, 3, len(spans))
+	txSpan := spans[2]
+	test.Equal(t, "ormx.tx", txSpan.Name())
+	test.Equal(t, codes.Error, txSpan.Status().Code)
+	for _, span := range spans[:2] {
+		test.Equal(t, txSpan.SpanContext().SpanID(), span.Parent().SpanID())
+		test.Equal(t, txSpan.SpanContext().TraceID(), span.SpanContext().TraceID())
+	}
+	test.Equal(t, "DELETE test", spans[0].Name())
+	test.Equal(t, codes.Unset, spans[0].Status().Code)
+	test.Equal(t, "UPDATE missing", spans[1].Name())
+	test.Equal(t, codes.Error, spans[1].Status().Code)
+	test.Equal(t, 1, len(spans[1].Events()))
+}
@@ -16,56 +16,84 @@ func PatchByID(ctx context.Context, table string, id int64, data any) error {
 
 // PatchByIDTx updates the data by id in the table using a transaction.
 func PatchByIDTx(ctx context.Context, tx *sqlx.Tx, table string, id int64, data any) error {
-	ub, ok := NewUpdateBuilderFromStruct(data, table)
-	if !ok {
-		return nil
+	if table == "" {
+		table = TableName(data)
+	}
+	tables, err := shardTables(ctx, table, data, id)
+	if err != nil {
+		return err
 	}
-	ub = ub.Where(WhereFrom(&ub.Cond, id, nil)...)
-	var (
-		sql  string
-		args []any
-		err  error
-	)
-	sql, args = Build(ctx, ub)
+	for _, physical := range tables {
+		ub, ok := NewUpdateBuilderFromStruct(data, physical)
+		if !ok {
+			return nil
+		}
+		ub = ub.Where(WhereFrom(&ub.Cond, id, nil)...)
+		sql, args := Build(ctx, ub)
 
-	if tx == nil {
-		_, err = Exec(ctx, sql, args...)
-	} else {
-		_, err = ExecTx(ctx, tx, sql, args...)
+		if tx == nil {
+			_, err = Exec(ctx, sql, args...)
+		} else {
+			_, err = ExecTx(ctx, tx, sql, args...)
+		}
+		if err != nil {
+			return err
+		}
 	}
-	return err
+	invalidateRows(ctx, table, id)
+	return nil
 }
 
 // PatchWhere updates the data that match the filter in the table.
 // The filter is used as the condition and can be of type KVs, or struct.
+// An empty filter returns ErrUnsafeWrite unless the context is created by AllowFullTable.
 func PatchWhere(ctx context.Context, table string, data any, filter any) (int64, error) {
 	return PatchWhereTx(ctx, nil, table, data, filter)
 }
 
 // PatchWhereTx updates the data that matchthe filter in the table using a transaction.
 // The filter is used as the condition and can be of type KVs, struct, []int64, int64.
+// An empty filter returns ErrUnsafeWrite unless the context is created by AllowFullTable.
+// The shards of sharded table hit by the filter are updated, and the rows affected are summed.
 func PatchWhereTx(ctx context.Context, tx *sqlx.Tx, table string, data any, filter any) (int64, error) {
-	ub, ok := NewUpdateBuilderFromStruct(data, table)
-	if !ok {
-		return 0, nil
+	if table == "" {
+		table = TableName(data)
 	}
-	ub = ub.Where(WhereFrom(&ub.Cond, filter, nil)...)
-	var (
-		err  error
-		sql  string
-		args []any
-		r    driver.Result
-	)
-	sql, args = Build(ctx, ub)
-	if tx == nil {
-		r, err = Exec(ctx, sql, args...)
-	} else {
-		r, err = ExecTx(ctx, tx, sql, args...)
+	if err := checkWriteFilter(ctx, table, filter); err != nil {
+		return 0, err
 	}
+	tables, err := shardTables(ctx, table, data, filter)
 	if err != nil {
 		return 0, err
 	}
-	return r.RowsAffected()
+	var affected int64
+	for _, physical := range tables {
+		ub, ok := NewUpdateBuilderFromStruct(data, physical)
+		if !ok {
+			return 0, nil
+		}
+		ub = ub.Where(WhereFrom(&ub.Cond, filter, nil)...)
+		var (
+			sql, args = Build(ctx, ub)
+			r         driver.Result
+		)
+		if tx == nil {
+			r, err = Exec(ctx, sql, args...)
+		} else {
+			r, err = ExecTx(ctx, tx, sql, args...)
+		}
+		if err != nil {
+			return 0, err
+		}
+		n, err := r.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		affected += n
+	}
+	ids, _ := filterIDs(filter)
+	invalidateRows(ctx, table, ids...)
+	return affected, nil
 }
 
 // NewUpdateBuilderFromStruct 使用 data 数据定义 update builder
@@ -73,6 +101,7 @@ func NewUpdateBuilderFromStruct(data any, table string) (*sb.UpdateBuilder, bool
 	if table == "" {
 		table = TableName(data)
 	}
+	registerModel(table, data)
 	ub := sb.NewUpdateBuilder().Update(table)
 	v := dereferencedValue(reflect.ValueOf(data))
 	t := dereferencedType(reflect.TypeOf(data))
@@ -115,12 +115,9 @@ func IsNotFound(err error) bool {
 	return errors.Is(err, sql.ErrNoRows)
 }
 
-// IsDuplicate 判断查询错误是否是 未找到错误
+// IsDuplicate 判断查询错误是否是 主键或唯一索引冲突错误
 func IsDuplicate(err error) bool {
-	if err == nil {
-		return false
-	}
-	return strings.Contains(err.Error(), "Error 1062: Duplicate")
+	return errorKind(err) == ErrDuplicateKey
 }
 
 // ParseOptionStr will decode key-value data from a string which format like k1:v1,k2:v2,k3:v3.
@@ -141,6 +138,7 @@ func ParseOptionStr(str string) map[string]string {
 		if str[i] == '\\' && i < len(str)-1 && str[i+1] == ',' {
 			b.WriteByte(',')
 			i++
+			continue
 		}
 		if str[i] == ':' {
 			stage = 'v'
@@ -164,4 +162,5 @@ func ParseOptionStr(str string) map[string]string {
 	return options
 }
 
+// Raw mark expr as the raw sql expression of arg, it is put into sql as it is. Use AllowRaw for the fields and sort of the query helpers.
 var Raw = sb.Raw